@@ -1,55 +1,163 @@
 package main
 
 import (
-	"log"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
 	"os"
+	"runtime"
+	"strconv"
 	"strings"
 
+	"github.com/MakeNowJust/heredoc"
 	"github.com/infrawatch/smart-gateway/internal/pkg/events"
+	"github.com/infrawatch/smart-gateway/internal/pkg/gateway"
 	"github.com/infrawatch/smart-gateway/internal/pkg/metrics"
+	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
 )
 
 var metricType = "metrics"
 var eventType = "events"
+var combinedType = "combined"
 
-func main() {
+//version is set during build, eg. go build -ldflags "-X main.version=1.2.3"
+var version = "devel"
+
+const (
+	runCommand      = "run"
+	validateCommand = "validate-config"
+	versionCommand  = "version"
+)
+
+//command holds parsed command line
+type command struct {
+	name        string
+	configPath  string
+	serviceType string
+	uniqueName  string
+}
+
+func usage(out io.Writer) {
+	fmt.Fprint(out, heredoc.Doc(`
+		Usage: smart_gateway <command> [options]
+
+		Commands:
+		  run              run the gateway (default when no command is given)
+		  validate-config  validate configuration file and exit
+		  version          print version and exit
 
-	serviceType := getServiceType()
-	if serviceType == metricType {
-		metrics.StartMetrics()
-	} else if serviceType == eventType {
-		events.StartEvents()
-	} else {
-		log.Printf("Unknow command line argument 'servicetype' valid values are '%s' or '%s'.", metricType, eventType)
+		Examples:
+		  $go run cmd/main.go run -config smartgateway_config.json -servicetype metrics
+		  $go run cmd/main.go validate-config -config smartgateway_config.json -servicetype combined
+
+		Run "smart_gateway <command> -h" for options of the command.
+	`))
+}
+
+//configUsage prints environment variables usable for overriding options of given service type
+func configUsage(out io.Writer, serviceType string) {
+	switch serviceType {
+	case metricType:
+		saconfig.PrintEnvironmentUsage(out, &saconfig.MetricConfiguration{})
+	case eventType:
+		saconfig.PrintEnvironmentUsage(out, &saconfig.EventConfiguration{})
+	case combinedType:
+		saconfig.PrintEnvironmentUsage(out, &saconfig.CombinedConfiguration{})
+	}
+}
+
+//parseCommand parses command line arguments (without program name). Command line without
+//command (eg. "-servicetype events -config ...") is treated as run command for backward compatibility.
+func parseCommand(args []string, output io.Writer) (*command, error) {
+	cmd := &command{name: runCommand}
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd.name = args[0]
+		args = args[1:]
+	}
+	switch cmd.name {
+	case runCommand, validateCommand:
+	case versionCommand:
+		return cmd, nil
+	case "help":
+		usage(output)
+		return nil, flag.ErrHelp
+	default:
+		usage(output)
+		return nil, fmt.Errorf("unknown command '%s'", cmd.name)
 	}
 
+	flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.StringVar(&cmd.serviceType, "servicetype", metricType, fmt.Sprintf("Service type, one of '%s', '%s' or '%s'.", metricType, eventType, combinedType))
+	flags.StringVar(&cmd.configPath, "config", "", "Path to configuration file.")
+	flags.StringVar(&cmd.uniqueName, "uname", "", "Unique name across application (default \"<servicetype>-<random number>\")")
+	flags.Usage = func() {
+		fmt.Fprintf(output, "Usage: smart_gateway %s [options]\n", cmd.name)
+		flags.PrintDefaults()
+		configUsage(output, cmd.serviceType)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument '%s'", flags.Arg(0))
+	}
+	switch cmd.serviceType {
+	case metricType, eventType, combinedType:
+	default:
+		return nil, fmt.Errorf("invalid service type '%s', valid values are '%s', '%s' or '%s'", cmd.serviceType, metricType, eventType, combinedType)
+	}
+	if cmd.configPath == "" {
+		flags.Usage()
+		return nil, fmt.Errorf("required command line argument 'config' is missing")
+	}
+	if cmd.uniqueName == "" {
+		cmd.uniqueName = cmd.serviceType + "-" + strconv.Itoa(rand.Intn(100))
+	}
+	return cmd, nil
 }
 
-//getServiceType ... checks for servicetype parameter to be either events or metrics.
-func getServiceType() string {
-	lenOfArgs := len(os.Args) - 1
-	var servicetype = metricType
-	for index, arg := range os.Args {
-		pattern := "-servicetype="
-		pposition := strings.Index(arg, pattern)
-		if pposition > -1 {
-			if pposition+len(pattern) < len(arg) {
-				servicetype = arg[pposition+len(pattern):]
-				return servicetype
-			}
-			log.Printf("Command line argument 'servicetype' %s is not valid.", arg)
-			return ""
-		}
-		if arg == "-servicetype" || arg == "--servicetype" {
-			if lenOfArgs >= index+1 {
-				servicetype = os.Args[index+1]
-				return servicetype
-			}
-			log.Printf("Command line argument 'servicetype' %s is not valid.", arg)
-			return ""
+//validateConfig loads and validates configuration file of given service type
+func validateConfig(configPath string, serviceType string) error {
+	var err error
+	switch serviceType {
+	case metricType:
+		_, err = saconfig.LoadMetricConfiguration(configPath)
+	case eventType:
+		_, err = saconfig.LoadEventConfiguration(configPath)
+	case combinedType:
+		_, err = saconfig.LoadCombinedConfiguration(configPath)
+	}
+	return err
+}
+
+func main() {
+	cmd, err := parseCommand(os.Args[1:], os.Stderr)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
+	switch cmd.name {
+	case versionCommand:
+		fmt.Printf("smart_gateway %s (%s)\n", version, runtime.Version())
+	case validateCommand:
+		if err := validateConfig(cmd.configPath, cmd.serviceType); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Configuration file %s is valid\n", cmd.configPath)
+	case runCommand:
+		switch cmd.serviceType {
+		case metricType:
+			metrics.StartMetrics(cmd.configPath, cmd.serviceType, cmd.uniqueName)
+		case eventType:
+			events.StartEvents(cmd.configPath, cmd.serviceType, cmd.uniqueName)
+		case combinedType:
+			gateway.StartCombined(cmd.configPath, cmd.serviceType, cmd.uniqueName)
 		}
 	}
-	log.Printf("Command line argument 'servicetype' is not set, using default '%s' type.", servicetype)
-	return servicetype
 }
@@ -1,28 +1,67 @@
 package main
 
 import (
-	"fmt"
-	"os"
+	"flag"
+	"io/ioutil"
+	"strings"
 	"testing"
 )
 
-func TestServiceTypeExists(t *testing.T) {
-	var serviceType string
-	os.Args = []string{"cmd", "-servicetype=bla"}
-	serviceType = getServiceType()
-	fmt.Println(serviceType)
-	if serviceType != "bla" {
-		t.Error("failed : command line argument 'servicetype' was not found")
+func TestParseCommand(t *testing.T) {
+	cmd, err := parseCommand([]string{"run", "-servicetype", "combined", "-config", "/etc/sg.yaml", "-uname", "sg-1"}, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *cmd != (command{name: runCommand, configPath: "/etc/sg.yaml", serviceType: combinedType, uniqueName: "sg-1"}) {
+		t.Errorf("unexpected command parsed: %#v", cmd)
+	}
+
+	cmd, err = parseCommand([]string{"validate-config", "-config=/etc/sg.json", "-servicetype=events"}, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd.name != validateCommand || cmd.serviceType != eventType || !strings.HasPrefix(cmd.uniqueName, "events-") {
+		t.Errorf("unexpected command parsed: %#v", cmd)
 	}
 
+	cmd, err = parseCommand([]string{"version"}, ioutil.Discard)
+	if err != nil || cmd.name != versionCommand {
+		t.Errorf("failed to parse version command: %#v, %v", cmd, err)
+	}
 }
 
-func TestServiceTypeDoesntExists(t *testing.T) {
-	var serviceType string
-	os.Args = []string{"cmd", "-servicenottype="}
-	serviceType = getServiceType()
-	//if valid not passed it will return defualt
-	if serviceType == "" {
-		t.Error("Failed for known command line argument 'servicenottype'.")
+func TestParseCommandWithoutCommand(t *testing.T) {
+	// command line used before introduction of commands
+	cmd, err := parseCommand([]string{"-servicetype=events", "-config", "/etc/sg.json"}, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd.name != runCommand || cmd.serviceType != eventType || cmd.configPath != "/etc/sg.json" {
+		t.Errorf("unexpected command parsed: %#v", cmd)
+	}
+
+	cmd, err = parseCommand([]string{"-config", "/etc/sg.json"}, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd.serviceType != metricType {
+		t.Errorf("expected default service type '%s', got '%s'", metricType, cmd.serviceType)
+	}
+}
+
+func TestParseCommandErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"start", "-config", "/etc/sg.json"},
+		{"run", "-servicetype", "bla", "-config", "/etc/sg.json"},
+		{"run", "-servicetype", "metrics"},
+		{"run", "-servicenottype=", "-config", "/etc/sg.json"},
+		{"run", "-config", "/etc/sg.json", "extra"},
+	} {
+		if _, err := parseCommand(args, ioutil.Discard); err == nil {
+			t.Errorf("expected error for arguments %v", args)
+		}
+	}
+	if _, err := parseCommand([]string{"run", "-h"}, ioutil.Discard); err != flag.ErrHelp {
+		t.Errorf("expected flag.ErrHelp, got %v", err)
 	}
 }
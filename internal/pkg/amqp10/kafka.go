@@ -0,0 +1,489 @@
+package amqp10
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/logging"
+	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+//default values of Kafka client options
+const (
+	DefaultKafkaGroupID   = "smart-gateway"
+	defaultKafkaTimeout   = 10 * time.Second
+	kafkaFetchRetryPeriod = time.Second
+)
+
+//kafkaErrors limits logging of errors reported by Kafka client to one record per URL and minute
+var kafkaErrors = logging.NewSampler(time.Minute)
+
+//newClientTLSConfig returns TLS configuration of connections to brokers verified by given CA certificate,
+//system CAs are used if caCert is empty. Client certificate is presented in case clientCert is given.
+func newClientTLSConfig(serverName string, caCert string, clientCert string, clientKey string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12}
+	if caCert != "" {
+		ca, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %s", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %s", caCert)
+		}
+	}
+	if clientCert != "" {
+		cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+//NewKafkaTLSConfig returns TLS configuration of connections to Kafka brokers or nil in case TLS is disabled
+func NewKafkaTLSConfig(config saconfig.KafkaConfig) (*tls.Config, error) {
+	if !config.UseTLS {
+		return nil, nil
+	}
+	return newClientTLSConfig(config.TLSServerName, config.TLSCaCert, config.TLSClientCert, config.TLSClientKey)
+}
+
+//NewKafkaSASLMechanism returns SASL mechanism authenticating to Kafka brokers or nil in case SASL is disabled
+func NewKafkaSASLMechanism(config saconfig.KafkaConfig) (sasl.Mechanism, error) {
+	switch config.SASLMechanism {
+	case "":
+		return nil, nil
+	case saconfig.KafkaSASLPlain:
+		return plain.Mechanism{Username: config.SASLUser, Password: config.SASLPass}, nil
+	case saconfig.KafkaSASLScramSHA256:
+		return scram.Mechanism(scram.SHA256, config.SASLUser, config.SASLPass)
+	case saconfig.KafkaSASLScramSHA512:
+		return scram.Mechanism(scram.SHA512, config.SASLUser, config.SASLPass)
+	}
+	return nil, fmt.Errorf("unsupported SASL mechanism '%s'", config.SASLMechanism)
+}
+
+//newKafkaDialer returns dialer of connections to Kafka brokers secured according to given configuration
+func newKafkaDialer(config saconfig.KafkaConfig, clientID string) (*kafka.Dialer, error) {
+	tlsConfig, err := NewKafkaTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	mechanism, err := NewKafkaSASLMechanism(config)
+	if err != nil {
+		return nil, err
+	}
+	return &kafka.Dialer{
+		ClientID:      clientID,
+		Timeout:       defaultKafkaTimeout,
+		DualStack:     true,
+		TLS:           tlsConfig,
+		SASLMechanism: mechanism,
+	}, nil
+}
+
+/************************ KafkaReceiver implementation ************************/
+
+//KafkaReader fetches messages of consumer group and commits their offsets, it is implemented by kafka.Reader
+type KafkaReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+//KafkaReceiver consumes messages of single Kafka topic as member of consumer group. Offset of each
+//message is committed once the message is processed, so messages which were received, but not processed
+//before the gateway ended, are consumed again by the group. Offsets are committed by goroutine of the
+//receiver, so slow brokers do not block processing of messages.
+type KafkaReceiver struct {
+	urlStr          string
+	notifier        chan string
+	status          chan int
+	stop            chan bool
+	stopOnce        sync.Once
+	draining        chan bool
+	drainOnce       sync.Once
+	lock            sync.Mutex
+	pending         []kafka.Message //messages passed to notifier channel which were not processed yet
+	processed       []kafka.Message //processed messages which were not committed yet
+	commitSignal    chan bool
+	committerDone   chan bool
+	reader          KafkaReader
+	dialer          *kafka.Dialer
+	brokers         []string
+	logger          *logging.Logger
+	stats           *ConnectionStats
+	reconnect       bool
+	collectinterval float64
+}
+
+//NewKafkaReceiver creates receiver consuming topic of given Kafka URL, eg. kafka://broker-0:9092/collectd-telemetry
+func NewKafkaReceiver(urlStr string, prefetch int, config saconfig.KafkaConfig, amqpHandler *AMQPHandler, uniqueName string) *KafkaReceiver {
+	return newKafkaReceiver(urlStr, "", prefetch, config, amqpHandler, uniqueName, false)
+}
+
+//NewKafkaReceiverWithReader creates receiver of given URL passing messages fetched by given reader instead
+//of consumer group reader connected to brokers of the URL
+func NewKafkaReceiverWithReader(urlStr string, reader KafkaReader) *KafkaReceiver {
+	receiver := initKafkaReceiver(urlStr, "", nil, false)
+	receiver.reader = reader
+
+	go receiver.start()
+	go receiver.commitProcessed()
+
+	return receiver
+}
+
+//initKafkaReceiver returns KafkaReceiver of given data source without reader
+func initKafkaReceiver(urlStr string, dataSource string, amqpHandler *AMQPHandler, reconnect bool) *KafkaReceiver {
+	receiver := &KafkaReceiver{
+		urlStr:          urlStr,
+		reconnect:       reconnect,
+		notifier:        make(chan string),
+		status:          make(chan int),
+		stop:            make(chan bool),
+		draining:        make(chan bool),
+		commitSignal:    make(chan bool, 1),
+		committerDone:   make(chan bool),
+		collectinterval: 30,
+		logger:          logger.With("url", logging.RedactURL(urlStr)),
+	}
+	if dataSource != "" {
+		receiver.logger = receiver.logger.With("datasource", dataSource)
+	}
+	if amqpHandler != nil {
+		receiver.stats = amqpHandler.GetConnectionStats(urlStr, dataSource)
+	}
+	return receiver
+}
+
+//newKafkaReceiver creates KafkaReceiver receiving data of given data source. Statistics of the connection
+//are collected by given handler in case it is not nil. Unreachable brokers are fatal unless reconnect
+//is true, in which case they are reported as lost connection and connecting is retried.
+func newKafkaReceiver(urlStr string, dataSource string, prefetch int, config saconfig.KafkaConfig, amqpHandler *AMQPHandler, uniqueName string, reconnect bool) *KafkaReceiver {
+	receiver := initKafkaReceiver(urlStr, dataSource, amqpHandler, reconnect)
+	brokers, topic, err := saconfig.ParseKafkaURL(urlStr)
+	if err != nil {
+		receiver.logger.Fatal("Invalid Kafka URL", "error", err)
+	}
+	dialer, err := newKafkaDialer(config, uniqueName)
+	if err != nil {
+		receiver.logger.Fatal("Invalid Kafka client configuration", "error", err)
+	}
+	groupID := config.GroupID
+	if groupID == "" {
+		groupID = DefaultKafkaGroupID
+	}
+	startOffset := kafka.LastOffset
+	if config.StartOffset == "earliest" {
+		startOffset = kafka.FirstOffset
+	}
+	receiver.brokers, receiver.dialer = brokers, dialer
+	receiver.reader = kafka.NewReader(kafka.ReaderConfig{
+		Brokers:        brokers,
+		GroupID:        groupID,
+		Topic:          topic,
+		Dialer:         dialer,
+		QueueCapacity:  prefetch,
+		StartOffset:    startOffset,
+		CommitInterval: time.Duration(config.CommitInterval) * time.Second,
+		ErrorLogger: kafka.LoggerFunc(func(format string, args ...interface{}) {
+			if ok, suppressed := kafkaErrors.Allow(urlStr); ok {
+				receiver.logger.Warn("Kafka consumer error", "error", fmt.Sprintf(format, args...), "suppressed", suppressed)
+			}
+		}),
+	})
+
+	go receiver.start()
+	go receiver.commitProcessed()
+
+	return receiver
+}
+
+//GetURL returns URL of consumed topic
+func (kr *KafkaReceiver) GetURL() string {
+	return kr.urlStr
+}
+
+//GetStats returns statistics of the connection or nil in case the receiver was created without handler
+func (kr *KafkaReceiver) GetStats() *ConnectionStats {
+	return kr.stats
+}
+
+//GetNotifier returns channel receiving message bodies
+func (kr *KafkaReceiver) GetNotifier() chan string {
+	return kr.notifier
+}
+
+//GetStatus returns channel receiving status of the connection
+func (kr *KafkaReceiver) GetStatus() chan int {
+	return kr.status
+}
+
+//UpdateMinCollectInterval ...
+func (kr *KafkaReceiver) UpdateMinCollectInterval(interval float64) {
+	if interval < kr.collectinterval {
+		kr.collectinterval = interval
+	}
+}
+
+//Processed marks the oldest message passed to notifier channel as processed and hands its offset
+//to the committing goroutine without waiting for the commit
+func (kr *KafkaReceiver) Processed() {
+	kr.lock.Lock()
+	if len(kr.pending) == 0 {
+		kr.lock.Unlock()
+		return
+	}
+	kr.processed = append(kr.processed, kr.pending[0])
+	kr.pending = kr.pending[1:]
+	kr.lock.Unlock()
+
+	select {
+	case kr.commitSignal <- true:
+	default:
+		// committing goroutine was already signalled
+	}
+}
+
+//commitProcessed commits offsets of processed messages until the receiver is stopped, offsets
+//processed before stopping are committed too. Offsets are committed periodically by the reader
+//in case CommitInterval is configured, otherwise the commits are synchronous.
+func (kr *KafkaReceiver) commitProcessed() {
+	defer close(kr.committerDone)
+	for {
+		select {
+		case <-kr.commitSignal:
+			kr.commit()
+		case <-kr.stop:
+			kr.commit()
+			return
+		}
+	}
+}
+
+//commit commits offsets of processed messages at once
+func (kr *KafkaReceiver) commit() {
+	kr.lock.Lock()
+	msgs := kr.processed
+	kr.processed = nil
+	kr.lock.Unlock()
+	if len(msgs) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultKafkaTimeout)
+	defer cancel()
+	if err := kr.reader.CommitMessages(ctx, msgs...); err != nil {
+		last := msgs[len(msgs)-1]
+		kr.logger.Error("Failed to commit offsets", "count", len(msgs), "partition", last.Partition, "offset", last.Offset, "error", err)
+	}
+}
+
+//Drain stops fetching of new messages. Message which was fetched, but not yet passed to notifier
+//channel, is left uncommitted and notifier channel is closed.
+func (kr *KafkaReceiver) Drain() {
+	kr.drainOnce.Do(func() { close(kr.draining) })
+}
+
+//Stop stops fetching of messages and leaves the consumer group. Offsets of processed messages
+//which were not committed yet are committed before.
+func (kr *KafkaReceiver) Stop() {
+	kr.stopOnce.Do(func() {
+		close(kr.stop)
+		<-kr.committerDone
+		if err := kr.reader.Close(); err != nil {
+			kr.logger.Warn("Failed to close Kafka consumer", "error", err)
+		}
+		kr.logger.Debug("Closed Kafka consumer")
+	})
+}
+
+//connect checks that at least one of the brokers is reachable
+func (kr *KafkaReceiver) connect(ctx context.Context) error {
+	var err error
+	for _, broker := range kr.brokers {
+		var conn *kafka.Conn
+		if conn, err = kr.dialer.DialContext(ctx, "tcp", broker); err == nil {
+			conn.Close()
+			return nil
+		}
+		kr.logger.Debug("Failed to connect to Kafka broker", "broker", broker, "error", err)
+	}
+	return err
+}
+
+//start fetches messages and passes them to notifier channel until the receiver is stopped or drained
+func (kr *KafkaReceiver) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-kr.stop:
+		case <-kr.draining:
+		}
+		cancel()
+	}()
+
+	// setStatus reports status of the connection, returns false if receiver was stopped meanwhile
+	setStatus := func(status int) bool {
+		select {
+		case kr.status <- status:
+			return true
+		case <-kr.stop:
+			return false
+		}
+	}
+
+	err := kr.connect(ctx)
+	for reported := false; err != nil && ctx.Err() == nil; err = kr.connect(ctx) {
+		if !kr.reconnect {
+			kr.logger.Fatal("Could not connect to Kafka brokers, are they running?", "error", err)
+		}
+		kr.logger.Error("Could not connect to Kafka brokers, retrying", "error", err)
+		if !reported {
+			if reported = true; !setStatus(0) {
+				return
+			}
+		}
+		select {
+		case <-time.After(connectRetryPeriod):
+		case <-ctx.Done():
+		}
+	}
+	connected := err == nil
+	if connected && !setStatus(1) {
+		return
+	}
+	for ctx.Err() == nil {
+		msg, err := kr.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			kr.logger.Error("Failed to fetch message", "error", err)
+			if connected {
+				// report lost connection
+				if connected = false; !setStatus(0) {
+					return
+				}
+			}
+			select {
+			case <-time.After(kafkaFetchRetryPeriod):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		if !connected {
+			if kr.stats != nil {
+				kr.stats.IncReconnects()
+			}
+			if connected = true; !setStatus(1) {
+				return
+			}
+		}
+
+		body := string(msg.Value)
+		if kr.stats != nil {
+			kr.stats.IncReceived(len(body))
+		}
+		if kr.logger.Enabled(logging.LevelDebug) {
+			kr.logger.Debug("Message received", "partition", msg.Partition, "offset", msg.Offset, "size", len(body), "body", logging.Truncate(body))
+		}
+		kr.lock.Lock()
+		kr.pending = append(kr.pending, msg)
+		kr.lock.Unlock()
+		select {
+		case kr.notifier <- body:
+		case <-ctx.Done():
+			// message will be consumed again by the group
+			kr.lock.Lock()
+			kr.pending = kr.pending[:len(kr.pending)-1]
+			kr.lock.Unlock()
+		}
+	}
+	select {
+	case <-kr.stop:
+		kr.logger.Debug("Stop received")
+	default:
+		kr.logger.Info("Consumer drained")
+		close(kr.notifier)
+	}
+}
+
+/************************* KafkaSender implementation *************************/
+
+//KafkaSender produces messages to single Kafka topic
+type KafkaSender struct {
+	urlStr string
+	writer *kafka.Writer
+}
+
+//NewKafkaSender creates sender producing messages to topic of given Kafka URL
+func NewKafkaSender(urlStr string, config saconfig.KafkaConfig, uniqueName string) (*KafkaSender, error) {
+	brokers, topic, err := saconfig.ParseKafkaURL(urlStr)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := NewKafkaTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	mechanism, err := NewKafkaSASLMechanism(config)
+	if err != nil {
+		return nil, err
+	}
+	return &KafkaSender{
+		urlStr: urlStr,
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			RequiredAcks: kafka.RequireAll,
+			BatchTimeout: 10 * time.Millisecond,
+			Transport: &kafka.Transport{
+				ClientID:    uniqueName,
+				DialTimeout: defaultKafkaTimeout,
+				TLS:         tlsConfig,
+				SASL:        mechanism,
+			},
+		},
+	}, nil
+}
+
+//SendSync sends given messages and waits until all in-sync replicas of the topic acknowledge them.
+//Returns error in case the messages are not acknowledged within given timeout.
+func (ks *KafkaSender) SendSync(bodies []string, timeout time.Duration) error {
+	msgs := make([]kafka.Message, 0, len(bodies))
+	for _, body := range bodies {
+		if logger.Enabled(logging.LevelDebug) {
+			logger.Debug("Sending message", "url", logging.RedactURL(ks.urlStr), "body", logging.Truncate(body))
+		}
+		msgs = append(msgs, kafka.Message{Value: []byte(body)})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := ks.writer.WriteMessages(ctx, msgs...); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("messages were not acknowledged by %s within %s", ks.urlStr, timeout)
+		}
+		return fmt.Errorf("failed to send %d messages to %s: %s", len(msgs), ks.urlStr, err)
+	}
+	return nil
+}
+
+//Close flushes pending messages and closes connections to brokers
+func (ks *KafkaSender) Close() error {
+	return ks.writer.Close()
+}
@@ -0,0 +1,219 @@
+package amqp10
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/logging"
+)
+
+//LoopbackScheme is scheme of connection and publish URLs served in memory of the process. Messages sent
+//to loopback://<address> are queued until any receiver of the same URL takes them. It is meant for tests
+//running the pipelines without a message bus.
+const LoopbackScheme = "loopback"
+
+//loopbackQueueSize is count of messages which can wait in each loopback queue
+const loopbackQueueSize = 1000
+
+//loopbackQueues holds queues of all loopback URLs used in the process
+var loopbackQueues = struct {
+	sync.Mutex
+	queues map[string]chan string
+}{queues: make(map[string]chan string)}
+
+//IsLoopbackURL returns true in case given connection or publish URL is served in memory
+func IsLoopbackURL(value string) bool {
+	return strings.HasPrefix(value, LoopbackScheme+"://")
+}
+
+//loopbackQueue returns queue of given loopback URL, the queue is created on first use
+func loopbackQueue(urlStr string) chan string {
+	loopbackQueues.Lock()
+	defer loopbackQueues.Unlock()
+	queue, ok := loopbackQueues.queues[urlStr]
+	if !ok {
+		queue = make(chan string, loopbackQueueSize)
+		loopbackQueues.queues[urlStr] = queue
+	}
+	return queue
+}
+
+/********************** LoopbackReceiver implementation ***********************/
+
+//LoopbackReceiver receives messages sent to its loopback URL. Receivers of the same URL share its queue,
+//so each message is received just once. Message taken from the queue, but not passed to notifier channel
+//before the receiver is drained or stopped, is returned to the queue.
+type LoopbackReceiver struct {
+	urlStr          string
+	queue           chan string
+	notifier        chan string
+	status          chan int
+	stop            chan bool
+	stopOnce        sync.Once
+	draining        chan bool
+	drainOnce       sync.Once
+	processed       atomic.Uint64
+	logger          *logging.Logger
+	stats           *ConnectionStats
+	collectinterval float64
+}
+
+//NewLoopbackReceiver creates receiver of messages sent to given loopback URL
+func NewLoopbackReceiver(urlStr string, amqpHandler *AMQPHandler) *LoopbackReceiver {
+	return newLoopbackReceiver(urlStr, "", amqpHandler)
+}
+
+//newLoopbackReceiver creates LoopbackReceiver receiving data of given data source. Statistics of
+//the connection are collected by given handler in case it is not nil.
+func newLoopbackReceiver(urlStr string, dataSource string, amqpHandler *AMQPHandler) *LoopbackReceiver {
+	receiver := &LoopbackReceiver{
+		urlStr:          urlStr,
+		queue:           loopbackQueue(urlStr),
+		notifier:        make(chan string),
+		status:          make(chan int),
+		stop:            make(chan bool),
+		draining:        make(chan bool),
+		collectinterval: 30,
+		logger:          logger.With("url", logging.RedactURL(urlStr)),
+	}
+	if dataSource != "" {
+		receiver.logger = receiver.logger.With("datasource", dataSource)
+	}
+	if amqpHandler != nil {
+		receiver.stats = amqpHandler.GetConnectionStats(urlStr, dataSource)
+	}
+
+	go receiver.start()
+
+	return receiver
+}
+
+//GetURL returns loopback URL of the receiver
+func (lr *LoopbackReceiver) GetURL() string {
+	return lr.urlStr
+}
+
+//GetStats returns statistics of the connection or nil in case the receiver was created without handler
+func (lr *LoopbackReceiver) GetStats() *ConnectionStats {
+	return lr.stats
+}
+
+//GetNotifier returns channel receiving message bodies
+func (lr *LoopbackReceiver) GetNotifier() chan string {
+	return lr.notifier
+}
+
+//GetStatus returns channel receiving status of the connection, which is reported as established on start
+func (lr *LoopbackReceiver) GetStatus() chan int {
+	return lr.status
+}
+
+//UpdateMinCollectInterval ...
+func (lr *LoopbackReceiver) UpdateMinCollectInterval(interval float64) {
+	if interval < lr.collectinterval {
+		lr.collectinterval = interval
+	}
+}
+
+//Processed counts processed message
+func (lr *LoopbackReceiver) Processed() {
+	lr.processed.Add(1)
+}
+
+//GetProcessed returns count of messages whose processing was acknowledged
+func (lr *LoopbackReceiver) GetProcessed() uint64 {
+	return lr.processed.Load()
+}
+
+//Drain stops receiving of new messages and closes notifier channel
+func (lr *LoopbackReceiver) Drain() {
+	lr.drainOnce.Do(func() { close(lr.draining) })
+}
+
+//Stop stops receiving of new messages
+func (lr *LoopbackReceiver) Stop() {
+	lr.stopOnce.Do(func() { close(lr.stop) })
+}
+
+//requeue returns given message to the queue, message is dropped in case the queue is full
+func (lr *LoopbackReceiver) requeue(body string) {
+	select {
+	case lr.queue <- body:
+	default:
+		lr.logger.Warn("Loopback queue is full, dropping message")
+	}
+}
+
+//start passes messages from the queue to notifier channel until the receiver is stopped or drained
+func (lr *LoopbackReceiver) start() {
+	select {
+	case lr.status <- 1:
+	case <-lr.stop:
+		return
+	case <-lr.draining:
+		close(lr.notifier)
+		return
+	}
+	for {
+		select {
+		case body := <-lr.queue:
+			if lr.stats != nil {
+				lr.stats.IncReceived(len(body))
+			}
+			if lr.logger.Enabled(logging.LevelDebug) {
+				lr.logger.Debug("Message received", "size", len(body), "body", logging.Truncate(body))
+			}
+			select {
+			case lr.notifier <- body:
+			case <-lr.stop:
+				lr.requeue(body)
+				return
+			case <-lr.draining:
+				lr.requeue(body)
+				close(lr.notifier)
+				return
+			}
+		case <-lr.stop:
+			lr.logger.Debug("Stop received")
+			return
+		case <-lr.draining:
+			lr.logger.Debug("Drain received")
+			close(lr.notifier)
+			return
+		}
+	}
+}
+
+/************************ LoopbackSender implementation ***********************/
+
+//LoopbackSender queues messages for receivers of its loopback URL
+type LoopbackSender struct {
+	urlStr string
+	queue  chan string
+}
+
+//NewLoopbackSender creates sender of messages to given loopback URL
+func NewLoopbackSender(urlStr string) *LoopbackSender {
+	return &LoopbackSender{urlStr: urlStr, queue: loopbackQueue(urlStr)}
+}
+
+//SendSync queues given messages. Returns error in case the queue stays full for given timeout,
+//remaining messages are not sent then.
+func (ls *LoopbackSender) SendSync(bodies []string, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for i, body := range bodies {
+		if logger.Enabled(logging.LevelDebug) {
+			logger.Debug("Sending message", "url", ls.urlStr, "body", logging.Truncate(body))
+		}
+		select {
+		case ls.queue <- body:
+		case <-timer.C:
+			return fmt.Errorf("message %d/%d was not queued to %s within %s", i+1, len(bodies), ls.urlStr, timeout)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,352 @@
+package amqp10
+
+import (
+	"crypto/tls"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/infrawatch/smart-gateway/internal/pkg/logging"
+	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
+)
+
+//default values of MQTT client options
+const (
+	defaultMQTTKeepAlive        = 30 * time.Second
+	defaultMQTTTimeout          = 10 * time.Second
+	mqttMaxReconnectInterval    = time.Minute
+	mqttDisconnectQuiesceMillis = 250
+)
+
+//NewMQTTTLSConfig returns TLS configuration of connections to MQTT brokers or nil in case TLS is disabled
+func NewMQTTTLSConfig(config saconfig.MQTTConfig) (*tls.Config, error) {
+	if !config.UseTLS {
+		return nil, nil
+	}
+	return newClientTLSConfig(config.TLSServerName, config.TLSCaCert, config.TLSClientCert, config.TLSClientKey)
+}
+
+//MQTTClientID returns client identifier of connection to given URL receiving data of given data source.
+//The identifier does not change between restarts, so broker can resume persistent session of the connection.
+func MQTTClientID(config saconfig.MQTTConfig, urlStr string, dataSource string, uniqueName string) string {
+	prefix := config.ClientID
+	if prefix == "" {
+		prefix = uniqueName
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(dataSource + "|" + urlStr))
+	return fmt.Sprintf("%s-%08x", prefix, hash.Sum32())
+}
+
+/************************* MQTTReceiver implementation ************************/
+
+//pendingMQTTMessage is message passed to notifier channel which was not processed yet
+type pendingMQTTMessage struct {
+	msg        mqtt.Message
+	connection uint64 //count of connections lost before the message was received
+}
+
+//MQTTReceiver receives messages published to topics matching topic filter of single MQTT connection.
+//The client speaks MQTT 3.1.1, which is supported by MQTT 5 brokers too. Messages of QoS 1 and 2 are
+//acknowledged once they are processed, so in case of persistent session the broker redelivers messages
+//which were received, but not processed before the receiver was drained or stopped. Messages received
+//over connection which was lost meanwhile are not acknowledged, the broker redelivers them as well.
+type MQTTReceiver struct {
+	urlStr          string
+	topic           string
+	qos             byte
+	notifier        chan string
+	notifierLock    sync.RWMutex //guards closing of notifier channel against handlers passing messages
+	notifierClosed  bool
+	status          chan int
+	statusLock      sync.Mutex
+	connected       bool
+	pendingLock     sync.Mutex
+	pending         []pendingMQTTMessage
+	lostCount       uint64
+	stop            chan bool
+	stopOnce        sync.Once
+	draining        chan bool
+	drainOnce       sync.Once
+	client          mqtt.Client
+	logger          *logging.Logger
+	stats           *ConnectionStats
+	reconnect       bool
+	collectinterval float64
+}
+
+//NewMQTTReceiver creates receiver subscribed to topic filter of given MQTT URL, eg. mqtt://broker:1883/edge/+/collectd
+func NewMQTTReceiver(urlStr string, config saconfig.MQTTConfig, amqpHandler *AMQPHandler, uniqueName string) *MQTTReceiver {
+	return newMQTTReceiver(urlStr, "", config, amqpHandler, uniqueName, false)
+}
+
+//newMQTTReceiver creates MQTTReceiver receiving data of given data source. Statistics of the connection
+//are collected by given handler in case it is not nil. Unreachable broker is fatal unless reconnect
+//is true, in which case it is reported as lost connection and connecting is retried.
+func newMQTTReceiver(urlStr string, dataSource string, config saconfig.MQTTConfig, amqpHandler *AMQPHandler, uniqueName string, reconnect bool) *MQTTReceiver {
+	receiver := &MQTTReceiver{
+		urlStr:          urlStr,
+		reconnect:       reconnect,
+		qos:             byte(config.QoS),
+		notifier:        make(chan string),
+		status:          make(chan int),
+		stop:            make(chan bool),
+		draining:        make(chan bool),
+		collectinterval: 30,
+		logger:          logger.With("url", logging.RedactURL(urlStr)),
+	}
+	if dataSource != "" {
+		receiver.logger = receiver.logger.With("datasource", dataSource)
+	}
+	if amqpHandler != nil {
+		receiver.stats = amqpHandler.GetConnectionStats(urlStr, dataSource)
+	}
+
+	broker, topic, err := saconfig.ParseMQTTURL(urlStr)
+	if err != nil {
+		receiver.logger.Fatal("Invalid MQTT URL", "error", err)
+	}
+	tlsConfig, err := NewMQTTTLSConfig(config)
+	if err != nil {
+		receiver.logger.Fatal("Invalid MQTT client configuration", "error", err)
+	}
+	receiver.topic = topic
+	keepAlive := defaultMQTTKeepAlive
+	if config.KeepAlive > 0 {
+		keepAlive = time.Duration(config.KeepAlive) * time.Second
+	}
+	scheme := "tcp"
+	if tlsConfig != nil {
+		scheme = "ssl"
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(fmt.Sprintf("%s://%s", scheme, broker)).
+		SetClientID(MQTTClientID(config, urlStr, dataSource, uniqueName)).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetTLSConfig(tlsConfig).
+		SetCleanSession(!config.PersistentSession).
+		SetKeepAlive(keepAlive).
+		SetConnectTimeout(defaultMQTTTimeout).
+		SetAutoReconnect(true).
+		SetMaxReconnectInterval(mqttMaxReconnectInterval).
+		SetOrderMatters(true).
+		SetAutoAckDisabled(true).
+		SetOnConnectHandler(receiver.onConnect).
+		SetConnectionLostHandler(receiver.onConnectionLost).
+		SetReconnectingHandler(func(mqtt.Client, *mqtt.ClientOptions) {
+			if receiver.stats != nil {
+				receiver.stats.IncReconnects()
+			}
+		})
+	receiver.client = mqtt.NewClient(opts)
+
+	go receiver.start()
+
+	return receiver
+}
+
+//GetURL returns URL of the connection
+func (mr *MQTTReceiver) GetURL() string {
+	return mr.urlStr
+}
+
+//GetStats returns statistics of the connection or nil in case the receiver was created without handler
+func (mr *MQTTReceiver) GetStats() *ConnectionStats {
+	return mr.stats
+}
+
+//GetNotifier returns channel receiving message bodies
+func (mr *MQTTReceiver) GetNotifier() chan string {
+	return mr.notifier
+}
+
+//GetStatus returns channel receiving status of the connection
+func (mr *MQTTReceiver) GetStatus() chan int {
+	return mr.status
+}
+
+//UpdateMinCollectInterval ...
+func (mr *MQTTReceiver) UpdateMinCollectInterval(interval float64) {
+	if interval < mr.collectinterval {
+		mr.collectinterval = interval
+	}
+}
+
+//Processed acknowledges the oldest message passed to notifier channel in case it was received
+//over current connection
+func (mr *MQTTReceiver) Processed() {
+	mr.pendingLock.Lock()
+	if len(mr.pending) == 0 {
+		mr.pendingLock.Unlock()
+		return
+	}
+	pending := mr.pending[0]
+	mr.pending = mr.pending[1:]
+	current := pending.connection == mr.lostCount
+	mr.pendingLock.Unlock()
+
+	if current {
+		pending.msg.Ack()
+	}
+}
+
+//Drain stops receiving of new messages, disconnects from the broker and closes notifier channel
+func (mr *MQTTReceiver) Drain() {
+	mr.drainOnce.Do(func() { close(mr.draining) })
+}
+
+//Stop stops receiving of messages and disconnects from the broker
+func (mr *MQTTReceiver) Stop() {
+	mr.stopOnce.Do(func() { close(mr.stop) })
+}
+
+//setStatus reports change of connection status
+func (mr *MQTTReceiver) setStatus(connected bool) {
+	mr.statusLock.Lock()
+	defer mr.statusLock.Unlock()
+	if connected == mr.connected {
+		return
+	}
+	mr.connected = connected
+	status := 0
+	if connected {
+		status = 1
+	}
+	select {
+	case mr.status <- status:
+	case <-mr.stop:
+	}
+}
+
+//subscribe subscribes topic filter, returns false in case the subscription failed
+func (mr *MQTTReceiver) subscribe(client mqtt.Client) bool {
+	token := client.Subscribe(mr.topic, mr.qos, mr.handle)
+	if !token.WaitTimeout(defaultMQTTTimeout) {
+		mr.logger.Error("Subscription was not acknowledged by MQTT broker", "topic", mr.topic)
+		return false
+	}
+	if err := token.Error(); err != nil {
+		mr.logger.Error("Failed to subscribe", "topic", mr.topic, "error", err)
+		return false
+	}
+	if qos := token.(*mqtt.SubscribeToken).Result()[mr.topic]; qos > 2 {
+		mr.logger.Error("Subscription was rejected by MQTT broker", "topic", mr.topic)
+		return false
+	}
+	return true
+}
+
+//onConnect subscribes topic filter each time the connection is established, subscriptions
+//of clean sessions are not kept by the broker. Failed subscription is retried while the connection
+//is open, connection is reported as established once the subscription succeeds.
+func (mr *MQTTReceiver) onConnect(client mqtt.Client) {
+	for !mr.subscribe(client) {
+		select {
+		case <-time.After(connectRetryPeriod):
+		case <-mr.stop:
+			return
+		case <-mr.draining:
+			return
+		}
+		if !client.IsConnectionOpen() {
+			// topic filter is subscribed again once the client reconnects
+			return
+		}
+	}
+	mr.logger.Debug("Subscribed", "topic", mr.topic)
+	// connection might have been lost meanwhile
+	mr.setStatus(client.IsConnectionOpen())
+}
+
+//onConnectionLost reports lost connection, the client reconnects automatically. Messages which
+//were not processed yet will not be acknowledged.
+func (mr *MQTTReceiver) onConnectionLost(client mqtt.Client, err error) {
+	mr.logger.Error("Lost connection to MQTT broker", "error", err)
+	mr.pendingLock.Lock()
+	mr.lostCount++
+	mr.pendingLock.Unlock()
+	mr.setStatus(false)
+}
+
+//handle passes body of received message to notifier channel, the message is acknowledged once it is processed
+func (mr *MQTTReceiver) handle(client mqtt.Client, msg mqtt.Message) {
+	body := string(msg.Payload())
+	if mr.stats != nil {
+		mr.stats.IncReceived(len(body))
+	}
+	if mr.logger.Enabled(logging.LevelDebug) {
+		mr.logger.Debug("Message received", "topic", msg.Topic(), "size", len(body), "body", logging.Truncate(body))
+	}
+	mr.notifierLock.RLock()
+	defer mr.notifierLock.RUnlock()
+	if mr.notifierClosed {
+		return
+	}
+	mr.pendingLock.Lock()
+	mr.pending = append(mr.pending, pendingMQTTMessage{msg: msg, connection: mr.lostCount})
+	mr.pendingLock.Unlock()
+	select {
+	case mr.notifier <- body:
+	case <-mr.stop:
+		mr.dropPending()
+	case <-mr.draining:
+		mr.dropPending()
+	}
+}
+
+//dropPending forgets the latest message which was not passed to notifier channel, the broker
+//redelivers it in case of persistent session
+func (mr *MQTTReceiver) dropPending() {
+	mr.pendingLock.Lock()
+	defer mr.pendingLock.Unlock()
+	if len(mr.pending) > 0 {
+		mr.pending = mr.pending[:len(mr.pending)-1]
+	}
+}
+
+//connect connects to the broker, the client reconnects automatically once connected
+func (mr *MQTTReceiver) connect() error {
+	token := mr.client.Connect()
+	token.Wait()
+	return token.Error()
+}
+
+//start connects to the broker and waits until the receiver is stopped or drained
+func (mr *MQTTReceiver) start() {
+	for reported, err := false, mr.connect(); err != nil; err = mr.connect() {
+		if !mr.reconnect {
+			mr.logger.Fatal("Could not connect to MQTT broker, is it running?", "error", err)
+		}
+		mr.logger.Error("Could not connect to MQTT broker, retrying", "error", err)
+		if !reported {
+			reported = true
+			select {
+			case mr.status <- 0:
+			case <-mr.stop:
+			case <-mr.draining:
+			}
+		}
+		select {
+		case <-time.After(connectRetryPeriod):
+			continue
+		case <-mr.stop:
+		case <-mr.draining:
+		}
+		break
+	}
+	select {
+	case <-mr.stop:
+		mr.logger.Debug("Stop received")
+		mr.client.Disconnect(mqttDisconnectQuiesceMillis)
+	case <-mr.draining:
+		mr.logger.Debug("Drain received")
+		mr.client.Disconnect(mqttDisconnectQuiesceMillis)
+		mr.notifierLock.Lock()
+		mr.notifierClosed = true
+		close(mr.notifier)
+		mr.notifierLock.Unlock()
+	}
+}
@@ -21,21 +21,28 @@ package amqp10
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"os/signal"
 	"reflect"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/infrawatch/smart-gateway/internal/pkg/cacheutil"
+	"github.com/infrawatch/smart-gateway/internal/pkg/logging"
 	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
-	"github.com/prometheus/client_golang/prometheus"
 	"qpid.apache.org/amqp"
 	"qpid.apache.org/electron"
 )
 
-var debugr = func(format string, data ...interface{}) {} // Default no debugging output
+var logger = logging.New("amqp10")
+
+//DefaultDrainTimeout is time given to processing of already received messages on shutdown when none is configured
+const DefaultDrainTimeout = 10 * time.Second
+
+//connectRetryPeriod is time between connection attempts of receivers created on configuration reload
+const connectRetryPeriod = 5 * time.Second
 
 //AMQPServer msgcount -1 is infinite
 type AMQPServer struct {
@@ -45,37 +52,62 @@ type AMQPServer struct {
 	notifier        chan string
 	status          chan int
 	done            chan bool
+	stop            chan bool
+	stopOnce        sync.Once
+	draining        chan bool
+	drainOnce       sync.Once
+	lock            sync.Mutex
 	connection      electron.Connection
+	receiver        electron.Receiver
 	method          func(s *AMQPServer) (electron.Receiver, error)
 	prefetch        int
 	amqpHandler     *AMQPHandler
+	logger          *logging.Logger
+	stats           *ConnectionStats
 	uniqueName      string
 	reconnect       bool
 	collectinterval float64
 }
 
-//AMQPServerItem hold information about data source which is AMQPServer listening to.
-type AMQPServerItem struct {
-	Server     *AMQPServer
-	DataSource saconfig.DataSource
+//Receiver receives messages of single configured connection and passes their bodies to notifier channel.
+//AMQPServer receives messages of AMQP1.0 connections, KafkaReceiver consumes Kafka topics, MQTTReceiver
+//subscribes MQTT topics and LoopbackReceiver receives messages sent within the process.
+type Receiver interface {
+	//GetURL returns URL of the connection
+	GetURL() string
+	//GetStats returns statistics of the connection or nil in case receiver was created without handler
+	GetStats() *ConnectionStats
+	//GetNotifier returns channel receiving message bodies
+	GetNotifier() chan string
+	//GetStatus returns channel receiving 1 when the connection is established and 0 when it is lost
+	GetStatus() chan int
+	//UpdateMinCollectInterval lowers collect interval of the connection
+	UpdateMinCollectInterval(interval float64)
+	//Processed acknowledges processing of the oldest message passed to notifier channel
+	Processed()
+	//Drain stops receiving of new messages, notifier channel is closed once received messages are passed
+	Drain()
+	//Stop stops receiving and drops messages which were not yet passed to notifier channel
+	Stop()
 }
 
-//AMQPHandler ...
-type AMQPHandler struct {
-	totalCount              int
-	totalProcessed          int
-	totalReconnectCount     int
-	totalCountDesc          *prometheus.Desc
-	totalProcessedDesc      *prometheus.Desc
-	totalReconnectCountDesc *prometheus.Desc
+//AMQPServerItem hold information about data source which is Receiver listening to.
+type AMQPServerItem struct {
+	Server     Receiver
+	DataSource saconfig.DataSource
 }
 
 //NewAMQPServer   ...
 func NewAMQPServer(urlStr string, debug bool, msgcount int, prefetch int, amqpHanlder *AMQPHandler, uniqueName string) *AMQPServer {
+	return newAMQPServer(urlStr, "", debug, msgcount, prefetch, amqpHanlder, uniqueName, false)
+}
+
+//newAMQPServer creates AMQPServer receiving data of given data source. Statistics of the connection
+//are collected by given handler in case it is not nil. Failure to connect is fatal unless reconnect
+//is true, in which case the failure is reported as lost connection and connecting is retried.
+func newAMQPServer(urlStr string, dataSource string, debug bool, msgcount int, prefetch int, amqpHanlder *AMQPHandler, uniqueName string, reconnect bool) *AMQPServer {
 	if len(urlStr) == 0 {
-		log.Println("No URL provided")
-		//usage()
-		os.Exit(1)
+		logger.Fatal("No URL provided")
 	}
 	server := &AMQPServer{
 		urlStr:          urlStr,
@@ -83,20 +115,24 @@ func NewAMQPServer(urlStr string, debug bool, msgcount int, prefetch int, amqpHa
 		notifier:        make(chan string),
 		status:          make(chan int),
 		done:            make(chan bool),
+		stop:            make(chan bool),
+		draining:        make(chan bool),
 		msgcount:        msgcount,
 		method:          (*AMQPServer).connect,
 		prefetch:        prefetch,
 		amqpHandler:     amqpHanlder,
 		uniqueName:      uniqueName,
-		reconnect:       false,
+		reconnect:       reconnect,
 		collectinterval: 30,
+		logger:          logger.With("url", logging.RedactURL(urlStr)),
 	}
-
-	if debug {
-		debugr = func(format string, data ...interface{}) {
-			log.Printf(format, data...)
-		}
+	if dataSource != "" {
+		server.logger = server.logger.With("datasource", dataSource)
+	}
+	if amqpHanlder != nil {
+		server.stats = amqpHanlder.GetConnectionStats(urlStr, dataSource)
 	}
+
 	// Spawn off the server's main loop immediately
 	// not exported
 	go server.start()
@@ -109,71 +145,9 @@ func (s *AMQPServer) GetHandler() *AMQPHandler {
 	return s.amqpHandler
 }
 
-//NewAMQPHandler  ...
-func NewAMQPHandler(source string) *AMQPHandler {
-	plabels := prometheus.Labels{}
-	plabels["source"] = source
-	return &AMQPHandler{
-		totalCount:          0,
-		totalProcessed:      0,
-		totalReconnectCount: 0,
-		totalCountDesc: prometheus.NewDesc("collectd_total_amqp_message_recv_count",
-			"Total count of amqp message received.",
-			nil, plabels,
-		),
-		totalProcessedDesc: prometheus.NewDesc("collectd_total_amqp_processed_message_count",
-			"Total count of amqp message processed.",
-			nil, plabels,
-		),
-		totalReconnectCountDesc: prometheus.NewDesc("collectd_total_amqp_reconnect_count",
-			"Total count of amqp reconnection .",
-			nil, plabels,
-		),
-	}
-}
-
-//IncTotalMsgRcv ...
-func (a *AMQPHandler) IncTotalMsgRcv() {
-	a.totalCount++
-}
-
-//IncTotalMsgProcessed ...
-func (a *AMQPHandler) IncTotalMsgProcessed() {
-	a.totalProcessed++
-}
-
-//IncTotalReconnectCount ...
-func (a *AMQPHandler) IncTotalReconnectCount() {
-	a.totalReconnectCount++
-}
-
-//GetTotalMsgRcv ...
-func (a *AMQPHandler) GetTotalMsgRcv() int {
-	return a.totalCount
-}
-
-//GetTotalMsgProcessed ...
-func (a *AMQPHandler) GetTotalMsgProcessed() int {
-	return a.totalProcessed
-}
-
-//GetTotalReconnectCount ...
-func (a *AMQPHandler) GetTotalReconnectCount() int {
-	return a.totalReconnectCount
-}
-
-//Describe ...
-func (a *AMQPHandler) Describe(ch chan<- *prometheus.Desc) {
-	ch <- a.totalCountDesc
-	ch <- a.totalProcessedDesc
-	ch <- a.totalReconnectCountDesc
-}
-
-//Collect implements prometheus.Collector.
-func (a *AMQPHandler) Collect(ch chan<- prometheus.Metric) {
-	ch <- prometheus.MustNewConstMetric(a.totalCountDesc, prometheus.CounterValue, float64(a.totalCount))
-	ch <- prometheus.MustNewConstMetric(a.totalProcessedDesc, prometheus.CounterValue, float64(a.totalProcessed))
-	ch <- prometheus.MustNewConstMetric(a.totalReconnectCountDesc, prometheus.CounterValue, float64(a.totalReconnectCount))
+//GetStats returns statistics of the connection or nil in case the server was created without handler
+func (s *AMQPServer) GetStats() *ConnectionStats {
+	return s.stats
 }
 
 //GetNotifier  Get notifier
@@ -193,8 +167,55 @@ func (s *AMQPServer) GetDoneChan() chan bool {
 
 //Close connections it is exported so users can force close
 func (s *AMQPServer) Close() {
-	s.connection.Close(nil)
-	debugr("Debug: close receiver connection %s", s.connection)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.connection != nil {
+		s.connection.Close(nil)
+		s.logger.Debug("Closed receiver connection")
+	}
+}
+
+//Stop stops the server main loop and closes the connection. Messages which were not
+//yet passed to notifier channel are dropped.
+func (s *AMQPServer) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.Close()
+}
+
+//Drain stops receiving of new messages by closing the receiver link, so that no more credit is issued.
+//Messages which were already received are still passed to notifier channel, which is closed afterwards.
+func (s *AMQPServer) Drain() {
+	s.drainOnce.Do(func() { close(s.draining) })
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.receiver != nil {
+		s.receiver.Close(nil)
+	}
+}
+
+//isDraining returns true if the server was asked to drain
+func (s *AMQPServer) isDraining() bool {
+	select {
+	case <-s.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+//stopped returns true if the server was stopped
+func (s *AMQPServer) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+//GetURL returns URL the server is listening on
+func (s *AMQPServer) GetURL() string {
+	return s.urlStr
 }
 
 //UpdateMinCollectInterval ...
@@ -204,38 +225,91 @@ func (s *AMQPServer) UpdateMinCollectInterval(interval float64) {
 	}
 }
 
+//Processed does nothing, AMQP1.0 messages are accepted once they are received
+func (s *AMQPServer) Processed() {
+}
+
 //start  starts amqp server
 func (s *AMQPServer) start() {
 	msgBuffCount := 10
 	if s.msgcount > 0 {
 		msgBuffCount = s.msgcount
 	}
+	// NOTE: the channels are not closed, because the receiving goroutine might still be running
+	// after the server was stopped
 	messages := make(chan amqp.Message, msgBuffCount) // Channel for messages from goroutines to main()
 	connectionStatus := make(chan int)
 	done := make(chan bool)
-
-	defer close(done)
-	defer close(messages)
-	defer close(connectionStatus)
+	drained := make(chan bool) // closed when receiving goroutine ended because of draining
 
 	go func() {
 		r, err := s.method(s)
-		if err != nil {
-			log.Fatalf("Could not connect to Qpid-dispatch router. is it running? : %v", err)
+		for reported := false; err != nil; r, err = s.method(s) {
+			if !s.reconnect {
+				s.logger.Fatal("Could not connect to Qpid-dispatch router, is it running?", "error", err)
+			}
+			s.logger.Error("Could not connect to Qpid-dispatch router, retrying", "error", err)
+			s.Close()
+			if !reported {
+				reported = true
+				select {
+				case connectionStatus <- 0:
+				case <-s.stop:
+					return
+				}
+			}
+			select {
+			case <-time.After(connectRetryPeriod):
+			case <-s.stop:
+				return
+			case <-s.draining:
+				close(drained)
+				return
+			}
+		}
+		s.lock.Lock()
+		s.receiver = r
+		s.lock.Unlock()
+		if s.isDraining() {
+			// server was asked to drain while connecting
+			r.Close(nil)
+			close(drained)
+			return
+		}
+		select {
+		case connectionStatus <- 1:
+		case <-s.stop:
+			// server was stopped while connecting
+			s.Close()
+			return
 		}
-		connectionStatus <- 1
 		untilCount := s.msgcount
 	theloop:
 		for {
 			if rm, err := r.Receive(); err == nil {
 				rm.Accept()
-				debugr("Message ACKed: %v", rm.Message)
-				messages <- rm.Message
-			} else if err == electron.Closed {
-				log.Printf("Channel closed...\n")
+				s.logger.Debug("Message ACKed")
+				select {
+				case messages <- rm.Message:
+				case <-s.stop:
+					return
+				}
+			} else if s.isDraining() {
+				s.logger.Info("Receiver closed for draining")
+				close(drained)
+				return
+			} else if err == electron.Closed || s.stopped() {
+				s.logger.Info("Channel closed")
+				if !s.stopped() {
+					// report lost connection
+					select {
+					case connectionStatus <- 0:
+					case <-s.stop:
+					}
+				}
 				return
 			} else {
-				log.Fatalf("Received error %v: %v", s.urlStr, err)
+				s.logger.Fatal("Failed to receive message", "error", err)
 			}
 			if untilCount > 0 {
 				untilCount--
@@ -247,33 +321,71 @@ func (s *AMQPServer) start() {
 		done <- true
 		s.done <- true
 		s.Close()
-		log.Println("Closed AMQP...letting loop know")
+		s.logger.Info("Closed AMQP connection")
 	}()
 
+	// forward passes message to notifier channel, returns false if server was stopped meanwhile
+	forward := func(m amqp.Message) bool {
+		var body string
+		switch msg := m.Body().(type) {
+		case amqp.Binary:
+			body = msg.String()
+		case string:
+			body = msg
+		default:
+			// do nothing and report
+			s.logger.Warn("Invalid type of AMQP message received", "type", fmt.Sprintf("%T", msg))
+			return true
+		}
+		if s.stats != nil {
+			s.stats.IncReceived(len(body))
+		}
+		if s.logger.Enabled(logging.LevelDebug) {
+			s.logger.Debug("Message received", "size", len(body), "body", logging.Truncate(body))
+		}
+		select {
+		case s.notifier <- body:
+			return true
+		case <-s.stop:
+			return false
+		}
+	}
+
 msgloop:
 	for {
 		select {
+		case <-s.stop:
+			s.logger.Debug("Stop received")
+			break msgloop
 		case <-done:
-			debugr("Done received...\n")
+			s.logger.Debug("Done received")
 			break msgloop
-		case m := <-messages:
-			debugr("Message received... %v\n", m.Body())
-			handler := s.GetHandler()
-			if handler != nil {
-				handler.IncTotalMsgRcv()
+		case <-drained:
+			s.logger.Debug("Drain received")
+			for {
+				select {
+				case m := <-messages:
+					if !forward(m) {
+						break msgloop
+					}
+				default:
+					// all received messages were passed
+					close(s.notifier)
+					s.Close()
+					break msgloop
+				}
 			}
-			switch msg := m.Body().(type) {
-			case amqp.Binary:
-				s.notifier <- msg.String()
-			case string:
-				s.notifier <- msg
-			default:
-				// do nothing and report
-				log.Printf("Invalid type of AMQP message received: %t", msg)
+		case m := <-messages:
+			if !forward(m) {
+				break msgloop
 			}
 		case status := <-connectionStatus:
-			debugr("Status received...\n")
-			s.status <- status
+			s.logger.Debug("Status received", "status", status)
+			select {
+			case s.status <- status:
+			case <-s.stop:
+				break msgloop
+			}
 		}
 	}
 }
@@ -284,19 +396,23 @@ func (s *AMQPServer) connect() (electron.Receiver, error) {
 	// Make name unique-ish
 	container := electron.NewContainer(fmt.Sprintf("rcv[%v]", s.uniqueName))
 	url, err := amqp.ParseURL(s.urlStr)
-	fatalIf(err)
+	if err != nil {
+		s.logger.Fatal("Invalid AMQP URL", "error", err)
+	}
 	c, err := container.Dial("tcp", url.Host) // NOTE: Dial takes just the Host part of the URL
 	if err != nil {
-		log.Printf("AMQP Dial tcp %v\n", err)
+		s.logger.Error("Failed to dial AMQP server", "host", url.Host, "error", err)
 		return nil, err
 	}
 
+	s.lock.Lock()
 	s.connection = c // Save connection so we can Close() when start() ends
+	s.lock.Unlock()
 
 	addr := strings.TrimPrefix(url.Path, "/")
 	opts := []electron.LinkOption{electron.Source(addr)}
 	if s.prefetch > 0 {
-		debugr("Amqp Prefetch set to %d\n", s.prefetch)
+		s.logger.Debug("AMQP prefetch set", "prefetch", s.prefetch)
 		opts = append(opts, electron.Capacity(s.prefetch), electron.Prefetch(true))
 	}
 
@@ -304,10 +420,53 @@ func (s *AMQPServer) connect() (electron.Receiver, error) {
 	return r, err
 }
 
-func fatalIf(err error) {
-	if err != nil {
-		log.Fatal(err)
+//DrainMessages stops receiving of new messages by all given servers and passes messages, which were
+//already received, to given process function. Processing of each message is acknowledged to its
+//server and all servers are stopped afterwards. Returns when all received messages were processed or when
+//timeout expires (DefaultDrainTimeout is used for zero timeout). In the latter case remaining messages
+//are dropped and count of servers which were not drained is returned.
+func DrainMessages(amqpServers []AMQPServerItem, timeout time.Duration, process func(item AMQPServerItem, msg string)) int {
+	if timeout <= 0 {
+		timeout = DefaultDrainTimeout
 	}
+	cases := make([]reflect.SelectCase, 0, len(amqpServers)+1)
+	for _, item := range amqpServers {
+		item.Server.Drain()
+		cases = append(cases, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(item.Server.GetNotifier()),
+		})
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	cases = append(cases, reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(timer.C),
+	})
+
+	pending := len(amqpServers)
+	for pending > 0 {
+		index, msg, ok := reflect.Select(cases)
+		if index == len(amqpServers) {
+			break
+		}
+		if !ok {
+			// notifier channel is closed when all received messages were passed, zero value
+			// of the channel makes reflect.Select ignore the case
+			cases[index].Chan = reflect.Value{}
+			pending--
+			continue
+		}
+		process(amqpServers[index], msg.String())
+		amqpServers[index].Server.Processed()
+	}
+	for index, item := range amqpServers {
+		if cases[index].Chan.IsValid() {
+			logger.Warn("Dropping messages of listener, drain timeout expired", "url", logging.RedactURL(item.Server.GetURL()), "datasource", item.DataSource)
+		}
+		item.Server.Stop()
+	}
+	return pending
 }
 
 //SpawnSignalHandler spawns goroutine which will wait for interruption signal(s)
@@ -318,78 +477,179 @@ func SpawnSignalHandler(finish chan bool, watchedSignals ...os.Signal) {
 	go func() {
 	signalLoop:
 		for sig := range interruptChannel {
-			log.Printf("Stopping execution on caught signal: %+v\n", sig)
+			logger.Info("Stopping execution on caught signal", "signal", sig)
 			close(finish)
 			break signalLoop
 		}
 	}()
 }
 
-//SpawnQpidStatusReporter builds dynamic select for reporting status of AMQP connections
-func SpawnQpidStatusReporter(wg *sync.WaitGroup, applicationHealth *cacheutil.ApplicationHealthCache, qpidStatusCases []reflect.SelectCase) {
+//SpawnQpidStatusReporter builds dynamic select for reporting status of connections of all given servers.
+//Status cases belong to the last servers of given amqpServers, which were newly created, and their connections
+//are added to application health. Connections of servers not included in amqpServers are removed from it.
+//Reporter ends once finish case of qpidStatusCases is selected or once returned function is called, which
+//has to be done before reporter of updated servers is spawned on configuration reload.
+func SpawnQpidStatusReporter(wg *sync.WaitGroup, applicationHealth *cacheutil.ApplicationHealthCache, qpidStatusCases []reflect.SelectCase, amqpServers []AMQPServerItem) func() {
+	current := make(map[string]bool, len(amqpServers))
+	for _, item := range amqpServers {
+		current[fmt.Sprintf("%s|%s", item.DataSource, item.Server.GetURL())] = true
+	}
+	applicationHealth.RetainConnections(func(url string, dataSource string) bool {
+		return current[fmt.Sprintf("%s|%s", dataSource, url)]
+	})
+	for _, item := range amqpServers[len(amqpServers)-len(qpidStatusCases)+1:] {
+		applicationHealth.AddConnection(item.Server.GetURL(), item.DataSource.String())
+	}
+
+	// status cases of all servers are followed by finish and stop cases
+	stop, stopped := make(chan bool), make(chan bool)
+	statusCases := make([]reflect.SelectCase, 0, len(amqpServers)+2)
+	for _, item := range amqpServers {
+		statusCases = append(statusCases, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(item.Server.GetStatus()),
+		})
+	}
+	statusCases = append(statusCases, qpidStatusCases[len(qpidStatusCases)-1], reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(stop),
+	})
+	finishCase, stopCase := len(amqpServers), len(amqpServers)+1
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		finishCase := len(qpidStatusCases) - 1
+		defer close(stopped)
 	statusLoop:
 		for {
-			switch index, status, _ := reflect.Select(qpidStatusCases); index {
+			switch index, status, _ := reflect.Select(statusCases); index {
 			case finishCase:
+				logger.Info("Closing QPID status reporter")
+				break statusLoop
+			case stopCase:
 				break statusLoop
 			default:
 				// Note: status here is always very low integer, so we don't need to be afraid of int64>int conversion
-				applicationHealth.QpidRouterState = int(status.Int())
+				applicationHealth.SetConnectionStatus(amqpServers[index].Server.GetURL(), amqpServers[index].DataSource.String(), int(status.Int()))
 			}
 		}
-		log.Println("Closing QPID status reporter")
 	}()
+	var stopOnce sync.Once
+	return func() {
+		stopOnce.Do(func() { close(stop) })
+		<-stopped
+	}
 }
 
-//CreateMessageLoopComponents creates signal select cases for configured AMQP1.0 connections and connects to all of thos
-func CreateMessageLoopComponents(config interface{}, finish chan bool, amqpHandler *AMQPHandler, uniqueName string) ([]reflect.SelectCase, []reflect.SelectCase, []AMQPServerItem) {
-	var (
-		debug       bool
-		prefetch    int
-		connections []saconfig.AMQPConnection
-	)
+//SpawnReloadSignalHandler returns channel which receives notification each time SIGHUP is received
+func SpawnReloadSignalHandler() chan os.Signal {
+	reloadChannel := make(chan os.Signal, 1)
+	signal.Notify(reloadChannel, syscall.SIGHUP)
+	return reloadChannel
+}
+
+//connectionSettings returns AMQP1.0, Kafka and MQTT related settings of given configuration
+func connectionSettings(config interface{}) (bool, int, []saconfig.AMQPConnection, saconfig.KafkaConfig, saconfig.MQTTConfig) {
 	switch conf := config.(type) {
 	case *saconfig.EventConfiguration:
-		debug = conf.Debug
-		prefetch = conf.Prefetch
-		connections = conf.AMQP1Connections
+		return conf.Debug, conf.Prefetch, conf.AMQP1Connections, conf.Kafka, conf.MQTT
 	case *saconfig.MetricConfiguration:
-		debug = conf.Debug
-		prefetch = conf.Prefetch
-		connections = conf.AMQP1Connections
-	default:
-		panic("Invalid type of configuration file struct.")
+		return conf.Debug, conf.Prefetch, conf.AMQP1Connections, conf.Kafka, conf.MQTT
+	}
+	panic("Invalid type of configuration file struct.")
+}
+
+//newReceiver creates receiver of given connection, URLs with kafka:// scheme are consumed by KafkaReceiver,
+//URLs with mqtt:// scheme by MQTTReceiver and URLs with loopback:// scheme by LoopbackReceiver. Receivers
+//retry connecting in case reconnect is true, otherwise failure to connect is fatal.
+func newReceiver(conn saconfig.AMQPConnection, debug bool, prefetch int, kafkaConfig saconfig.KafkaConfig, mqttConfig saconfig.MQTTConfig, amqpHandler *AMQPHandler, uniqueName string, reconnect bool) Receiver {
+	if saconfig.IsKafkaURL(conn.URL) {
+		return newKafkaReceiver(conn.URL, conn.DataSourceID.String(), prefetch, kafkaConfig, amqpHandler, uniqueName, reconnect)
+	}
+	if saconfig.IsMQTTURL(conn.URL) {
+		return newMQTTReceiver(conn.URL, conn.DataSourceID.String(), mqttConfig, amqpHandler, uniqueName, reconnect)
+	}
+	if IsLoopbackURL(conn.URL) {
+		return newLoopbackReceiver(conn.URL, conn.DataSourceID.String(), amqpHandler)
 	}
+	return newAMQPServer(conn.URL, conn.DataSourceID.String(), debug, -1, prefetch, amqpHandler, uniqueName, reconnect)
+}
 
-	processingCases := make([]reflect.SelectCase, 0, len(connections))
-	qpidStatusCases := make([]reflect.SelectCase, 0, len(connections))
-	amqpServers := make([]AMQPServerItem, 0, len(connections))
+//CreateMessageLoopComponents creates signal select cases for configured AMQP1.0, Kafka and MQTT connections and connects to all of thos
+func CreateMessageLoopComponents(config interface{}, finish chan bool, amqpHandler *AMQPHandler, uniqueName string) ([]reflect.SelectCase, []reflect.SelectCase, []AMQPServerItem) {
+	// include also case for finishing the loops
+	finishCases := []reflect.SelectCase{reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(finish),
+	}}
+	processingCases, qpidStatusCases, amqpServers := updateMessageLoopComponents(config, finish, amqpHandler, uniqueName, finishCases, nil, false)
+	logger.Info("Listening for messages")
+	return processingCases, qpidStatusCases, amqpServers
+}
+
+//UpdateMessageLoopComponents updates components created by CreateMessageLoopComponents according to AMQP1.0
+//connections of given configuration. Servers of connections which are not configured any more are stopped
+//and servers for new connections are created. Servers of unchanged connections keep running. Processing select
+//cases of given servers have to be at the beginning of given processingCases, other cases are preserved after
+//them. Returns updated processing cases and servers together with status select cases of newly created servers.
+//Newly created servers retry connecting, so that unreachable server does not end running gateway.
+func UpdateMessageLoopComponents(config interface{}, finish chan bool, amqpHandler *AMQPHandler, uniqueName string, processingCases []reflect.SelectCase, amqpServers []AMQPServerItem) ([]reflect.SelectCase, []reflect.SelectCase, []AMQPServerItem) {
+	return updateMessageLoopComponents(config, finish, amqpHandler, uniqueName, processingCases, amqpServers, true)
+}
+
+//updateMessageLoopComponents implements UpdateMessageLoopComponents, newly created servers retry connecting
+//in case reconnect is true
+func updateMessageLoopComponents(config interface{}, finish chan bool, amqpHandler *AMQPHandler, uniqueName string, processingCases []reflect.SelectCase, amqpServers []AMQPServerItem, reconnect bool) ([]reflect.SelectCase, []reflect.SelectCase, []AMQPServerItem) {
+	debug, prefetch, connections, kafkaConfig, mqttConfig := connectionSettings(config)
+	connectionKey := func(url string, dataSource saconfig.DataSource) string {
+		return fmt.Sprintf("%s|%s", dataSource, url)
+	}
+
+	configured := make(map[string]bool, len(connections))
 	for _, conn := range connections {
-		amqpServer := NewAMQPServer(conn.URL, debug, -1, prefetch, amqpHandler, uniqueName)
-		//create select case for this listener
-		processingCases = append(processingCases, reflect.SelectCase{
-			Dir:  reflect.SelectRecv,
-			Chan: reflect.ValueOf(amqpServer.GetNotifier()),
-		})
+		configured[connectionKey(conn.URL, conn.DataSourceID)] = true
+	}
+	running := make(map[string]bool, len(amqpServers))
+	updatedServers := make([]AMQPServerItem, 0, len(connections))
+	for _, item := range amqpServers {
+		key := connectionKey(item.Server.GetURL(), item.DataSource)
+		if configured[key] && !running[key] {
+			running[key] = true
+			updatedServers = append(updatedServers, item)
+		} else {
+			logger.Info("Closing listener", "url", logging.RedactURL(item.Server.GetURL()), "datasource", item.DataSource)
+			item.Server.Stop()
+			if !configured[key] && amqpHandler != nil {
+				amqpHandler.RemoveConnectionStats(item.Server.GetURL(), item.DataSource.String())
+			}
+		}
+	}
+
+	qpidStatusCases := make([]reflect.SelectCase, 0, len(connections)+1)
+	for _, conn := range connections {
+		if running[connectionKey(conn.URL, conn.DataSourceID)] {
+			continue
+		}
+		running[connectionKey(conn.URL, conn.DataSourceID)] = true
+		receiver := newReceiver(conn, debug, prefetch, kafkaConfig, mqttConfig, amqpHandler, uniqueName, reconnect)
 		qpidStatusCases = append(qpidStatusCases, reflect.SelectCase{
 			Dir:  reflect.SelectRecv,
-			Chan: reflect.ValueOf(amqpServer.GetStatus()),
+			Chan: reflect.ValueOf(receiver.GetStatus()),
 		})
-		amqpServers = append(amqpServers, AMQPServerItem{amqpServer, conn.DataSourceID})
+		updatedServers = append(updatedServers, AMQPServerItem{receiver, conn.DataSourceID})
 	}
-	log.Println("Listening for AMQP1.0 messages")
-	// include also case for finishing the loops
-	processingCases = append(processingCases, reflect.SelectCase{
-		Dir:  reflect.SelectRecv,
-		Chan: reflect.ValueOf(finish),
-	})
 	qpidStatusCases = append(qpidStatusCases, reflect.SelectCase{
 		Dir:  reflect.SelectRecv,
 		Chan: reflect.ValueOf(finish),
 	})
-	return processingCases, qpidStatusCases, amqpServers
+
+	//create select case for each listener
+	updatedCases := make([]reflect.SelectCase, 0, len(updatedServers)+len(processingCases)-len(amqpServers))
+	for _, item := range updatedServers {
+		updatedCases = append(updatedCases, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(item.Server.GetNotifier()),
+		})
+	}
+	updatedCases = append(updatedCases, processingCases[len(amqpServers):]...)
+	return updatedCases, qpidStatusCases, updatedServers
 }
@@ -21,15 +21,33 @@ package amqp10
 
 import (
 	"fmt"
-	"log"
+	"net"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/infrawatch/smart-gateway/internal/pkg/logging"
+	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
 	"qpid.apache.org/amqp"
 	"qpid.apache.org/electron"
 )
 
-var debugsf = func(format string, data ...interface{}) {} // Default no debugging output
+//Sender sends messages to single address and waits until they are accepted
+type Sender interface {
+	SendSync(bodies []string, timeout time.Duration) error
+}
+
+//NewSender returns sender of given publish URL: KafkaSender for URLs with kafka:// scheme, LoopbackSender
+//for URLs with loopback:// scheme and AMQPSender for other URLs, which are given without amqp:// scheme
+func NewSender(publishURL string, kafkaConfig saconfig.KafkaConfig, uniqueName string) (Sender, error) {
+	if saconfig.IsKafkaURL(publishURL) {
+		return NewKafkaSender(publishURL, kafkaConfig, uniqueName)
+	}
+	if IsLoopbackURL(publishURL) {
+		return NewLoopbackSender(publishURL), nil
+	}
+	return NewAMQPSender(fmt.Sprintf("amqp://%s", publishURL), false), nil
+}
 
 //AMQPSender msgcount -1 is infinite
 type AMQPSender struct {
@@ -42,9 +60,7 @@ type AMQPSender struct {
 //NewAMQPSender   ...
 func NewAMQPSender(urlStr string, debug bool) *AMQPSender {
 	if len(urlStr) == 0 {
-		log.Println("No URL provided")
-		//usage()
-		os.Exit(1)
+		logger.Fatal("No URL provided")
 	}
 	server := &AMQPSender{
 		urlStr:      urlStr,
@@ -52,12 +68,6 @@ func NewAMQPSender(urlStr string, debug bool) *AMQPSender {
 		connections: make(chan electron.Connection, 1),
 		acks:        make(chan electron.Outcome),
 	}
-	// Spawn off the server's main loop immediately
-	// not exported
-	if debug {
-		debugsf = func(format string, data ...interface{}) { log.Printf(format, data...) }
-	}
-
 	return server
 }
 
@@ -65,7 +75,7 @@ func NewAMQPSender(urlStr string, debug bool) *AMQPSender {
 func (as *AMQPSender) Close() {
 	c := <-as.connections
 	c.Close(nil)
-	debugsf("Debug: close sender connection %s", c)
+	logger.Debug("Closed sender connection", "url", logging.RedactURL(as.urlStr))
 }
 
 // GetAckChannel returns electron.Outcome channel for receiving ACK when debug mode is turned on
@@ -75,7 +85,6 @@ func (as *AMQPSender) GetAckChannel() chan electron.Outcome {
 
 //Send  starts amqp server
 func (as *AMQPSender) Send(jsonmsg string) {
-	debugsf("Debug: AMQP send is invoked")
 	go func(body string) {
 		container := electron.NewContainer(fmt.Sprintf("send[%v]", os.Getpid()))
 		url, err := amqp.ParseURL(as.urlStr)
@@ -91,7 +100,9 @@ func (as *AMQPSender) Send(jsonmsg string) {
 		m.SetContentType("application/json")
 		m.Marshal(body)
 
-		debugsf("Debug:Sending alerts on a bus URL %s\n", body)
+		if logger.Enabled(logging.LevelDebug) {
+			logger.Debug("Sending message", "url", logging.RedactURL(as.urlStr), "body", logging.Truncate(body))
+		}
 
 		if as.debug {
 			s.SendAsync(m, as.acks, "smart-gateway-ack")
@@ -102,8 +113,50 @@ func (as *AMQPSender) Send(jsonmsg string) {
 	}(jsonmsg)
 }
 
+//SendSync sends given messages over single connection and waits until each of them is accepted
+//by the broker. Returns error in case the broker cannot be reached within given timeout or it does
+//not accept any of the messages in time, remaining messages are not sent then.
+func (as *AMQPSender) SendSync(bodies []string, timeout time.Duration) error {
+	url, err := amqp.ParseURL(as.urlStr)
+	if err != nil {
+		return fmt.Errorf("invalid URL %s: %s", as.urlStr, err)
+	}
+	conn, err := net.DialTimeout("tcp", url.Host, timeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %s", url.Host, err)
+	}
+	container := electron.NewContainer(fmt.Sprintf("send[%v]", os.Getpid()))
+	c, err := container.Connection(conn)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to open connection to %s: %s", url.Host, err)
+	}
+	defer c.Close(nil)
+	s, err := c.Sender(electron.Target(strings.TrimPrefix(url.Path, "/")))
+	if err != nil {
+		return fmt.Errorf("failed to open sender link to %s: %s", as.urlStr, err)
+	}
+
+	for i, body := range bodies {
+		m := amqp.NewMessage()
+		m.SetContentType("application/json")
+		m.Marshal(body)
+		if logger.Enabled(logging.LevelDebug) {
+			logger.Debug("Sending message", "url", logging.RedactURL(as.urlStr), "body", logging.Truncate(body))
+		}
+		outcome := s.SendSyncTimeout(m, timeout)
+		if outcome.Error != nil {
+			return fmt.Errorf("failed to send message %d/%d to %s: %s", i+1, len(bodies), as.urlStr, outcome.Error)
+		}
+		if outcome.Status != electron.Accepted {
+			return fmt.Errorf("message %d/%d was not accepted by %s: %s", i+1, len(bodies), as.urlStr, outcome.Status)
+		}
+	}
+	return nil
+}
+
 func fatalsIf(err error) {
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal("Failed to send message", "error", err)
 	}
 }
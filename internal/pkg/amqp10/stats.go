@@ -0,0 +1,221 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package amqp10
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	//messageSizeBuckets are upper bounds of message size histogram buckets in bytes (64B - 1MiB)
+	messageSizeBuckets = prometheus.ExponentialBuckets(64, 4, 8)
+	//processingTimeBuckets are upper bounds of processing time histogram buckets in seconds (50µs - 13s)
+	processingTimeBuckets = prometheus.ExponentialBuckets(0.00005, 4, 10)
+)
+
+//ConnectionStats holds statistics of single AMQP1.0 connection. Counters are updated atomically,
+//so the statistics can be updated from receiving and processing goroutines at once.
+type ConnectionStats struct {
+	url            string
+	dataSource     string
+	received       atomic.Uint64
+	processed      atomic.Uint64
+	parseFailures  atomic.Uint64
+	reconnects     atomic.Uint64
+	messageSize    prometheus.Observer
+	processingTime prometheus.Observer
+}
+
+//IncReceived counts received message of given size in bytes
+func (cs *ConnectionStats) IncReceived(size int) {
+	cs.received.Add(1)
+	cs.messageSize.Observe(float64(size))
+}
+
+//IncProcessed counts processed message together with time spent by processing it
+func (cs *ConnectionStats) IncProcessed(duration time.Duration) {
+	cs.processed.Add(1)
+	cs.processingTime.Observe(duration.Seconds())
+}
+
+//IncParseFailures counts message which failed to be parsed
+func (cs *ConnectionStats) IncParseFailures() {
+	cs.parseFailures.Add(1)
+}
+
+//IncReconnects counts reconnection to AMQP1.0 server
+func (cs *ConnectionStats) IncReconnects() {
+	cs.reconnects.Add(1)
+}
+
+//GetReceived returns count of received messages
+func (cs *ConnectionStats) GetReceived() uint64 {
+	return cs.received.Load()
+}
+
+//GetProcessed returns count of processed messages
+func (cs *ConnectionStats) GetProcessed() uint64 {
+	return cs.processed.Load()
+}
+
+//GetParseFailures returns count of messages which failed to be parsed
+func (cs *ConnectionStats) GetParseFailures() uint64 {
+	return cs.parseFailures.Load()
+}
+
+//GetReconnects returns count of reconnections
+func (cs *ConnectionStats) GetReconnects() uint64 {
+	return cs.reconnects.Load()
+}
+
+//AMQPHandler collects statistics of AMQP1.0 connections labelled by connection URL and data source
+type AMQPHandler struct {
+	lock                    sync.RWMutex
+	connections             map[string]*ConnectionStats
+	totalCountDesc          *prometheus.Desc
+	totalProcessedDesc      *prometheus.Desc
+	totalReconnectCountDesc *prometheus.Desc
+	totalParseFailuresDesc  *prometheus.Desc
+	messageSize             *prometheus.HistogramVec
+	processingTime          *prometheus.HistogramVec
+}
+
+//NewAMQPHandler  ...
+func NewAMQPHandler(source string) *AMQPHandler {
+	plabels := prometheus.Labels{}
+	plabels["source"] = source
+	labels := []string{"url", "datasource"}
+	return &AMQPHandler{
+		connections: make(map[string]*ConnectionStats),
+		totalCountDesc: prometheus.NewDesc("collectd_total_amqp_message_recv_count",
+			"Total count of amqp message received.",
+			labels, plabels,
+		),
+		totalProcessedDesc: prometheus.NewDesc("collectd_total_amqp_processed_message_count",
+			"Total count of amqp message processed.",
+			labels, plabels,
+		),
+		totalReconnectCountDesc: prometheus.NewDesc("collectd_total_amqp_reconnect_count",
+			"Total count of amqp reconnection .",
+			labels, plabels,
+		),
+		totalParseFailuresDesc: prometheus.NewDesc("collectd_total_amqp_parse_failure_count",
+			"Total count of amqp message which failed to be parsed.",
+			labels, plabels,
+		),
+		messageSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "collectd_amqp_message_size_bytes",
+			Help:        "Size of received amqp message in bytes.",
+			ConstLabels: plabels,
+			Buckets:     messageSizeBuckets,
+		}, labels),
+		processingTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "collectd_amqp_message_processing_seconds",
+			Help:        "Time spent by processing of received amqp message in seconds.",
+			ConstLabels: plabels,
+			Buckets:     processingTimeBuckets,
+		}, labels),
+	}
+}
+
+//GetConnectionStats returns statistics of connection to given URL receiving data of given data source.
+//Statistics are created on first request. Password of the URL is not exported in url label.
+func (a *AMQPHandler) GetConnectionStats(url string, dataSource string) *ConnectionStats {
+	key := fmt.Sprintf("%s|%s", dataSource, url)
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	stats, ok := a.connections[key]
+	if !ok {
+		label := logging.RedactURL(url)
+		stats = &ConnectionStats{
+			url:            label,
+			dataSource:     dataSource,
+			messageSize:    a.messageSize.WithLabelValues(label, dataSource),
+			processingTime: a.processingTime.WithLabelValues(label, dataSource),
+		}
+		a.connections[key] = stats
+	}
+	return stats
+}
+
+//RemoveConnectionStats stops exporting statistics of connection to given URL receiving data of given data source
+func (a *AMQPHandler) RemoveConnectionStats(url string, dataSource string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	delete(a.connections, fmt.Sprintf("%s|%s", dataSource, url))
+	a.messageSize.DeleteLabelValues(logging.RedactURL(url), dataSource)
+	a.processingTime.DeleteLabelValues(logging.RedactURL(url), dataSource)
+}
+
+//sum returns sum of given counter of all connections
+func (a *AMQPHandler) sum(counter func(*ConnectionStats) uint64) int {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	total := 0
+	for _, stats := range a.connections {
+		total += int(counter(stats))
+	}
+	return total
+}
+
+//GetTotalMsgRcv returns count of messages received by all connections
+func (a *AMQPHandler) GetTotalMsgRcv() int {
+	return a.sum((*ConnectionStats).GetReceived)
+}
+
+//GetTotalMsgProcessed returns count of messages processed from all connections
+func (a *AMQPHandler) GetTotalMsgProcessed() int {
+	return a.sum((*ConnectionStats).GetProcessed)
+}
+
+//GetTotalReconnectCount returns count of reconnections of all connections
+func (a *AMQPHandler) GetTotalReconnectCount() int {
+	return a.sum((*ConnectionStats).GetReconnects)
+}
+
+//Describe ...
+func (a *AMQPHandler) Describe(ch chan<- *prometheus.Desc) {
+	ch <- a.totalCountDesc
+	ch <- a.totalProcessedDesc
+	ch <- a.totalReconnectCountDesc
+	ch <- a.totalParseFailuresDesc
+	a.messageSize.Describe(ch)
+	a.processingTime.Describe(ch)
+}
+
+//Collect implements prometheus.Collector.
+func (a *AMQPHandler) Collect(ch chan<- prometheus.Metric) {
+	a.lock.RLock()
+	for _, stats := range a.connections {
+		ch <- prometheus.MustNewConstMetric(a.totalCountDesc, prometheus.CounterValue, float64(stats.GetReceived()), stats.url, stats.dataSource)
+		ch <- prometheus.MustNewConstMetric(a.totalProcessedDesc, prometheus.CounterValue, float64(stats.GetProcessed()), stats.url, stats.dataSource)
+		ch <- prometheus.MustNewConstMetric(a.totalReconnectCountDesc, prometheus.CounterValue, float64(stats.GetReconnects()), stats.url, stats.dataSource)
+		ch <- prometheus.MustNewConstMetric(a.totalParseFailuresDesc, prometheus.CounterValue, float64(stats.GetParseFailures()), stats.url, stats.dataSource)
+	}
+	a.lock.RUnlock()
+	a.messageSize.Collect(ch)
+	a.processingTime.Collect(ch)
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/cacheutil"
+	"github.com/infrawatch/smart-gateway/internal/pkg/logging"
+)
+
+//AdminPrefix is path prefix of admin endpoints
+const AdminPrefix = "/admin/"
+
+//LogLevels is the body of log level admin requests and responses
+type LogLevels struct {
+	Level  string            `json:"level"`
+	Levels map[string]string `json:"levels"`
+}
+
+//adminHandler serves runtime administration endpoints of the metrics cache
+type adminHandler struct {
+	cache *cacheutil.IncomingDataCache
+	token string
+}
+
+//NewAdminHandler returns handler of admin endpoints managing given cache. Requests have to carry
+//given token in "Authorization: Bearer <token>" header. Handler serves following endpoints:
+//  GET    /admin/cache/hosts                   lists cached hosts with their last access time and series count
+//  GET    /admin/cache/hosts/{host}            dumps cached items of given host
+//  DELETE /admin/cache/hosts/{host}            evicts given host
+//  DELETE /admin/cache/hosts/{host}?item={key} evicts given item of given host
+//  POST   /admin/cache/expire                  removes stale items
+//  GET    /admin/loglevel                      returns current log levels
+//  PUT    /admin/loglevel                      sets log levels
+func NewAdminHandler(cache *cacheutil.IncomingDataCache, token string) http.Handler {
+	return &adminHandler{cache: cache, token: token}
+}
+
+//RegisterAdminHandler registers admin endpoints managing given cache to given multiplexer
+func RegisterAdminHandler(handler *http.ServeMux, cache *cacheutil.IncomingDataCache, token string) {
+	handler.Handle(AdminPrefix, NewAdminHandler(cache, token))
+}
+
+//authorized returns true in case request carries admin token
+func (ah *adminHandler) authorized(r *http.Request) bool {
+	token, ok := bearerToken(r)
+	return ok && ah.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(ah.token)) == 1
+}
+
+//ServeHTTP dispatches admin requests
+func (ah *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !ah.authorized(r) {
+		logger.Warn("Unauthorized admin request", "path", r.URL.Path, "remote", r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="smart-gateway"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, AdminPrefix), "/")
+	var (
+		status int
+		body   interface{}
+		err    error
+	)
+	switch {
+	case path == "cache/hosts":
+		status, body, err = ah.hosts(r)
+	case strings.HasPrefix(path, "cache/hosts/"):
+		status, body, err = ah.host(r, strings.TrimPrefix(path, "cache/hosts/"))
+	case path == "cache/expire":
+		status, body, err = ah.expire(r)
+	case path == "loglevel":
+		status, body, err = ah.logLevel(r)
+	default:
+		status, err = http.StatusNotFound, fmt.Errorf("unknown admin endpoint /admin/%s", path)
+	}
+	if err != nil {
+		logger.Debug("Admin request failed", "method", r.Method, "path", r.URL.Path, "status", status, "error", err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	logger.Info("Admin request", "method", r.Method, "path", r.URL.Path, "status", status)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.Error("Failed to write admin response", "error", err)
+	}
+}
+
+//methodNotAllowed returns error of request using unsupported method
+func methodNotAllowed(r *http.Request) (int, interface{}, error) {
+	return http.StatusMethodNotAllowed, nil, fmt.Errorf("method %s is not allowed for %s", r.Method, r.URL.Path)
+}
+
+func (ah *adminHandler) hosts(r *http.Request) (int, interface{}, error) {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed(r)
+	}
+	return http.StatusOK, ah.cache.ListHosts(), nil
+}
+
+func (ah *adminHandler) host(r *http.Request, host string) (int, interface{}, error) {
+	switch r.Method {
+	case http.MethodGet:
+		items, ok, err := ah.cache.GetHostItems(host)
+		if err != nil {
+			return http.StatusInternalServerError, nil, err
+		} else if !ok {
+			return http.StatusNotFound, nil, fmt.Errorf("host %s is not cached", host)
+		}
+		return http.StatusOK, items, nil
+	case http.MethodDelete:
+		if item := r.URL.Query().Get("item"); item != "" {
+			if !ah.cache.EvictItem(host, item) {
+				return http.StatusNotFound, nil, fmt.Errorf("item %s of host %s is not cached", item, host)
+			}
+			return http.StatusOK, map[string]string{"evicted": item}, nil
+		}
+		if !ah.cache.EvictHost(host) {
+			return http.StatusNotFound, nil, fmt.Errorf("host %s is not cached", host)
+		}
+		return http.StatusOK, map[string]string{"evicted": host}, nil
+	}
+	return methodNotAllowed(r)
+}
+
+func (ah *adminHandler) expire(r *http.Request) (int, interface{}, error) {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed(r)
+	}
+	return http.StatusOK, map[string]int{"expired": ah.cache.ExpireStale()}, nil
+}
+
+func (ah *adminHandler) logLevel(r *http.Request) (int, interface{}, error) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		request := LogLevels{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			return http.StatusBadRequest, nil, fmt.Errorf("invalid request body: %s", err)
+		}
+		var level logging.Level
+		if !level.SetFromString(request.Level) {
+			return http.StatusBadRequest, nil, fmt.Errorf("invalid log level '%s'", request.Level)
+		}
+		levels := make(map[string]logging.Level, len(request.Levels))
+		for component, name := range request.Levels {
+			var lvl logging.Level
+			if !lvl.SetFromString(name) {
+				return http.StatusBadRequest, nil, fmt.Errorf("invalid log level '%s' of component %s", name, component)
+			}
+			levels[component] = lvl
+		}
+		logging.SetLevels(level, levels)
+	default:
+		return methodNotAllowed(r)
+	}
+
+	level, levels := logging.Levels()
+	response := LogLevels{Level: level.String(), Levels: make(map[string]string, len(levels))}
+	for component, lvl := range levels {
+		response.Levels[component] = lvl.String()
+	}
+	return http.StatusOK, response, nil
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+//WebhookVersion is the only supported version of Alertmanager webhook payload
+const WebhookVersion = "4"
+
+//EnvelopeVersion is version of format of alert messages sent to the bus
+const EnvelopeVersion = "1"
+
+//alert statuses used by Alertmanager
+const (
+	statusFiring   = "firing"
+	statusResolved = "resolved"
+)
+
+//AlertEnvelope is message sent to the bus for each received webhook. In case alerts are split, each
+//alert is sent in separate envelope containing just that alert and status of the envelope is status
+//of the alert. Label and annotation maps are never null and times are in RFC3339 format in UTC.
+type AlertEnvelope struct {
+	Version           string            `json:"version"`    //EnvelopeVersion
+	Source            string            `json:"source"`     //always "alertmanager"
+	ReceivedAt        string            `json:"receivedAt"` //time the webhook was received by smart gateway
+	Receiver          string            `json:"receiver"`
+	Status            string            `json:"status"` //"firing" or "resolved"
+	GroupKey          string            `json:"groupKey"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"` //count of alerts dropped by Alertmanager due to max_alerts
+	Alerts            []Alert           `json:"alerts"`
+}
+
+//WebhookError holds all problems found in webhook payload
+type WebhookError struct {
+	Problems []string
+}
+
+func (e *WebhookError) Error() string {
+	return fmt.Sprintf("invalid webhook payload: %s", strings.Join(e.Problems, "; "))
+}
+
+//addProblem records problem found on given path of the payload
+func (e *WebhookError) addProblem(path string, format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf("%s: %s", path, fmt.Sprintf(format, args...)))
+}
+
+//validateStatus checks that status is either firing or resolved
+func validateStatus(status string, path string, errs *WebhookError) {
+	if status != statusFiring && status != statusResolved {
+		errs.addProblem(path, "invalid status '%s', expected firing or resolved", status)
+	}
+}
+
+//validateTime checks that given time is in RFC3339 format
+func validateTime(value string, path string, required bool, errs *WebhookError) {
+	if value == "" {
+		if required {
+			errs.addProblem(path, "value is required")
+		}
+		return
+	}
+	if _, err := time.Parse(time.RFC3339, value); err != nil {
+		errs.addProblem(path, "invalid time '%s', expected RFC3339 format", value)
+	}
+}
+
+//Validate checks that the message is valid Alertmanager webhook payload of supported version.
+//Returns WebhookError containing all problems found.
+func (hm *HookMessage) Validate() error {
+	errs := &WebhookError{}
+	if hm.Version != WebhookVersion {
+		errs.addProblem("version", "unsupported version '%s', expected %s", hm.Version, WebhookVersion)
+	}
+	if hm.GroupKey == "" {
+		errs.addProblem("groupKey", "value is required")
+	}
+	if hm.Receiver == "" {
+		errs.addProblem("receiver", "value is required")
+	}
+	validateStatus(hm.Status, "status", errs)
+	if hm.TruncatedAlerts < 0 {
+		errs.addProblem("truncatedAlerts", "value cannot be negative")
+	}
+	if len(hm.Alerts) == 0 {
+		errs.addProblem("alerts", "at least one alert is required")
+	}
+	for i, alert := range hm.Alerts {
+		path := fmt.Sprintf("alerts[%d]", i)
+		validateStatus(alert.Status, path+".status", errs)
+		if len(alert.Labels) == 0 {
+			errs.addProblem(path+".labels", "value is required")
+		}
+		validateTime(alert.StartsAt, path+".startsAt", true, errs)
+		validateTime(alert.EndsAt, path+".endsAt", false, errs)
+	}
+	if len(errs.Problems) > 0 {
+		return errs
+	}
+	return nil
+}
+
+//normalizeLabels returns copy of given map which is empty instead of nil
+func normalizeLabels(labels map[string]string) map[string]string {
+	normalized := make(map[string]string, len(labels))
+	for key, value := range labels {
+		normalized[key] = value
+	}
+	return normalized
+}
+
+//normalizeTime returns given RFC3339 time in UTC, zero time (used by Alertmanager for alerts
+//without end) is returned as empty string
+func normalizeTime(value string) string {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+//withAlerts returns copy of the message containing only given alerts. Status of the copy is firing
+//in case any of the alerts is firing, resolved otherwise.
+func (hm HookMessage) withAlerts(alerts []Alert) HookMessage {
+	hm.Alerts = alerts
+	hm.Status = statusResolved
+	for _, alert := range alerts {
+		if alert.Status == statusFiring {
+			hm.Status = statusFiring
+		}
+	}
+	return hm
+}
+
+//NewAlertEnvelopes converts validated webhook payload to envelopes sent to the bus. Returns envelope
+//for each alert in case split is true, single envelope with all alerts otherwise.
+func NewAlertEnvelopes(hm HookMessage, received time.Time, split bool) []AlertEnvelope {
+	alerts := make([]Alert, 0, len(hm.Alerts))
+	for _, alert := range hm.Alerts {
+		alerts = append(alerts, Alert{
+			Status:       alert.Status,
+			Labels:       normalizeLabels(alert.Labels),
+			Annotations:  normalizeLabels(alert.Annotations),
+			StartsAt:     normalizeTime(alert.StartsAt),
+			EndsAt:       normalizeTime(alert.EndsAt),
+			GeneratorURL: alert.GeneratorURL,
+			Fingerprint:  alert.Fingerprint,
+		})
+	}
+	envelope := AlertEnvelope{
+		Version:           EnvelopeVersion,
+		Source:            "alertmanager",
+		ReceivedAt:        received.UTC().Format(time.RFC3339Nano),
+		Receiver:          hm.Receiver,
+		Status:            hm.Status,
+		GroupKey:          hm.GroupKey,
+		GroupLabels:       normalizeLabels(hm.GroupLabels),
+		CommonLabels:      normalizeLabels(hm.CommonLabels),
+		CommonAnnotations: normalizeLabels(hm.CommonAnnotations),
+		ExternalURL:       hm.ExternalURL,
+		TruncatedAlerts:   hm.TruncatedAlerts,
+		Alerts:            alerts,
+	}
+	if !split {
+		return []AlertEnvelope{envelope}
+	}
+	envelopes := make([]AlertEnvelope, 0, len(alerts))
+	for _, alert := range alerts {
+		single := envelope
+		single.Status = alert.Status
+		single.Alerts = []Alert{alert}
+		envelopes = append(envelopes, single)
+	}
+	return envelopes
+}
+
+/************************** AlertRoute implementation **************************/
+
+//AlertRoute relays alerts which match all its label matchers to its sender. Route without matchers
+//relays all alerts.
+type AlertRoute struct {
+	Name    string //address of the route used in logs
+	Sender  AlertSender
+	match   map[string]string
+	matchRE map[string]*regexp.Regexp
+}
+
+//NewAlertRoute returns route relaying alerts with labels of given values (match) and labels matching
+//given regular expressions (matchRE) to given sender
+func NewAlertRoute(name string, sender AlertSender, match map[string]string, matchRE map[string]string) (*AlertRoute, error) {
+	route := &AlertRoute{Name: name, Sender: sender, match: match, matchRE: make(map[string]*regexp.Regexp, len(matchRE))}
+	for label, pattern := range matchRE {
+		re, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression of label %s: %s", label, err)
+		}
+		route.matchRE[label] = re
+	}
+	return route, nil
+}
+
+//Matches returns true in case given labels match all matchers of the route
+func (ar *AlertRoute) Matches(labels map[string]string) bool {
+	for label, value := range ar.match {
+		if labels[label] != value {
+			return false
+		}
+	}
+	for label, re := range ar.matchRE {
+		if !re.MatchString(labels[label]) {
+			return false
+		}
+	}
+	return true
+}
+
+//filter returns those of given alerts which match the route
+func (ar *AlertRoute) filter(alerts []Alert) []Alert {
+	matched := make([]Alert, 0, len(alerts))
+	for _, alert := range alerts {
+		if ar.Matches(alert.Labels) {
+			matched = append(matched, alert)
+		}
+	}
+	return matched
+}
@@ -2,18 +2,24 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/infrawatch/smart-gateway/internal/pkg/amqp10"
 	"github.com/infrawatch/smart-gateway/internal/pkg/cacheutil"
+	"github.com/infrawatch/smart-gateway/internal/pkg/logging"
 	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-var debugh = func(format string, data ...interface{}) {} // Default no debugging output
+var logger = logging.New("api")
+
+//DefaultPublishTimeout is time given to the bus to accept relayed alerts when none is configured
+const DefaultPublishTimeout = 10 * time.Second
 
 type (
 	// Timestamp is a helper for (un)marhalling time
@@ -29,21 +35,31 @@ type (
 		CommonLabels      map[string]string `json:"commonLabels"`
 		CommonAnnotations map[string]string `json:"commonAnnotations"`
 		ExternalURL       string            `json:"externalURL"`
+		TruncatedAlerts   int               `json:"truncatedAlerts"`
 		Alerts            []Alert           `json:"alerts"`
 	}
 
 	//Alert is a single alert.
 	Alert struct {
-		Labels      map[string]string `json:"labels"`
-		Annotations map[string]string `json:"annotations"`
-		StartsAt    string            `json:"startsAt,omitempty"`
-		EndsAt      string            `json:"EndsAt,omitempty"`
+		Status       string            `json:"status"`
+		Labels       map[string]string `json:"labels"`
+		Annotations  map[string]string `json:"annotations"`
+		StartsAt     string            `json:"startsAt,omitempty"`
+		EndsAt       string            `json:"endsAt,omitempty"`
+		GeneratorURL string            `json:"generatorURL,omitempty"`
+		Fingerprint  string            `json:"fingerprint,omitempty"`
+	}
+
+	//AlertSender publishes messages to the bus and waits until they are accepted
+	AlertSender interface {
+		SendSync(bodies []string, timeout time.Duration) error
 	}
 
 	//Context ...
 	Context struct {
 		Config      *saconfig.EventConfiguration
-		AMQP1Sender *amqp10.AMQPSender
+		AMQP1Sender AlertSender   // receives all alerts, can be nil
+		Routes      []*AlertRoute // receive alerts matching their labels
 	}
 
 	//Handler ...
@@ -53,15 +69,36 @@ type (
 	}
 )
 
-//NewContext ...
-func NewContext(serverConfig saconfig.EventConfiguration) *Context {
-	amqpPublishurl := fmt.Sprintf("amqp://%s", serverConfig.API.AMQP1PublishURL)
-	amqpSender := amqp10.NewAMQPSender(amqpPublishurl, false)
-	context := &Context{Config: &serverConfig, AMQP1Sender: amqpSender}
-	if serverConfig.Debug {
-		debugh = func(format string, data ...interface{}) { log.Printf(format, data...) }
+//NewContext returns context of alert relay sending alerts to API.AMQP1PublishURL and API.PublishTargets
+func NewContext(serverConfig saconfig.EventConfiguration) (*Context, error) {
+	ctxt := &Context{Config: &serverConfig}
+	if serverConfig.API.AMQP1PublishURL != "" {
+		sender, err := amqp10.NewSender(serverConfig.API.AMQP1PublishURL, serverConfig.Kafka, serverConfig.UniqueName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid publish URL %s: %s", serverConfig.API.AMQP1PublishURL, err)
+		}
+		ctxt.AMQP1Sender = sender
+	}
+	for _, target := range serverConfig.API.PublishTargets {
+		sender, err := amqp10.NewSender(target.URL, serverConfig.Kafka, serverConfig.UniqueName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid publish target %s: %s", target.URL, err)
+		}
+		route, err := NewAlertRoute(target.URL, sender, target.Match, target.MatchRE)
+		if err != nil {
+			return nil, fmt.Errorf("invalid publish target %s: %s", target.URL, err)
+		}
+		ctxt.Routes = append(ctxt.Routes, route)
 	}
-	return context
+	return ctxt, nil
+}
+
+//routes returns all routes of the context, AMQP1Sender is used as route without matchers
+func (c *Context) routes() []*AlertRoute {
+	if c.AMQP1Sender == nil {
+		return c.Routes
+	}
+	return append([]*AlertRoute{{Name: c.Config.API.AMQP1PublishURL, Sender: c.AMQP1Sender}}, c.Routes...)
 }
 
 //ServeHTTP...
@@ -69,7 +106,7 @@ func (ah Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Updated to pass ah.appContext as a parameter to our handler type.
 	status, err := ah.H(ah.Context, w, r)
 	if err != nil {
-		debugh("Debug:HTTP %d: %q", status, err)
+		logger.Debug("Request failed", "path", r.URL.Path, "status", status, "error", err)
 		switch status {
 		case http.StatusNotFound:
 			http.NotFound(w, r)
@@ -77,37 +114,82 @@ func (ah Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			// err := ah.renderTemplate(w, "http_404.tmpl", nil)
 		case http.StatusInternalServerError:
 			http.Error(w, http.StatusText(status), status)
+		case http.StatusBadRequest:
+			http.Error(w, err.Error(), status)
 		default:
 			http.Error(w, http.StatusText(status), status)
 		}
 	}
 }
 
-//AlertHandler  ...
+//AlertHandler validates Alertmanager webhook and relays it to the bus converted to AlertEnvelope
+//(or an envelope per alert in case API.SplitAlerts is set). Each route receives only alerts matching
+//it. Responds 202 once all routes accepted their messages and 503 when any of them failed to do so,
+//so that Alertmanager retries the notification.
 func AlertHandler(a *Context, w http.ResponseWriter, r *http.Request) (int, error) {
 	var body HookMessage
 	decoder := json.NewDecoder(r.Body)
 	defer r.Body.Close()
 	if err := decoder.Decode(&body); err != nil {
-		http.Error(w, "invalid request body", 400)
-		return http.StatusInternalServerError, err
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return http.StatusRequestEntityTooLarge, err
+		}
+		return http.StatusBadRequest, err
+	}
+	if err := body.Validate(); err != nil {
+		return http.StatusBadRequest, err
 	}
 
-	debugh("API AlertHandler Body%#v\n", body)
-	out, err := json.Marshal(body)
-	if err != nil {
-		panic(err)
-	}
-	debugh("Debug:Sending alerts to to AMQP")
-	debugh("Debug:Alert on AMQP%#v\n", string(out))
-	a.AMQP1Sender.Send(string(out))
-
-	// We can shortcut this: since renderTemplate returns `error`,
-	// our ServeHTTP method will return a HTTP 500 instead and won't
-	// attempt to write a broken template out with a HTTP 200 status.
-	// (see the postscript for how renderTemplate is implemented)
-	// If it doesn't return an error, things will go as planned.
-	return http.StatusOK, nil
+	received := time.Now()
+	routes := a.routes()
+	messages := make([][]string, len(routes))
+	for i, route := range routes {
+		alerts := route.filter(body.Alerts)
+		if len(alerts) == 0 {
+			continue
+		}
+		for _, envelope := range NewAlertEnvelopes(body.withAlerts(alerts), received, a.Config.API.SplitAlerts) {
+			out, err := json.Marshal(envelope)
+			if err != nil {
+				return http.StatusInternalServerError, err
+			}
+			messages[i] = append(messages[i], string(out))
+		}
+	}
+
+	timeout := time.Duration(a.Config.API.PublishTimeout) * time.Second
+	if timeout == 0 {
+		timeout = DefaultPublishTimeout
+	}
+	errs := make([]error, len(routes))
+	var wg sync.WaitGroup
+	for i := range routes {
+		if len(messages[i]) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = routes[i].Sender.SendSync(messages[i], timeout)
+		}(i)
+	}
+	wg.Wait()
+
+	failed := []string{}
+	for i, err := range errs {
+		if err != nil {
+			logger.Warn("Failed to relay alerts", "group", body.GroupKey, "route", routes[i].Name, "error", err)
+			failed = append(failed, routes[i].Name)
+		} else if len(messages[i]) > 0 {
+			logger.Debug("Relayed alerts", "group", body.GroupKey, "route", routes[i].Name, "messages", len(messages[i]))
+		}
+	}
+	if len(failed) > 0 {
+		return http.StatusServiceUnavailable, fmt.Errorf("failed to relay alerts to %s", strings.Join(failed, ", "))
+	}
+	w.WriteHeader(http.StatusAccepted)
+	return http.StatusAccepted, nil
 }
 
 //MetricHandler ...metric handlers
@@ -136,7 +218,7 @@ func NewAppStateMetricHandler(applicationHealth *cacheutil.ApplicationHealthCach
 			nil, plabels,
 		),
 		qpidRouterState: prometheus.NewDesc("collectd_qpid_router_status",
-			"Metric listener router status ",
+			"Listener router status ",
 			nil, plabels,
 		),
 	}
@@ -150,11 +232,11 @@ func NewAppStateEventMetricHandler(applicationHealth *cacheutil.ApplicationHealt
 	return &EventMetricHandler{
 		applicationHealth: applicationHealth,
 		lastPull: prometheus.NewDesc("collectd_last_pull_timestamp_seconds",
-			"Unix timestamp of the last event listener pull in seconds.",
+			"Unix timestamp of the last metrics pull in seconds.",
 			nil, plabels,
 		),
 		qpidRouterState: prometheus.NewDesc("collectd_qpid_router_status",
-			"Event listener router status ",
+			"Listener router status ",
 			nil, plabels,
 		),
 		elasticSearchState: prometheus.NewDesc("collectd_elasticsearch_status",
@@ -180,12 +262,14 @@ func (eventMetricHandler *EventMetricHandler) Describe(ch chan<- *prometheus.Des
 // Collect implements prometheus.Collector.
 func (metricHandler *MetricHandler) Collect(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(metricHandler.lastPull, prometheus.GaugeValue, float64(time.Now().Unix()))
-	ch <- prometheus.MustNewConstMetric(metricHandler.qpidRouterState, prometheus.GaugeValue, float64(metricHandler.applicationHealth.QpidRouterState))
+	qpidRouterState, _ := metricHandler.applicationHealth.States()
+	ch <- prometheus.MustNewConstMetric(metricHandler.qpidRouterState, prometheus.GaugeValue, float64(qpidRouterState))
 }
 
 // Collect implements prometheus.Collector.
 func (eventMetricHandler *EventMetricHandler) Collect(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(eventMetricHandler.lastPull, prometheus.GaugeValue, float64(time.Now().Unix()))
-	ch <- prometheus.MustNewConstMetric(eventMetricHandler.qpidRouterState, prometheus.GaugeValue, float64(eventMetricHandler.applicationHealth.QpidRouterState))
-	ch <- prometheus.MustNewConstMetric(eventMetricHandler.elasticSearchState, prometheus.GaugeValue, float64(eventMetricHandler.applicationHealth.ElasticSearchState))
+	qpidRouterState, elasticSearchState := eventMetricHandler.applicationHealth.States()
+	ch <- prometheus.MustNewConstMetric(eventMetricHandler.qpidRouterState, prometheus.GaugeValue, float64(qpidRouterState))
+	ch <- prometheus.MustNewConstMetric(eventMetricHandler.elasticSearchState, prometheus.GaugeValue, float64(elasticSearchState))
 }
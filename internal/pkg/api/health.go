@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/cacheutil"
+)
+
+//Paths of health endpoints
+const (
+	HealthPath    = "/healthz"
+	ReadinessPath = "/readyz"
+)
+
+//ProbePaths are paths of endpoints used by liveness and readiness probes, which are served without
+//authentication, so that probes do not need credentials
+var ProbePaths = []string{HealthPath, ReadinessPath}
+
+//isProbePath returns true in case given path is one of ProbePaths
+func isProbePath(path string) bool {
+	for _, probe := range ProbePaths {
+		if path == probe {
+			return true
+		}
+	}
+	return false
+}
+
+//HealthResponse is the body of /healthz and /readyz responses
+type HealthResponse struct {
+	Status     string                            `json:"status"`
+	Ready      bool                              `json:"ready"`
+	Components map[string]cacheutil.HealthReport `json:"components"`
+}
+
+//healthHandler serves health reports of given pipelines
+type healthHandler struct {
+	components map[string]*cacheutil.ApplicationHealthCache
+	readiness  bool
+}
+
+//ServeHTTP responds with health reports of all pipelines. Readiness handler responds
+//with 503 Service Unavailable in case any of the pipelines is not ready.
+func (hh *healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	response := HealthResponse{Status: "ok", Ready: true, Components: make(map[string]cacheutil.HealthReport, len(hh.components))}
+	names := make([]string, 0, len(hh.components))
+	for name := range hh.components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		report := hh.components[name].Report()
+		response.Components[name] = report
+		if !report.Ready {
+			response.Ready = false
+			response.Status = "not ready"
+			logger.Debug("Pipeline is not ready", "pipeline", name, "problems", strings.Join(report.Problems, "; "))
+		}
+	}
+
+	status := http.StatusOK
+	if hh.readiness && !response.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("Failed to write health report", "error", err)
+	}
+}
+
+//NewHealthHandler returns handler of liveness endpoint, which always responds with 200 OK
+//and health reports of given pipelines
+func NewHealthHandler(components map[string]*cacheutil.ApplicationHealthCache) http.Handler {
+	return &healthHandler{components: components}
+}
+
+//NewReadinessHandler returns handler of readiness endpoint, which responds with health reports
+//of given pipelines and 503 Service Unavailable in case any of them is not ready
+func NewReadinessHandler(components map[string]*cacheutil.ApplicationHealthCache) http.Handler {
+	return &healthHandler{components: components, readiness: true}
+}
+
+//RegisterHealthHandlers registers /healthz and /readyz endpoints reporting health of given pipelines
+func RegisterHealthHandlers(handler *http.ServeMux, components map[string]*cacheutil.ApplicationHealthCache) {
+	handler.Handle(HealthPath, NewHealthHandler(components))
+	handler.Handle(ReadinessPath, NewReadinessHandler(components))
+}
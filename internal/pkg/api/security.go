@@ -0,0 +1,305 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//DefaultMaxBodySize is the size limit of request bodies in bytes used when none is configured
+const DefaultMaxBodySize = 1 << 20
+
+//NewTLSConfig returns TLS configuration of HTTPS server using given certificate and key files.
+//Client certificates are required and verified using given CA certificate file in case it is not empty.
+func NewTLSConfig(certFile string, keyFile string, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %s", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		ca, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client CA certificate: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("failed to parse client CA certificate %s", clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+//NewWebTLSConfig returns TLS configuration of HTTPS server according to TLS options of web configuration file
+func NewWebTLSConfig(config *saconfig.TLSServerConfig) (*tls.Config, error) {
+	tlsConfig, err := NewTLSConfig(config.CertFile, config.KeyFile, config.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	clientAuth := map[string]tls.ClientAuthType{
+		"NoClientCert":               tls.NoClientCert,
+		"RequestClientCert":          tls.RequestClientCert,
+		"RequireAnyClientCert":       tls.RequireAnyClientCert,
+		"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+		"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+	}
+	if config.ClientAuthType != "" {
+		tlsConfig.ClientAuth = clientAuth[config.ClientAuthType]
+	}
+	if config.MinVersion != "" {
+		tlsConfig.MinVersion = saconfig.TLSVersions[config.MinVersion]
+	}
+	if config.MaxVersion != "" {
+		tlsConfig.MaxVersion = saconfig.TLSVersions[config.MaxVersion]
+	}
+	return tlsConfig, nil
+}
+
+/************************** RateLimiter implementation *************************/
+
+//bucket holds tokens of single client
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+//RateLimiter limits rate of requests of each client using token bucket algorithm. Each client
+//(identified by its IP address) can make burst requests at once and rate requests per second on average.
+type RateLimiter struct {
+	rate    float64
+	burst   float64
+	lock    sync.Mutex
+	buckets map[string]*bucket
+	cleaned time.Time
+}
+
+//NewRateLimiter returns rate limiter allowing given rate of requests per second and given burst
+//of requests per client. Burst lower than 1 is set to ceiling of rate.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = int(math.Ceil(rate))
+	}
+	return &RateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket), cleaned: time.Now()}
+}
+
+//Allow takes token from bucket of given client. Returns false in case the bucket is empty.
+func (rl *RateLimiter) Allow(client string) bool {
+	now := time.Now()
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	b, ok := rl.buckets[client]
+	if !ok {
+		b = &bucket{tokens: rl.burst, updated: now}
+		rl.buckets[client] = b
+	}
+	b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.updated).Seconds()*rl.rate)
+	b.updated = now
+
+	// drop buckets of clients which would have full bucket anyway
+	if full := time.Duration(rl.burst / rl.rate * float64(time.Second)); now.Sub(rl.cleaned) > full {
+		for key, other := range rl.buckets {
+			if now.Sub(other.updated) > full {
+				delete(rl.buckets, key)
+			}
+		}
+		rl.cleaned = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+/************************ protectedHandler implementation ***********************/
+
+//bearerPrefix is prefix of Authorization header value carrying bearer token
+const bearerPrefix = "Bearer "
+
+//bearerToken returns token of request Authorization header, returns false in case the header does not
+//use Bearer scheme (scheme name is case-insensitive)
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < len(bearerPrefix) || !strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		return "", false
+	}
+	return header[len(bearerPrefix):], true
+}
+
+//protectedHandler authenticates, rate limits and limits body size of requests passed to wrapped handler
+type protectedHandler struct {
+	handler     http.Handler
+	auth        string
+	routes      []string // route prefixes sorted from the longest
+	routeAuth   map[string]string
+	token       string
+	user        string
+	pass        string
+	maxBodySize int64
+	limiter     *RateLimiter
+}
+
+//NewProtectedHandler wraps given handler so that requests are rate limited and authenticated
+//according to given API configuration and size of their bodies is limited. Requests of ProbePaths
+//are not authenticated.
+func NewProtectedHandler(handler http.Handler, config saconfig.EventAPIConfig) http.Handler {
+	ph := &protectedHandler{
+		handler:     handler,
+		auth:        config.Auth,
+		routeAuth:   config.RouteAuth,
+		token:       config.AuthToken,
+		user:        config.AuthUser,
+		pass:        config.AuthPass,
+		maxBodySize: config.MaxBodySize,
+	}
+	if ph.maxBodySize == 0 {
+		ph.maxBodySize = DefaultMaxBodySize
+	}
+	if config.RateLimit > 0 {
+		ph.limiter = NewRateLimiter(config.RateLimit, config.RateBurst)
+	}
+	for route := range config.RouteAuth {
+		ph.routes = append(ph.routes, route)
+	}
+	sort.Slice(ph.routes, func(i, j int) bool { return len(ph.routes[i]) > len(ph.routes[j]) })
+	return ph
+}
+
+//authFor returns authentication method of given path, probe endpoints are not authenticated. Route
+//covers its own path and paths below it, eg. "/alert" covers "/alert/x", but not "/alerts".
+func (ph *protectedHandler) authFor(path string) string {
+	if isProbePath(path) {
+		return saconfig.APIAuthNone
+	}
+	for _, route := range ph.routes {
+		if path == route || strings.HasPrefix(path, strings.TrimSuffix(route, "/")+"/") {
+			return ph.routeAuth[route]
+		}
+	}
+	return ph.auth
+}
+
+//authenticate returns true in case request carries credentials required by given authentication method
+func (ph *protectedHandler) authenticate(method string, r *http.Request) bool {
+	switch method {
+	case saconfig.APIAuthBearer:
+		token, ok := bearerToken(r)
+		return ok && subtle.ConstantTimeCompare([]byte(token), []byte(ph.token)) == 1
+	case saconfig.APIAuthBasic:
+		user, pass, ok := r.BasicAuth()
+		userOk := subtle.ConstantTimeCompare([]byte(user), []byte(ph.user)) == 1
+		passOk := subtle.ConstantTimeCompare([]byte(pass), []byte(ph.pass)) == 1
+		return ok && userOk && passOk
+	}
+	return true
+}
+
+//ServeHTTP rejects requests exceeding rate limit or lacking credentials and passes the others
+//to the wrapped handler
+func (ph *protectedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	if ph.limiter != nil && !ph.limiter.Allow(client) {
+		logger.Debug("Request rate limit exceeded", "path", r.URL.Path, "client", client)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+	if method := ph.authFor(r.URL.Path); !ph.authenticate(method, r) {
+		logger.Warn("Unauthorized request", "path", r.URL.Path, "client", client, "auth", method)
+		if method == saconfig.APIAuthBasic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="smart-gateway"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="smart-gateway"`)
+		}
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if r.ContentLength > ph.maxBodySize {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, ph.maxBodySize)
+	ph.handler.ServeHTTP(w, r)
+}
+
+/*********************** basicAuthHandler implementation ***********************/
+
+//maxAuthCacheSize is count of verified credentials after which the cache of basic auth handler is cleared
+const maxAuthCacheSize = 100
+
+//basicAuthHandler passes to wrapped handler only requests of users with password matching bcrypt hash
+type basicAuthHandler struct {
+	handler http.Handler
+	users   map[string]string
+	lock    sync.Mutex
+	// bcrypt is slow by design, so verified credentials are cached
+	verified map[[sha256.Size]byte]bool
+}
+
+//NewBasicAuthHandler wraps given handler so that only requests of given users (user name -> bcrypt hash
+//of password) are passed to it
+func NewBasicAuthHandler(handler http.Handler, users map[string]string) http.Handler {
+	return &basicAuthHandler{handler: handler, users: users, verified: make(map[[sha256.Size]byte]bool)}
+}
+
+//authenticate returns true in case request carries credentials of one of the users
+func (bh *basicAuthHandler) authenticate(r *http.Request) bool {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	hash, ok := bh.users[user]
+	if !ok {
+		return false
+	}
+	key := sha256.Sum256([]byte(user + "\x00" + pass + "\x00" + hash))
+	bh.lock.Lock()
+	verified := bh.verified[key]
+	bh.lock.Unlock()
+	if verified {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) != nil {
+		return false
+	}
+	bh.lock.Lock()
+	if len(bh.verified) >= maxAuthCacheSize {
+		bh.verified = make(map[[sha256.Size]byte]bool)
+	}
+	bh.verified[key] = true
+	bh.lock.Unlock()
+	return true
+}
+
+//ServeHTTP rejects requests without valid credentials and passes the others to the wrapped handler
+func (bh *basicAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !bh.authenticate(r) {
+		logger.Warn("Unauthorized request", "path", r.URL.Path, "remote", r.RemoteAddr, "auth", saconfig.APIAuthBasic)
+		w.Header().Set("WWW-Authenticate", `Basic realm="smart-gateway"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	bh.handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//defaultShutdownTimeout is time given to active requests on shutdown when none is configured
+const defaultShutdownTimeout = 10 * time.Second
+
+//SpawnHTTPServer spawns goroutine serving given handler on given address. HTTPS is served in case
+//TLS configuration is given. The server is shut down when finish channel is closed, active requests
+//are given shutdownTimeout to finish (10 seconds for zero timeout) and their connections are closed afterwards.
+//Given wait group is done once the server is shut down.
+func SpawnHTTPServer(wg *sync.WaitGroup, finish chan bool, name string, address string, handler http.Handler, tlsConfig *tls.Config, shutdownTimeout time.Duration) {
+	srv := &http.Server{Addr: address, Handler: handler, TLSConfig: tlsConfig}
+	logger := logger.With("server", name, "address", address, "tls", tlsConfig != nil)
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = defaultShutdownTimeout
+	}
+	// spawn shutdown signal handler
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-finish
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Error("Failed to stop server gracefully", "error", err)
+			srv.Close()
+		}
+	}()
+	// spawn the server
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		logger.Info("Started server")
+		var err error
+		if tlsConfig != nil {
+			// certificates are part of TLS configuration
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			logger.Fatal("Failed to start server", "error", err)
+		} else {
+			logger.Info("Closing server")
+		}
+	}()
+}
@@ -0,0 +1,137 @@
+package cacheutil
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+//HostSummary describes cached data of single host
+type HostSummary struct {
+	Host       string `json:"host"`
+	LastAccess int64  `json:"last_access"` //timestamp in seconds of the last update
+	Items      int    `json:"items"`
+	Series     int    `json:"series"` //count of data sources of all items
+	Restored   bool   `json:"restored"`
+}
+
+//CacheItem holds single cached item together with its cache metadata
+type CacheItem struct {
+	Key        string          `json:"key"`
+	DataSource string          `json:"datasource"`
+	New        bool            `json:"new"` //true until the item is exported
+	Updated    int64           `json:"updated"`
+	Created    int64           `json:"created"`
+	Metric     json.RawMessage `json:"metric"`
+}
+
+//summary returns summary of the shard for given host
+func (shard *ShardedIncomingDataCache) summary(host string) HostSummary {
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+	summary := HostSummary{Host: host, LastAccess: shard.lastAccess, Items: len(shard.plugin), Restored: shard.restored}
+	for _, metric := range shard.plugin {
+		summary.Series += len(metric.GetValues())
+	}
+	return summary
+}
+
+//items returns all items of the shard sorted by their key
+func (shard *ShardedIncomingDataCache) items() ([]CacheItem, error) {
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+	items := make([]CacheItem, 0, len(shard.plugin))
+	for key, metric := range shard.plugin {
+		data, err := json.Marshal(metric)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, CacheItem{
+			Key:        key,
+			DataSource: metric.GetDataSourceName(),
+			New:        metric.ISNew(),
+			Updated:    shard.updated[key],
+			Created:    shard.created[key].Unix(),
+			Metric:     data,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	return items, nil
+}
+
+//ListHosts returns summaries of all cached hosts sorted by host name
+func (i *IncomingDataCache) ListHosts() []HostSummary {
+	lock, allHosts := i.GetHosts()
+	defer lock.Unlock()
+	hosts := make([]HostSummary, 0, len(allHosts))
+	for host, shard := range allHosts {
+		hosts = append(hosts, shard.summary(host))
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+	return hosts
+}
+
+//GetHostItems returns cached items of given host. Returns false in case the host is not cached.
+func (i *IncomingDataCache) GetHostItems(host string) ([]CacheItem, bool, error) {
+	lock, allHosts := i.GetHosts()
+	shard, ok := allHosts[host]
+	lock.Unlock()
+	if !ok {
+		return nil, false, nil
+	}
+	items, err := shard.items()
+	return items, true, err
+}
+
+//EvictHost removes all cached data of given host. Returns false in case the host is not cached.
+func (i *IncomingDataCache) EvictHost(host string) bool {
+	lock, allHosts := i.GetHosts()
+	defer lock.Unlock()
+	if _, ok := allHosts[host]; !ok {
+		return false
+	}
+	delete(allHosts, host)
+	logger.Info("Evicted host from cache", "host", host)
+	return true
+}
+
+//EvictItem removes cached item of given host identified by its key. Host is removed as well in case
+//it has no other items. Returns false in case the item is not cached.
+func (i *IncomingDataCache) EvictItem(host string, itemKey string) bool {
+	lock, allHosts := i.GetHosts()
+	defer lock.Unlock()
+	shard, ok := allHosts[host]
+	if !ok {
+		return false
+	}
+	shard.lock.Lock()
+	_, ok = shard.plugin[itemKey]
+	shard.deleteItem(itemKey)
+	empty := len(shard.plugin) == 0
+	shard.lock.Unlock()
+	if empty {
+		delete(allHosts, host)
+	}
+	if ok {
+		logger.Info("Evicted item from cache", "host", host, "item", itemKey)
+	}
+	return ok
+}
+
+//ExpireStale removes items which were not updated for longer than their TTL together with hosts left
+//without items, even when the items were not exported yet. Returns count of removed items.
+func (i *IncomingDataCache) ExpireStale() int {
+	lock, allHosts := i.GetHosts()
+	defer lock.Unlock()
+	count := 0
+	for host, shard := range allHosts {
+		shard.lock.Lock()
+		count += shard.expireItems()
+		empty := len(shard.plugin) == 0
+		shard.lock.Unlock()
+		if empty {
+			delete(allHosts, host)
+			logger.Info("Cleaned up host", "host", host)
+		}
+	}
+	return count
+}
@@ -1,29 +1,28 @@
 package cacheutil
 
 import (
-	"log"
 	"sync"
 	"time"
 
+	"github.com/infrawatch/smart-gateway/internal/pkg/logging"
 	"github.com/infrawatch/smart-gateway/internal/pkg/metrics/incoming"
 )
 
 // MAXTTL to remove plugin is stale for 5
 var MAXTTL int64 = 300
-var freeList = make(chan *IncomingBuffer, 1000)
-var debugc = func(format string, data ...interface{}) {} // Default no debugging output
 
-//ApplicationHealthCache  ...
-type ApplicationHealthCache struct {
-	QpidRouterState    int
-	ElasticSearchState int
-	LastAccess         int64 //timestamp in seconds
-}
+// intervalsToExpire is count of collection intervals after which not updated item is considered stale
+// in case it is longer time than TTL of the cache
+const intervalsToExpire = 3
+
+var freeList = make(chan *IncomingBuffer, 1000)
+var logger = logging.New("cacheutil")
 
 //IncomingBuffer  this is inut data send to cache server
 //IncomingBuffer  ..its of type collectd or anything else
 type IncomingBuffer struct {
-	data incoming.MetricDataFormat
+	data    incoming.MetricDataFormat
+	flushed chan bool // marks flush request, closed when all preceding data were stored
 }
 
 //IncomingDataCache cache server converts it into this
@@ -37,20 +36,15 @@ type IncomingDataCache struct {
 //ShardedIncomingDataCache  ..
 type ShardedIncomingDataCache struct {
 	plugin     map[string]incoming.MetricDataFormat
+	updated    map[string]int64
+	created    map[string]time.Time
+	counters   map[string]*counterState
 	lastAccess int64
 	maxTTL     int64
+	restored   bool // true when shard contains only data restored from snapshot
 	lock       *sync.RWMutex
 }
 
-//NewApplicationHealthCache  ..
-func NewApplicationHealthCache() *ApplicationHealthCache {
-	return &ApplicationHealthCache{
-		QpidRouterState:    0,
-		LastAccess:         0,
-		ElasticSearchState: 0,
-	}
-}
-
 //NewCache   .. .
 func NewCache(maxttl int64) IncomingDataCache {
 	if maxttl == 0 {
@@ -66,9 +60,12 @@ func NewCache(maxttl int64) IncomingDataCache {
 //NewShardedIncomingDataCache   .
 func NewShardedIncomingDataCache(maxttl int64) *ShardedIncomingDataCache {
 	return &ShardedIncomingDataCache{
-		plugin: make(map[string]incoming.MetricDataFormat),
-		maxTTL: maxttl,
-		lock:   new(sync.RWMutex),
+		plugin:   make(map[string]incoming.MetricDataFormat),
+		updated:  make(map[string]int64),
+		created:  make(map[string]time.Time),
+		counters: make(map[string]*counterState),
+		maxTTL:   maxttl,
+		lock:     new(sync.RWMutex),
 	}
 }
 
@@ -87,7 +84,7 @@ func (i *IncomingDataCache) FlushAll() {
 	}
 	for _, key := range willDelete {
 		delete(allHosts, key)
-		log.Printf("Cleaned up host for %s", key)
+		logger.Info("Cleaned up host", "host", key)
 	}
 }
 
@@ -107,19 +104,54 @@ func (i IncomingDataCache) GetHosts() (*sync.RWMutex, map[string]*ShardedIncomin
 
 //GetLastAccess ..Get last access time ...
 func (shard *ShardedIncomingDataCache) GetLastAccess() int64 {
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
 	return shard.lastAccess
 }
 
+//Restored returns true if the shard contains data restored from snapshot and no new data arrived since
+func (shard *ShardedIncomingDataCache) Restored() bool {
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+	return shard.restored
+}
+
 //Expired  ... add expired test
 func (shard *ShardedIncomingDataCache) Expired() bool {
 	//clean up if data is not access for max TTL specified
 	return time.Now().Unix()-shard.GetLastAccess() > int64(shard.maxTTL)
 }
 
-//GetShard  ..
+//itemExpired returns true if the item was not updated for longer time than TTL of the shard
+//or intervalsToExpire of item's collection intervals, whichever is longer. Shard has to be locked.
+func (shard *ShardedIncomingDataCache) itemExpired(item incoming.MetricDataFormat) bool {
+	ttl := shard.maxTTL
+	if intervalTTL := int64(item.GetInterval() * intervalsToExpire); intervalTTL > ttl {
+		ttl = intervalTTL
+	}
+	return time.Now().Unix()-shard.updated[item.GetItemKey()] > ttl
+}
+
+//deleteItem removes item from shard. Shard has to be locked.
+func (shard *ShardedIncomingDataCache) deleteItem(itemKey string) {
+	delete(shard.plugin, itemKey)
+	delete(shard.updated, itemKey)
+	delete(shard.created, itemKey)
+	delete(shard.counters, itemKey)
+}
+
+//GetShard returns shard of given host, the shard is created in case it does not exist
 func (i IncomingDataCache) GetShard(key string) *ShardedIncomingDataCache {
+	i.lock.RLock()
+	shard := i.hosts[key]
+	i.lock.RUnlock()
+	if shard != nil {
+		return shard
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
 	if i.hosts[key] == nil {
-		i.Put(key)
+		i.hosts[key] = NewShardedIncomingDataCache(i.maxTTL)
 	}
 	return i.hosts[key]
 }
@@ -152,10 +184,24 @@ func (shard *ShardedIncomingDataCache) SetData(data incoming.MetricDataFormat) e
 	defer shard.lock.Unlock()
 	if shard.plugin[data.GetItemKey()] == nil {
 		shard.plugin[data.GetItemKey()] = incoming.NewFromDataSourceName(data.GetDataSourceName())
+		shard.created[data.GetItemKey()] = time.Now()
 	}
 	shard.lastAccess = time.Now().Unix()
+	shard.restored = false
+	shard.updated[data.GetItemKey()] = shard.lastAccess
 	metric := shard.plugin[data.GetItemKey()]
 	metric.SetData(data)
+	if collectd, ok := metric.(*incoming.CollectdMetric); ok && hasCounters(collectd) {
+		state, ok := shard.counters[data.GetItemKey()]
+		if !ok {
+			state = &counterState{}
+			shard.counters[data.GetItemKey()] = state
+		}
+		if state.update(collectd) {
+			// reset counters start over, so their creation time is reset too
+			shard.created[data.GetItemKey()] = time.Now()
+		}
+	}
 	return nil
 }
 
@@ -176,9 +222,6 @@ func NewCacheServer(maxTTL int64, debug bool) *CacheServer {
 		cache: NewCache(maxTTL),
 		ch:    make(chan *IncomingBuffer, 1000),
 	}
-	if debug {
-		debugc = func(format string, data ...interface{}) { log.Printf(format, data...) }
-	}
 	// Spawn off the server's main loop immediately
 	go server.loop()
 	return server
@@ -197,11 +240,22 @@ func (cs *CacheServer) Put(incomingData incoming.MetricDataFormat) {
 	cs.ch <- buffer
 }
 
+//Flush waits until all data passed to Put before were stored to cache
+func (cs *CacheServer) Flush() {
+	flushed := make(chan bool)
+	cs.ch <- &IncomingBuffer{flushed: flushed}
+	<-flushed
+}
+
 func (cs CacheServer) loop() {
-	debugc("Debug:CacheServer loop started")
+	logger.Debug("Cache server loop started")
 	for {
 		// Reuse buffer if there's room.
 		buffer := <-cs.ch
+		if buffer.flushed != nil {
+			close(buffer.flushed)
+			continue
+		}
 		shard := cs.cache.GetShard(buffer.data.GetKey())
 		shard.SetData(buffer.data)
 		select {
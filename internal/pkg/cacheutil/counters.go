@@ -0,0 +1,124 @@
+package cacheutil
+
+import (
+	"math"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/metrics/incoming"
+)
+
+const (
+	// wrap limits used for detection of counter overflow, analogically to collectd's counter_diff
+	counter32Max = float64(math.MaxUint32)
+	counter64Max = float64(math.MaxUint64)
+	// decrease of counter is considered wrap around only if previous value was within this
+	// fraction of the limit, otherwise the counter was reset
+	wrapWindow = 1.0 / 16
+)
+
+//counterState holds previous values of collectd counter and derive data sources of single series
+//and per-second rates computed from them
+type counterState struct {
+	raw    []float64
+	offset []float64
+	rates  []float64
+	valid  []bool
+	time   float64 // timestamp of previous values in seconds
+}
+
+//hasCounters returns true if the metric contains any counter or derive data source
+func hasCounters(metric *incoming.CollectdMetric) bool {
+	for _, dstype := range metric.Dstypes {
+		if dstype == "counter" || dstype == "derive" {
+			return true
+		}
+	}
+	return false
+}
+
+//counterDiff returns difference between two raw collectd counter values. Counters are unsigned
+//so smaller new value means the counter wrapped around its 32-bit or 64-bit limit in case the old
+//value was close to the limit. Second returned value is the amount which needs to be added to values
+//after the wrap around. Any other decrease means the counter was reset, which is reported by the third
+//returned value.
+func counterDiff(old, new float64) (diff float64, wrapOffset float64, reset bool) {
+	if new >= old {
+		return new - old, 0, false
+	}
+	for _, limit := range []float64{counter32Max, counter64Max} {
+		if old <= limit && limit-old <= limit*wrapWindow {
+			return limit - old + new + 1, limit + 1, false
+		}
+	}
+	return 0, 0, true
+}
+
+//update tracks values of given metric and corrects values of counter data sources
+//so that they are monotonic even if the counter wraps around. Reset counters start
+//from their raw values again and their rate is not available until next value. Returns true
+//in case any counter was reset.
+func (state *counterState) update(metric *incoming.CollectdMetric) (reset bool) {
+	count := len(metric.Values)
+	if len(metric.Dstypes) < count {
+		count = len(metric.Dstypes)
+	}
+	now := float64(metric.Time.Time().UnixNano()) / 1e9
+	if len(state.raw) != count {
+		// new series or changed data source count, so start tracking from scratch
+		state.raw = make([]float64, count)
+		state.offset = make([]float64, count)
+		state.rates = make([]float64, count)
+		state.valid = make([]bool, count)
+		copy(state.raw, metric.Values)
+		state.time = now
+		return false
+	}
+
+	elapsed := now - state.time
+	values := make([]float64, len(metric.Values))
+	copy(values, metric.Values)
+	for index := 0; index < count; index++ {
+		var diff float64
+		raw := metric.Values[index]
+		switch metric.Dstypes[index] {
+		case "counter":
+			var (
+				wrap    float64
+				restart bool
+			)
+			diff, wrap, restart = counterDiff(state.raw[index], raw)
+			if restart {
+				state.offset[index] = 0
+				state.raw[index] = raw
+				state.valid[index] = false
+				reset = true
+				continue
+			}
+			state.offset[index] += wrap
+			values[index] = raw + state.offset[index]
+		case "derive":
+			// derive is signed, so decreasing value results in negative rate
+			diff = raw - state.raw[index]
+		default:
+			state.valid[index] = false
+			continue
+		}
+		state.raw[index] = raw
+		if elapsed > 0 {
+			state.rates[index] = diff / elapsed
+			state.valid[index] = true
+		} else {
+			state.valid[index] = false
+		}
+	}
+	state.time = now
+	metric.Values = values
+	return reset
+}
+
+//rate returns per-second rate of the data source on given index and true if the rate is available
+func (state *counterState) rate(index int) (float64, bool) {
+	if state == nil || index >= len(state.valid) || !state.valid[index] {
+		return 0, false
+	}
+	return state.rates[index], true
+}
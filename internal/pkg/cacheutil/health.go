@@ -0,0 +1,260 @@
+package cacheutil
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/logging"
+	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
+)
+
+//ApplicationHealthCache  ...
+type ApplicationHealthCache struct {
+	QpidRouterState    int
+	ElasticSearchState int
+	LastAccess         int64 //timestamp in seconds
+
+	lock                    sync.RWMutex
+	started                 time.Time
+	connections             map[string]*connectionHealth
+	lastMessage             time.Time
+	elasticEnabled          bool
+	elasticFailures         int
+	alertManagerEnabled     bool
+	alertManagerFailures    int
+	alertManagerLastError   string
+	maxMessageAge           time.Duration
+	maxElasticFailures      int
+	maxAlertManagerFailures int
+}
+
+//connectionHealth holds state of single AMQP1.0 connection
+type connectionHealth struct {
+	url         string
+	dataSource  string
+	connected   bool
+	lastMessage time.Time
+}
+
+//ConnectionReport describes state of single AMQP1.0 connection in health report
+type ConnectionReport struct {
+	URL            string   `json:"url"`
+	DataSource     string   `json:"datasource"`
+	Connected      bool     `json:"connected"`
+	LastMessageAge *float64 `json:"last_message_age_seconds,omitempty"`
+}
+
+//OutputReport describes state of output (eg. Elasticsearch) in health report
+type OutputReport struct {
+	Healthy             bool   `json:"healthy"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	LastError           string `json:"last_error,omitempty"`
+}
+
+//HealthReport describes health of the application and its dependencies
+type HealthReport struct {
+	Ready          bool               `json:"ready"`
+	Problems       []string           `json:"problems"`
+	Connections    []ConnectionReport `json:"connections"`
+	LastMessageAge *float64           `json:"last_message_age_seconds,omitempty"`
+	Elasticsearch  *OutputReport      `json:"elasticsearch,omitempty"`
+	AlertManager   *OutputReport      `json:"alertmanager,omitempty"`
+}
+
+//NewApplicationHealthCache  ..
+func NewApplicationHealthCache() *ApplicationHealthCache {
+	return &ApplicationHealthCache{
+		QpidRouterState:    0,
+		LastAccess:         0,
+		ElasticSearchState: 0,
+		started:            time.Now(),
+		connections:        make(map[string]*connectionHealth),
+	}
+}
+
+//connectionKey returns key identifying connection of given data source and URL
+func connectionKey(url string, dataSource string) string {
+	return fmt.Sprintf("%s|%s", dataSource, url)
+}
+
+//SetReadiness sets thresholds crossing of which makes the application not ready
+func (ahc *ApplicationHealthCache) SetReadiness(readiness saconfig.ReadinessConfig) {
+	ahc.lock.Lock()
+	defer ahc.lock.Unlock()
+	ahc.maxMessageAge = time.Duration(readiness.MaxMessageAge) * time.Second
+	ahc.maxElasticFailures = readiness.MaxElasticFailures
+	ahc.maxAlertManagerFailures = readiness.MaxAlertManagerFailures
+}
+
+//AddConnection starts tracking state of AMQP1.0 connection to given URL
+func (ahc *ApplicationHealthCache) AddConnection(url string, dataSource string) {
+	ahc.lock.Lock()
+	defer ahc.lock.Unlock()
+	key := connectionKey(url, dataSource)
+	if _, ok := ahc.connections[key]; !ok {
+		ahc.connections[key] = &connectionHealth{url: logging.RedactURL(url), dataSource: dataSource}
+	}
+}
+
+//States returns aggregated state of AMQP1.0 connections and state of Elasticsearch
+func (ahc *ApplicationHealthCache) States() (int, int) {
+	ahc.lock.RLock()
+	defer ahc.lock.RUnlock()
+	return ahc.QpidRouterState, ahc.ElasticSearchState
+}
+
+//RetainConnections stops tracking state of AMQP1.0 connections for which keep returns false
+func (ahc *ApplicationHealthCache) RetainConnections(keep func(url string, dataSource string) bool) {
+	ahc.lock.Lock()
+	defer ahc.lock.Unlock()
+	for key, conn := range ahc.connections {
+		if !keep(conn.url, conn.dataSource) {
+			delete(ahc.connections, key)
+		}
+	}
+	ahc.updateQpidRouterState()
+}
+
+//SetConnectionStatus records status of AMQP1.0 connection to given URL (1 connected, 0 disconnected)
+func (ahc *ApplicationHealthCache) SetConnectionStatus(url string, dataSource string, status int) {
+	ahc.lock.Lock()
+	defer ahc.lock.Unlock()
+	key := connectionKey(url, dataSource)
+	conn, ok := ahc.connections[key]
+	if !ok {
+		conn = &connectionHealth{url: logging.RedactURL(url), dataSource: dataSource}
+		ahc.connections[key] = conn
+	}
+	conn.connected = status > 0
+	ahc.updateQpidRouterState()
+}
+
+//updateQpidRouterState sets aggregated router state, which is 1 only if all connections are connected
+func (ahc *ApplicationHealthCache) updateQpidRouterState() {
+	state := 1
+	for _, conn := range ahc.connections {
+		if !conn.connected {
+			state = 0
+		}
+	}
+	if len(ahc.connections) == 0 {
+		state = 0
+	}
+	ahc.QpidRouterState = state
+}
+
+//MessageReceived records time of last message received on AMQP1.0 connection to given URL
+func (ahc *ApplicationHealthCache) MessageReceived(url string, dataSource string) {
+	now := time.Now()
+	ahc.lock.Lock()
+	defer ahc.lock.Unlock()
+	if conn, ok := ahc.connections[connectionKey(url, dataSource)]; ok {
+		conn.lastMessage = now
+	}
+	ahc.lastMessage = now
+}
+
+//SetElasticSearchResult records result of the last Elasticsearch request
+func (ahc *ApplicationHealthCache) SetElasticSearchResult(err error) {
+	ahc.lock.Lock()
+	defer ahc.lock.Unlock()
+	if err != nil {
+		ahc.ElasticSearchState = 0
+		ahc.elasticFailures++
+	} else {
+		ahc.ElasticSearchState = 1
+		ahc.elasticFailures = 0
+	}
+}
+
+//EnableElasticSearch marks Elasticsearch as configured output, so that it is included in health report
+func (ahc *ApplicationHealthCache) EnableElasticSearch(enabled bool) {
+	ahc.lock.Lock()
+	defer ahc.lock.Unlock()
+	ahc.elasticEnabled = enabled
+}
+
+//EnableAlertManager marks Alertmanager as configured output, so that it is included in health report
+func (ahc *ApplicationHealthCache) EnableAlertManager(enabled bool) {
+	ahc.lock.Lock()
+	defer ahc.lock.Unlock()
+	ahc.alertManagerEnabled = enabled
+}
+
+//SetAlertManagerResult records result of the last Alertmanager notification
+func (ahc *ApplicationHealthCache) SetAlertManagerResult(err error) {
+	ahc.lock.Lock()
+	defer ahc.lock.Unlock()
+	if err != nil {
+		ahc.alertManagerFailures++
+		ahc.alertManagerLastError = err.Error()
+	} else {
+		ahc.alertManagerFailures = 0
+		ahc.alertManagerLastError = ""
+	}
+}
+
+//Report returns health report of the application. Application is not ready in case any AMQP1.0
+//connection is not connected or any of configured thresholds is crossed.
+func (ahc *ApplicationHealthCache) Report() HealthReport {
+	ahc.lock.RLock()
+	defer ahc.lock.RUnlock()
+	now := time.Now()
+	age := func(t time.Time) *float64 {
+		if t.IsZero() {
+			return nil
+		}
+		seconds := now.Sub(t).Seconds()
+		return &seconds
+	}
+
+	report := HealthReport{Problems: []string{}, Connections: []ConnectionReport{}}
+	for _, conn := range ahc.connections {
+		report.Connections = append(report.Connections, ConnectionReport{
+			URL:            conn.url,
+			DataSource:     conn.dataSource,
+			Connected:      conn.connected,
+			LastMessageAge: age(conn.lastMessage),
+		})
+		if !conn.connected {
+			report.Problems = append(report.Problems, fmt.Sprintf("AMQP1.0 %s connection to %s is not established", conn.dataSource, conn.url))
+		}
+	}
+	sort.Slice(report.Connections, func(i, j int) bool {
+		return connectionKey(report.Connections[i].URL, report.Connections[i].DataSource) < connectionKey(report.Connections[j].URL, report.Connections[j].DataSource)
+	})
+	sort.Strings(report.Problems)
+
+	report.LastMessageAge = age(ahc.lastMessage)
+	if ahc.maxMessageAge > 0 && len(ahc.connections) > 0 {
+		// age is counted from the start in case no message was received yet
+		since := ahc.lastMessage
+		if since.IsZero() {
+			since = ahc.started
+		}
+		if now.Sub(since) > ahc.maxMessageAge {
+			report.Problems = append(report.Problems, fmt.Sprintf("no message received for more than %s", ahc.maxMessageAge))
+		}
+	}
+
+	if ahc.elasticEnabled {
+		report.Elasticsearch = &OutputReport{Healthy: ahc.ElasticSearchState == 1, ConsecutiveFailures: ahc.elasticFailures}
+		if ahc.maxElasticFailures > 0 && ahc.elasticFailures >= ahc.maxElasticFailures {
+			report.Problems = append(report.Problems, fmt.Sprintf("%d consecutive Elasticsearch request(s) failed", ahc.elasticFailures))
+		}
+	}
+	if ahc.alertManagerEnabled {
+		report.AlertManager = &OutputReport{
+			Healthy:             ahc.alertManagerFailures == 0,
+			ConsecutiveFailures: ahc.alertManagerFailures,
+			LastError:           ahc.alertManagerLastError,
+		}
+		if ahc.maxAlertManagerFailures > 0 && ahc.alertManagerFailures >= ahc.maxAlertManagerFailures {
+			report.Problems = append(report.Problems, fmt.Sprintf("%d consecutive Alertmanager notification(s) failed", ahc.alertManagerFailures))
+		}
+	}
+	report.Ready = len(report.Problems) == 0
+	return report
+}
@@ -1,8 +1,7 @@
 package cacheutil
 
 import (
-	"log"
-
+	"github.com/infrawatch/smart-gateway/internal/pkg/metrics/incoming"
 	"github.com/infrawatch/smart-gateway/internal/pkg/tsdb"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -11,7 +10,7 @@ import (
 func AddHeartBeat(instance string, value float64, ch chan<- prometheus.Metric) {
 	m, err := tsdb.NewHeartBeatMetricByHost(instance, value)
 	if err != nil {
-		log.Printf("newHeartBeat: %v for %s", err, instance)
+		logger.Error("Failed to create heartbeat metric", "host", instance, "error", err)
 	}
 	ch <- m
 }
@@ -20,33 +19,28 @@ func AddHeartBeat(instance string, value float64, ch chan<- prometheus.Metric) {
 func AddMetricsByHostCount(instance string, value float64, ch chan<- prometheus.Metric) {
 	m, err := tsdb.AddMetricsByHost(instance, value)
 	if err != nil {
-		log.Printf("AddMetricsByHost: %v for %s", err, instance)
+		logger.Error("Failed to create metrics count metric", "host", instance, "error", err)
 	}
 	ch <- m
 }
 
-//FlushPrometheusMetric   generate Prometheus metrics
-func (shard *ShardedIncomingDataCache) FlushPrometheusMetric(usetimestamp bool, ch chan<- prometheus.Metric) int {
+//FlushPrometheusMetric   generate Prometheus metrics. Per-second rate gauges are generated
+//for collectd counter and derive data sources in case exportRates is true. Counters carry
+//the time when the item was added to cache as their created timestamp. Each item is exported
+//only once after it was updated.
+func (shard *ShardedIncomingDataCache) FlushPrometheusMetric(usetimestamp bool, exportRates bool, ch chan<- prometheus.Metric) int {
 	shard.lock.Lock()
 	defer shard.lock.Unlock()
 	minMetricCreated := 0 //..minimum of one metrics created
 
 	for _, dataInterface := range shard.plugin {
 		if dataInterface.ISNew() {
+			minMetricCreated += shard.writeMetrics(usetimestamp, exportRates, nil, dataInterface, ch)
 			dataInterface.SetNew(false)
-			for index := range dataInterface.GetValues() {
-				m, err := tsdb.NewPrometheusMetric(usetimestamp, dataInterface.GetDataSourceName(), dataInterface, index)
-				if err != nil {
-					log.Printf("newMetric: %v", err)
-					continue
-				}
-				ch <- m
-				minMetricCreated++
-			}
 		} else {
 			//clean up if data is not access for max TTL specified
-			if shard.Expired() {
-				delete(shard.plugin, dataInterface.GetItemKey())
+			if shard.itemExpired(dataInterface) {
+				shard.deleteItem(dataInterface.GetItemKey())
 			}
 		}
 
@@ -54,6 +48,50 @@ func (shard *ShardedIncomingDataCache) FlushPrometheusMetric(usetimestamp bool,
 	return minMetricCreated
 }
 
+//CollectFilteredPrometheusMetric generates Prometheus metrics of the last values of all cached data
+//sources matching given filter, regardless whether they were already exported. Cache is not modified,
+//so the metrics stay available for other scrapers. Nil filter matches everything.
+func (shard *ShardedIncomingDataCache) CollectFilteredPrometheusMetric(usetimestamp bool, exportRates bool, filter tsdb.MetricFilter, ch chan<- prometheus.Metric) int {
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+	count := 0
+	for _, dataInterface := range shard.plugin {
+		count += shard.writeMetrics(usetimestamp, exportRates, filter, dataInterface, ch)
+	}
+	return count
+}
+
+//writeMetrics generates Prometheus metrics of data sources of given item matching given filter
+//and returns their count. Rate gauges are generated for matching data sources. Shard has to be locked.
+func (shard *ShardedIncomingDataCache) writeMetrics(usetimestamp bool, exportRates bool, filter tsdb.MetricFilter, dataInterface incoming.MetricDataFormat, ch chan<- prometheus.Metric) int {
+	count := 0
+	for index := range dataInterface.GetValues() {
+		if filter != nil && !filter.Matches(tsdb.GetMetricIdentity(dataInterface, index)) {
+			continue
+		}
+		m, err := tsdb.NewPrometheusMetricWithCreated(usetimestamp, dataInterface.GetDataSourceName(), dataInterface, index, shard.created[dataInterface.GetItemKey()])
+		if err != nil {
+			logger.Error("Failed to create metric", "host", dataInterface.GetKey(), "item", dataInterface.GetItemKey(), "error", err)
+			continue
+		}
+		ch <- m
+		count++
+
+		if !exportRates {
+			continue
+		}
+		if rate, ok := shard.counters[dataInterface.GetItemKey()].rate(index); ok {
+			m, err := tsdb.NewPrometheusRateMetric(usetimestamp, dataInterface.(*incoming.CollectdMetric), index, rate)
+			if err != nil {
+				logger.Error("Failed to create rate metric", "host", dataInterface.GetKey(), "item", dataInterface.GetItemKey(), "error", err)
+				continue
+			}
+			ch <- m
+		}
+	}
+	return count
+}
+
 //FlushAllMetrics   Generic Flushing metrics not used.. used only for testing
 func (shard *ShardedIncomingDataCache) FlushAllMetrics() {
 	shard.lock.Lock()
@@ -61,13 +99,28 @@ func (shard *ShardedIncomingDataCache) FlushAllMetrics() {
 	for _, dataInterface := range shard.plugin {
 		if dataInterface.ISNew() {
 			dataInterface.SetNew(false)
-			log.Printf("New Metrics %#v\n", dataInterface)
+			logger.Debug("New metric", "host", dataInterface.GetKey(), "item", dataInterface.GetItemKey())
 		} else {
 			//clean up if data is not access for max TTL specified
-			if shard.Expired() {
-				delete(shard.plugin, dataInterface.GetItemKey())
-				log.Printf("Cleaned up plugin for %s", dataInterface.GetItemKey())
+			if shard.itemExpired(dataInterface) {
+				shard.deleteItem(dataInterface.GetItemKey())
+				logger.Info("Cleaned up plugin", "host", dataInterface.GetKey(), "item", dataInterface.GetItemKey())
 			}
 		}
 	}
 }
+
+//expireItems removes items which were not updated for longer than their TTL regardless whether they were
+//flushed already. Returns count of removed items. Shard has to be locked.
+func (shard *ShardedIncomingDataCache) expireItems() int {
+	count := 0
+	for key, dataInterface := range shard.plugin {
+		//clean up if data is not access for max TTL specified
+		if shard.itemExpired(dataInterface) {
+			shard.deleteItem(key)
+			logger.Info("Cleaned up plugin", "host", dataInterface.GetKey(), "item", key)
+			count++
+		}
+	}
+	return count
+}
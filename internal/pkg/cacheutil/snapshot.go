@@ -0,0 +1,231 @@
+package cacheutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/metrics/incoming"
+)
+
+// snapshotVersion is version of cache snapshot format. It has to be increased on every
+// change of the snapshot structures below. Version 1 snapshots do not contain counter state.
+const (
+	snapshotVersion    = 2
+	minSnapshotVersion = 1
+)
+
+// DefaultSnapshotInterval is interval of periodic cache snapshots used when none is configured
+const DefaultSnapshotInterval = 60 * time.Second
+
+//cacheSnapshot is the content of cache snapshot file
+type cacheSnapshot struct {
+	Version   int                       `json:"version"`
+	Timestamp int64                     `json:"timestamp"`
+	Hosts     map[string][]snapshotItem `json:"hosts"`
+}
+
+//snapshotItem holds single cached item together with its cache metadata
+type snapshotItem struct {
+	DataSource string           `json:"datasource"`
+	Updated    int64            `json:"updated"`
+	Created    int64            `json:"created"`
+	Metric     json.RawMessage  `json:"metric"`
+	Counters   *counterSnapshot `json:"counters,omitempty"`
+}
+
+//counterSnapshot holds state of collectd counters of single item, so that values corrected
+//after wrap around stay monotonic after restart
+type counterSnapshot struct {
+	Raw    []float64 `json:"raw"`
+	Offset []float64 `json:"offset"`
+	Time   float64   `json:"time"`
+}
+
+//snapshot returns state of the counters in snapshot form
+func (state *counterState) snapshot() *counterSnapshot {
+	if state == nil {
+		return nil
+	}
+	// state is updated in place, so it is copied for marshalling outside of shard lock
+	return &counterSnapshot{
+		Raw:    append([]float64(nil), state.raw...),
+		Offset: append([]float64(nil), state.offset...),
+		Time:   state.time,
+	}
+}
+
+//restore returns counter state of the snapshot. Rates are not available until next values arrive.
+func (cs *counterSnapshot) restore() (*counterState, error) {
+	if len(cs.Raw) != len(cs.Offset) {
+		return nil, fmt.Errorf("counter state has %d raw values and %d offsets", len(cs.Raw), len(cs.Offset))
+	}
+	return &counterState{
+		raw:    cs.Raw,
+		offset: cs.Offset,
+		rates:  make([]float64, len(cs.Raw)),
+		valid:  make([]bool, len(cs.Raw)),
+		time:   cs.Time,
+	}, nil
+}
+
+//WriteSnapshot stores content of the cache to given file. The file is replaced atomically
+//so that incomplete snapshot is never left behind.
+func (i *IncomingDataCache) WriteSnapshot(path string) error {
+	snapshot := cacheSnapshot{
+		Version:   snapshotVersion,
+		Timestamp: time.Now().Unix(),
+		Hosts:     make(map[string][]snapshotItem),
+	}
+	lock, allHosts := i.GetHosts()
+	for host, shard := range allHosts {
+		items, err := shard.snapshot()
+		if err != nil {
+			lock.Unlock()
+			return fmt.Errorf("failed to snapshot host %s: %s", host, err)
+		}
+		snapshot.Hosts[host] = items
+	}
+	lock.Unlock()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+//RestoreSnapshot loads cache content from given snapshot file and returns count of restored items.
+//Missing snapshot file is not considered as an error. Restored items are not exported as new data,
+//but hosts having restored data report heartbeat until real data arrives or the data expires.
+func (i *IncomingDataCache) RestoreSnapshot(path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	snapshot := cacheSnapshot{}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return 0, fmt.Errorf("failed to parse snapshot: %s", err)
+	}
+	if snapshot.Version < minSnapshotVersion || snapshot.Version > snapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %d (expected %d to %d)", snapshot.Version, minSnapshotVersion, snapshotVersion)
+	}
+
+	count := 0
+	for host, items := range snapshot.Hosts {
+		shard := i.GetShard(host)
+		restored, err := shard.restore(items)
+		if err != nil {
+			return count, fmt.Errorf("failed to restore host %s: %s", host, err)
+		}
+		count += restored
+	}
+	return count, nil
+}
+
+//snapshot returns all items of the shard in snapshot form
+func (shard *ShardedIncomingDataCache) snapshot() ([]snapshotItem, error) {
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+	items := make([]snapshotItem, 0, len(shard.plugin))
+	for key, metric := range shard.plugin {
+		data, err := json.Marshal(metric)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, snapshotItem{
+			DataSource: metric.GetDataSourceName(),
+			Updated:    shard.updated[key],
+			Created:    shard.created[key].Unix(),
+			Metric:     data,
+			Counters:   shard.counters[key].snapshot(),
+		})
+	}
+	return items, nil
+}
+
+//restore adds given snapshot items to the shard. Items which were updated in the meantime are skipped.
+func (shard *ShardedIncomingDataCache) restore(items []snapshotItem) (int, error) {
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	count := 0
+	for _, item := range items {
+		metric := incoming.NewFromDataSourceName(item.DataSource)
+		if metric == nil {
+			return count, fmt.Errorf("unknown data source '%s'", item.DataSource)
+		}
+		if err := json.Unmarshal(item.Metric, metric); err != nil {
+			return count, err
+		}
+		key := metric.GetItemKey()
+		if _, ok := shard.plugin[key]; ok {
+			continue
+		}
+		if item.Counters != nil {
+			state, err := item.Counters.restore()
+			if err != nil {
+				return count, err
+			}
+			shard.counters[key] = state
+		}
+		metric.SetNew(false)
+		shard.plugin[key] = metric
+		shard.updated[key] = item.Updated
+		shard.created[key] = time.Unix(item.Created, 0)
+		if item.Updated > shard.lastAccess {
+			shard.lastAccess = item.Updated
+		}
+		count++
+	}
+	shard.restored = shard.restored || count > 0
+	return count, nil
+}
+
+//SpawnSnapshotWriter spawns goroutine which periodically stores snapshot of the cache to given file.
+//Final snapshot is stored when finish channel is closed.
+func (cs *CacheServer) SpawnSnapshotWriter(wg *sync.WaitGroup, finish chan bool, path string, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultSnapshotInterval
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := cs.cache.WriteSnapshot(path); err != nil {
+					logger.Error("Failed to write cache snapshot", "path", path, "error", err)
+				}
+			case <-finish:
+				if err := cs.cache.WriteSnapshot(path); err != nil {
+					logger.Error("Failed to write cache snapshot", "path", path, "error", err)
+				}
+				return
+			}
+		}
+	}()
+}
@@ -2,23 +2,22 @@ package events
 
 import (
 	"bytes"
-	"context"
-	"flag"
+	"crypto/tls"
 	"fmt"
 	"io/ioutil"
-	"log"
-	"math/rand"
 	"net/http"
 	"os"
 	"reflect"
-	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
-	"github.com/MakeNowJust/heredoc"
 	"github.com/infrawatch/smart-gateway/internal/pkg/amqp10"
 	"github.com/infrawatch/smart-gateway/internal/pkg/api"
 	"github.com/infrawatch/smart-gateway/internal/pkg/cacheutil"
 	"github.com/infrawatch/smart-gateway/internal/pkg/events/incoming"
+	"github.com/infrawatch/smart-gateway/internal/pkg/logging"
 	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
 	"github.com/infrawatch/smart-gateway/internal/pkg/saelastic"
 	"github.com/prometheus/client_golang/prometheus"
@@ -39,165 +38,85 @@ const (
 		<ul>
 			<li>/alerts POST alerts in JSON format on to AMQP message bus</li>
 			<li>/metrics GET metric data</li>
+			<li>/healthz GET health of the gateway and its dependencies</li>
+			<li>/readyz GET readiness of the gateway, responds with 503 when not ready</li>
 		</ul>
 	</body>
 </html>
 `
 )
 
-/*************** main routine ***********************/
-// eventusage and command-line flags
-func eventusage() {
-	doc := heredoc.Doc(`
-  For running with config file use
-	********************* config *********************
-	$go run cmd/main.go -config smartgateway_config.json -servicetype events
-	**************************************************`)
-
-	fmt.Fprintln(os.Stderr, `Required command line argument missing`)
-	fmt.Fprintln(os.Stdout, doc)
-	flag.PrintDefaults()
+var (
+	logger = logging.New("events")
+	//parseFailures limits logging of parse failures to one record per connection and minute
+	parseFailures = logging.NewSampler(time.Minute)
+)
+
+//EventStore stores processed events, it is implemented by Elasticsearch client
+type EventStore interface {
+	//Create stores given document to given index and returns its ID
+	Create(indexname string, indextype string, jsondata interface{}) (string, error)
 }
 
-var debuge = func(format string, data ...interface{}) {} // Default no debugging output
+//Dependencies holds external systems used by events pipeline. Elasticsearch client created according
+//to configuration is used when Store is nil and collectors are registered to default Prometheus registry
+//when Registerer is nil.
+type Dependencies struct {
+	Store      EventStore
+	Registerer prometheus.Registerer
+}
 
-//spawnAPIServer spawns goroutine which provides http API for alerts and metrics statistics for Prometheus
-func spawnAPIServer(wg *sync.WaitGroup, finish chan bool, serverConfig saconfig.EventConfiguration, metricHandler *api.EventMetricHandler, amqpHandler *amqp10.AMQPHandler) {
-	prometheus.MustRegister(metricHandler, amqpHandler)
-	// Including these stats kills performance when Prometheus polls with multiple targets
-	prometheus.Unregister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
-	prometheus.Unregister(prometheus.NewGoCollector())
+//alertManagerTimeout bounds notification of Alert Manager, pending notifications are awaited on shutdown
+const alertManagerTimeout = 10 * time.Second
 
-	ctxt := api.NewContext(serverConfig)
-	http.Handle("/alert", api.Handler{Context: ctxt, H: api.AlertHandler})
-	http.Handle("/metrics", promhttp.Handler())
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(APIHOME))
-	})
-	srv := &http.Server{Addr: serverConfig.API.APIEndpointURL}
-	// spawn shutdown signal handler
-	go func() {
-		//lint:ignore S1000 reason: we are waiting for channel close, value might not be ever received
-		select {
-		case <-finish:
-			if err := srv.Shutdown(context.Background()); err != nil {
-				log.Fatalf("Failed to stop API server: %s\n", err)
-				// in case of error we need to allow wait group to end
-				wg.Done()
-			}
-		}
-	}()
-	// spawn the API server
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		log.Println("Started API server")
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-			log.Fatalf("Failed to start API server: %s\n", err.Error())
-		} else {
-			log.Println("Closing API server")
-		}
-	}()
-}
+//alertManagerClient sends alerts to Prometheus Alert Manager
+var alertManagerClient = &http.Client{Timeout: alertManagerTimeout}
 
-//notifyAlertManager generates alert from event for Prometheus Alert Manager
-func notifyAlertManager(wg *sync.WaitGroup, serverConfig saconfig.EventConfiguration, event *incoming.EventDataFormat, record string) {
+//notifyAlertManager generates alert from event for Prometheus Alert Manager and records result of the notification to health cache
+func notifyAlertManager(wg *sync.WaitGroup, serverConfig saconfig.EventConfiguration, applicationHealth *cacheutil.ApplicationHealthCache, event *incoming.EventDataFormat, record string) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		generatorURL := fmt.Sprintf("%s/%s/%s/%s", serverConfig.ElasticHostURL, (*event).GetIndexName(), EVENTSINDEXTYPE, record)
 		alert, err := (*event).GeneratePrometheusAlertBody(generatorURL)
 		if err != nil {
-			log.Printf("Failed generate alert from event:\n- error: %s\n- event: %s\n", err, (*event).GetSanitized())
+			logger.Error("Failed to generate alert from event", "error", err, "event", logging.Truncate((*event).GetSanitized()))
 		}
-		debuge("Debug: Generated alert:\n%s\n", alert)
+		logger.Debug("Generated alert", "alert", logging.Truncate(string(alert)))
 		var byteAlertBody = []byte(fmt.Sprintf("[%s]", alert))
 		req, _ := http.NewRequest("POST", serverConfig.AlertManagerURL, bytes.NewBuffer(byteAlertBody))
 		req.Header.Set("X-Custom-Header", "smartgateway")
 		req.Header.Set("Content-Type", "application/json")
 
-		client := &http.Client{}
-		resp, err := client.Do(req)
+		resp, err := alertManagerClient.Do(req)
 		if err != nil {
-			log.Printf("Failed to report alert to AlertManager:\n- error: %s\n- alert: %s\n", err, alert)
-			body, _ := ioutil.ReadAll(resp.Body)
-			defer resp.Body.Close()
-			debuge("Debug:response Status:%s\n", resp.Status)
-			debuge("Debug:response Headers:%s\n", resp.Header)
-			debuge("Debug:response Body:%s\n", string(body))
+			logger.Error("Failed to report alert to AlertManager", "url", logging.RedactURL(serverConfig.AlertManagerURL), "error", err, "alert", logging.Truncate(string(alert)))
+			applicationHealth.SetAlertManagerResult(err)
+			return
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			err = fmt.Errorf("unexpected response status %s", resp.Status)
+			logger.Error("Failed to report alert to AlertManager", "url", logging.RedactURL(serverConfig.AlertManagerURL), "error", err, "alert", logging.Truncate(string(alert)))
+			logger.Debug("AlertManager response", "headers", fmt.Sprint(resp.Header), "body", logging.Truncate(string(body)))
 		}
-		log.Println("Closing Alert Manager notifier")
+		applicationHealth.SetAlertManagerResult(err)
+		logger.Debug("Closing Alert Manager notifier")
 	}()
 }
 
-//StartEvents is the entry point for running smart-gateway in events mode
-func StartEvents() {
-	var wg sync.WaitGroup
-	finish := make(chan bool)
-
-	amqp10.SpawnSignalHandler(finish, os.Interrupt)
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-
-	// set flags for parsing options
-	flag.Usage = eventusage
-	fServiceType := flag.String("servicetype", "event", "Event type")
-	fConfigLocation := flag.String("config", "", "Path to configuration file.")
-	fUniqueName := flag.String("uname", "events-"+strconv.Itoa(rand.Intn(100)), "Unique name across application")
-	flag.Parse()
-
-	//load configuration from given config file or from cmdline parameters
-	var serverConfig *saconfig.EventConfiguration
-	if len(*fConfigLocation) > 0 {
-		conf, err := saconfig.LoadConfiguration(*fConfigLocation, "event")
-		if err != nil {
-			log.Fatal("Config Parse Error: ", err)
-		}
-		serverConfig = conf.(*saconfig.EventConfiguration)
-		serverConfig.ServiceType = *fServiceType
-	} else {
-		eventusage()
-		os.Exit(1)
-	}
-
-	if serverConfig.Debug {
-		debuge = func(format string, data ...interface{}) { log.Printf(format, data...) }
-	}
-
-	if len(serverConfig.AMQP1EventURL) == 0 && len(serverConfig.AMQP1Connections) == 0 {
-		log.Println("Configuration option 'AMQP1EventURL' or 'AMQP1Connections' is required")
-		eventusage()
-		os.Exit(1)
-	}
-
-	if len(serverConfig.ElasticHostURL) == 0 {
-		log.Println("Configuration option 'ElasticHostURL' is required")
-		eventusage()
-		os.Exit(1)
-	} else {
-		log.Printf("Elasticsearch configured at %s\n", serverConfig.ElasticHostURL)
-	}
-
+//prepareConfiguration fills options which are derived from other options of loaded configuration
+func prepareConfiguration(serverConfig *saconfig.EventConfiguration) {
 	if len(serverConfig.AlertManagerURL) > 0 {
-		log.Printf("AlertManager configured at %s\n", serverConfig.AlertManagerURL)
 		serverConfig.AlertManagerEnabled = true
-	} else {
-		log.Println("AlertManager disabled")
 	}
-
 	if len(serverConfig.API.APIEndpointURL) > 0 {
-		debuge("API configured at %s\n", serverConfig.API.APIEndpointURL)
 		serverConfig.APIEnabled = true
-	} else {
-		log.Println("API disabled")
 	}
-
-	if len(serverConfig.API.AMQP1PublishURL) > 0 {
-		log.Printf("AMQP1.0 publish address configured at %s\n", serverConfig.API.AMQP1PublishURL)
+	if len(serverConfig.API.AMQP1PublishURL) > 0 || len(serverConfig.API.PublishTargets) > 0 {
 		serverConfig.PublishEventEnabled = true
-	} else {
-		log.Println("AMQP1.0 publish address disabled")
 	}
-
 	if len(serverConfig.AMQP1EventURL) > 0 {
 		//TO-DO(mmagr): Remove this in next major release
 		serverConfig.AMQP1Connections = []saconfig.AMQPConnection{
@@ -208,87 +127,293 @@ func StartEvents() {
 			},
 		}
 	}
+}
+
+//setupLogging configures format and levels of logging
+func setupLogging(serverConfig *saconfig.EventConfiguration) error {
+	return logging.Setup(serverConfig.Debug, serverConfig.LogFormat, serverConfig.LogLevel, serverConfig.LogLevels)
+}
+
+//reloadConfiguration loads configuration again and applies changes of options which do not require
+//restart. Returns updated configuration together with handler manager for it or nil in case there
+//is no change to apply.
+func reloadConfiguration(loadConfig func() (*saconfig.EventConfiguration, error), running *saconfig.EventConfiguration) (*saconfig.EventConfiguration, *EventHandlerManager) {
+	logger.Info("Reloading events configuration")
+	reloaded, err := loadConfig()
+	if err != nil {
+		logger.Error("Failed to reload configuration, keeping current one", "error", err)
+		return nil, nil
+	}
+	reloaded.ServiceType = running.ServiceType
+	prepareConfiguration(reloaded)
+
+	reloadable, restart := saconfig.ChangedOptions(running, reloaded)
+	if len(restart) > 0 {
+		logger.Warn("Ignoring change of option(s), restart is required to apply it", "options", strings.Join(restart, ","))
+	}
+	if len(reloadable) == 0 {
+		logger.Info("No configuration change to apply")
+		return nil, nil
+	}
+
+	updated := *running
+	updated.Debug = reloaded.Debug
+	updated.AMQP1EventURL = reloaded.AMQP1EventURL
+	updated.AMQP1Connections = reloaded.AMQP1Connections
+	updated.AlertManagerURL = reloaded.AlertManagerURL
+	updated.AlertManagerEnabled = reloaded.AlertManagerEnabled
+	updated.HandlerPlugins = reloaded.HandlerPlugins
+	updated.Readiness = reloaded.Readiness
+	updated.LogFormat = reloaded.LogFormat
+	updated.LogLevel = reloaded.LogLevel
+	updated.LogLevels = reloaded.LogLevels
+	handlerManager, err := NewEventHandlerManager(updated)
+	if err != nil {
+		logger.Error("Failed to reload configuration, keeping current one", "error", err)
+		return nil, nil
+	}
+	if err := setupLogging(&updated); err != nil {
+		logger.Error("Failed to reconfigure logging", "error", err)
+	}
+	logger.Info("Applied change of option(s)", "options", strings.Join(reloadable, ","))
+	return &updated, handlerManager
+}
+
+//SpawnEvents spawns events pipeline consisting of AMQP1.0 listeners and event processor storing events
+//to Elasticsearch. Alert API endpoint is registered to given multiplexer in case AMQP1.0 publish address
+//is configured, but the HTTP server has to be started by caller. Configuration is reloaded using loadConfig
+//on SIGHUP. When finish channel is closed, receiving of new messages is stopped and already received
+//messages are processed before all goroutines end. External systems can be replaced by given dependencies.
+//Returns health cache of the pipeline.
+func SpawnEvents(wg *sync.WaitGroup, finish chan bool, serverConfig *saconfig.EventConfiguration, loadConfig func() (*saconfig.EventConfiguration, error), uniqueName string, handler *http.ServeMux, deps Dependencies) *cacheutil.ApplicationHealthCache {
+	prepareConfiguration(serverConfig)
+	if err := setupLogging(serverConfig); err != nil {
+		logger.Fatal("Config Parse Error", "error", err)
+	}
+
+	logger.Info("Elasticsearch configured", "url", logging.RedactURL(serverConfig.ElasticHostURL))
+
+	if serverConfig.AlertManagerEnabled {
+		logger.Info("AlertManager configured", "url", logging.RedactURL(serverConfig.AlertManagerURL))
+	} else {
+		logger.Info("AlertManager disabled")
+	}
+
+	if serverConfig.PublishEventEnabled {
+		if serverConfig.API.AMQP1PublishURL != "" {
+			logger.Info("AMQP1.0 publish address configured", "url", logging.RedactURL(serverConfig.API.AMQP1PublishURL))
+		}
+		for _, target := range serverConfig.API.PublishTargets {
+			logger.Info("AMQP1.0 publish target configured", "url", logging.RedactURL(target.URL), "match", target.Match, "match_re", target.MatchRE)
+		}
+	} else {
+		logger.Info("AMQP1.0 publish address disabled")
+	}
+
 	for _, conn := range serverConfig.AMQP1Connections {
-		log.Printf("AMQP1.0 %s listen address configured at %s\n", conn.DataSource, conn.URL)
+		logger.Info("AMQP1.0 listen address configured", "datasource", conn.DataSource, "url", logging.RedactURL(conn.URL))
 	}
 
 	applicationHealth := cacheutil.NewApplicationHealthCache()
+	applicationHealth.SetReadiness(serverConfig.Readiness)
+	applicationHealth.EnableElasticSearch(true)
+	applicationHealth.EnableAlertManager(serverConfig.AlertManagerEnabled)
 	metricHandler := api.NewAppStateEventMetricHandler(applicationHealth)
 	amqpHandler := amqp10.NewAMQPHandler("Event Consumer")
 
 	// Elastic connection
-	elasticClient, err := saelastic.CreateClient(*serverConfig)
-
-	if err != nil {
-		log.Fatal(err.Error())
+	elasticClient := deps.Store
+	if elasticClient == nil {
+		client, err := saelastic.CreateClient(*serverConfig)
+		if err != nil {
+			logger.Fatal("Failed to connect to Elasticsearch", "url", logging.RedactURL(serverConfig.ElasticHostURL), "error", err)
+		}
+		logger.Info("Connected to Elasticsearch", "url", logging.RedactURL(serverConfig.ElasticHostURL))
+		elasticClient = client
 	}
-	log.Println("Connected to Elasticsearch")
-	applicationHealth.ElasticSearchState = 1
+	applicationHealth.SetElasticSearchResult(nil)
 
-	// API spawn
-	if serverConfig.APIEnabled {
-		spawnAPIServer(&wg, finish, *serverConfig, metricHandler, amqpHandler)
+	// API handlers
+	registerer := deps.Registerer
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	registerer.MustRegister(metricHandler, amqpHandler)
+	if serverConfig.PublishEventEnabled {
+		ctxt, err := api.NewContext(*serverConfig)
+		if err != nil {
+			logger.Fatal("Failed to configure alert relay", "error", err)
+		}
+		handler.Handle("/alert", api.Handler{Context: ctxt, H: api.AlertHandler})
 	}
 
 	// AMQP connection(s)
-	processingCases, qpidStatusCases, amqpServers := amqp10.CreateMessageLoopComponents(serverConfig, finish, amqpHandler, *fUniqueName)
-	amqp10.SpawnQpidStatusReporter(&wg, applicationHealth, qpidStatusCases)
+	processingCases, qpidStatusCases, amqpServers := amqp10.CreateMessageLoopComponents(serverConfig, finish, amqpHandler, uniqueName)
+	stopReporter := amqp10.SpawnQpidStatusReporter(wg, applicationHealth, qpidStatusCases, amqpServers)
 
 	// spawn handler manager
 	handlerManager, err := NewEventHandlerManager(*serverConfig)
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Fatal("Failed to load event handlers", "error", err)
 	}
 
+	// event sinks
+	sinks := make([]EventSink, 0, len(serverConfig.Webhooks)+1)
+	for _, webhookConfig := range serverConfig.Webhooks {
+		sink, err := NewWebhookSink(webhookConfig)
+		if err != nil {
+			logger.Fatal("Failed to configure webhook", "error", err)
+		}
+		logger.Info("Webhook configured", "webhook", sink.Name())
+		sinks = append(sinks, sink)
+	}
+	if serverConfig.Loki.URL != "" {
+		logger.Info("Loki configured", "url", logging.RedactURL(serverConfig.Loki.URL))
+		sinks = append(sinks, NewLokiSink(serverConfig.Loki))
+	}
+	for _, sink := range sinks {
+		sink.Spawn(wg)
+	}
+
+	processingCases = append(processingCases, reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(amqp10.SpawnReloadSignalHandler()),
+	})
+
 	// spawn event processor
 	wg.Add(1)
-	go func() {
+	go func(runningConfig *saconfig.EventConfiguration) {
 		defer wg.Done()
-		finishCase := len(processingCases) - 1
-	processingLoop:
-		for {
-			switch index, msg, _ := reflect.Select(processingCases); index {
-			case finishCase:
-				break processingLoop
-			default:
-				// NOTE: below will panic for generic data source until the appropriate logic will be implemented
-				event := incoming.NewFromDataSource(amqpServers[index].DataSource)
-				amqpServers[index].Server.GetHandler().IncTotalMsgProcessed()
-				err := event.ParseEvent(msg.String())
-				if err != nil {
-					log.Printf("Failed to parse received event:\n- error: %s\n- event: %s\n", err, event)
+		process := func(item amqp10.AMQPServerItem, msg string) {
+			logger.Debug("Received message", "url", logging.RedactURL(item.Server.GetURL()), "datasource", item.DataSource, "body", logging.Truncate(msg))
+			start := time.Now()
+			stats := item.Server.GetStats()
+			defer func() { stats.IncProcessed(time.Since(start)) }()
+			event := incoming.NewFromDataSource(item.DataSource)
+			applicationHealth.MessageReceived(item.Server.GetURL(), item.DataSource.String())
+			err := event.ParseEvent(msg)
+			if err != nil {
+				stats.IncParseFailures()
+				if ok, suppressed := parseFailures.Allow(item.Server.GetURL()); ok {
+					logger.Warn("Failed to parse received event", "url", logging.RedactURL(item.Server.GetURL()), "datasource", item.DataSource,
+						"error", err, "body", logging.Truncate(msg), "suppressed", suppressed)
 				}
+			}
 
-				process := true
-				for _, handler := range handlerManager.Handlers[amqpServers[index].DataSource] {
-					if handler.Relevant(event) {
-						process, err = handler.Handle(event, elasticClient)
-						if !process {
-							if err != nil {
-								log.Print(err.Error())
-							}
-							break
+			process := true
+			for _, handler := range handlerManager.Handlers[item.DataSource] {
+				if handler.Relevant(event) {
+					process, err = handler.Handle(event, elasticClient)
+					if !process {
+						if err != nil {
+							logger.Error("Event handler failed", "datasource", item.DataSource, "error", err)
 						}
+						break
 					}
 				}
-				if process {
-					record, err := elasticClient.Create(event.GetIndexName(), EVENTSINDEXTYPE, event.GetRawData())
-					if err != nil {
-						applicationHealth.ElasticSearchState = 0
-						log.Printf("Failed to save event to Elasticsearch DB:\n- error: %s\n- event: %s\n", err, event)
-					} else {
-						applicationHealth.ElasticSearchState = 1
-					}
-					if serverConfig.AlertManagerEnabled {
-						notifyAlertManager(&wg, *serverConfig, &event, record)
+			}
+			if process {
+				record, err := elasticClient.Create(event.GetIndexName(), EVENTSINDEXTYPE, event.GetRawData())
+				if err != nil {
+					logger.Error("Failed to save event to Elasticsearch", "index", event.GetIndexName(), "error", err, "event", logging.Truncate(event.GetSanitized()))
+				}
+				applicationHealth.SetElasticSearchResult(err)
+				if runningConfig.AlertManagerEnabled {
+					notifyAlertManager(wg, *runningConfig, applicationHealth, &event, record)
+				}
+				if len(sinks) > 0 {
+					sinkEvent := NewSinkEvent(event)
+					for _, sink := range sinks {
+						sink.Enqueue(sinkEvent)
 					}
 				}
 			}
 		}
-		log.Println("Closing event processor.")
-	}()
+	processingLoop:
+		for {
+			// cases for connections are followed by finish and reload cases
+			finishCase, reloadCase := len(amqpServers), len(amqpServers)+1
+			switch index, msg, _ := reflect.Select(processingCases); index {
+			case finishCase:
+				break processingLoop
+			case reloadCase:
+				updatedConfig, updatedManager := reloadConfiguration(loadConfig, runningConfig)
+				if updatedConfig == nil {
+					continue
+				}
+				runningConfig, handlerManager = updatedConfig, updatedManager
+				stopReporter()
+				processingCases, qpidStatusCases, amqpServers = amqp10.UpdateMessageLoopComponents(runningConfig, finish, amqpHandler, uniqueName, processingCases, amqpServers)
+				stopReporter = amqp10.SpawnQpidStatusReporter(wg, applicationHealth, qpidStatusCases, amqpServers)
+				applicationHealth.SetReadiness(runningConfig.Readiness)
+				applicationHealth.EnableAlertManager(runningConfig.AlertManagerEnabled)
+			default:
+				process(amqpServers[index], msg.String())
+				amqpServers[index].Server.Processed()
+			}
+		}
+		// events are stored synchronously and pending alert notifications are part of the wait group
+		logger.Info("Draining received events")
+		shutdownTimeout := time.Duration(runningConfig.ShutdownTimeout) * time.Second
+		if shutdownTimeout <= 0 {
+			shutdownTimeout = amqp10.DefaultDrainTimeout
+		}
+		// queued events are sent to sinks within the same timeout
+		deadline := time.Now().Add(shutdownTimeout)
+		amqp10.DrainMessages(amqpServers, shutdownTimeout, process)
+		for _, sink := range sinks {
+			sink.Close(deadline)
+		}
+		logger.Info("Closing event processor")
+	}(serverConfig)
+	return applicationHealth
+}
+
+//StartEvents is the entry point for running smart-gateway in events mode
+func StartEvents(configPath string, serviceType string, uniqueName string) {
+	var wg, httpWg sync.WaitGroup
+	finish, httpFinish := make(chan bool), make(chan bool)
+
+	amqp10.SpawnSignalHandler(finish, os.Interrupt, syscall.SIGTERM)
+
+	loadConfig := func() (*saconfig.EventConfiguration, error) {
+		return saconfig.LoadEventConfiguration(configPath)
+	}
+	serverConfig, err := loadConfig()
+	if err != nil {
+		logger.Fatal("Config Parse Error", "error", err)
+	}
+	serverConfig.ServiceType = serviceType
+
+	// Including these stats kills performance when Prometheus polls with multiple targets
+	prometheus.Unregister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	prometheus.Unregister(prometheus.NewGoCollector())
+
+	handler := http.NewServeMux()
+	handler.Handle("/metrics", promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+	handler.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(APIHOME))
+	})
+	applicationHealth := SpawnEvents(&wg, finish, serverConfig, loadConfig, uniqueName, handler, Dependencies{})
+	api.RegisterHealthHandlers(handler, map[string]*cacheutil.ApplicationHealthCache{"events": applicationHealth})
+
+	// API spawn
+	if serverConfig.APIEnabled {
+		var tlsConfig *tls.Config
+		if serverConfig.API.TLSCert != "" {
+			if tlsConfig, err = api.NewTLSConfig(serverConfig.API.TLSCert, serverConfig.API.TLSKey, serverConfig.API.TLSClientCA); err != nil {
+				logger.Fatal("Failed to configure TLS of API", "error", err)
+			}
+		}
+		api.SpawnHTTPServer(&httpWg, httpFinish, "API", serverConfig.API.APIEndpointURL, api.NewProtectedHandler(handler, serverConfig.API), tlsConfig,
+			time.Duration(serverConfig.ShutdownTimeout)*time.Second)
+	} else {
+		logger.Info("API disabled")
+	}
 
-	// do not end until all loop goroutines ends
+	// do not end until all loop goroutines ends, HTTP server is closed as the last one
 	wg.Wait()
-	log.Println("Exiting")
+	close(httpFinish)
+	httpWg.Wait()
+	logger.Info("Exiting")
 }
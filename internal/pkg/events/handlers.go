@@ -6,7 +6,6 @@ import (
 
 	"github.com/infrawatch/smart-gateway/internal/pkg/events/incoming"
 	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
-	"github.com/infrawatch/smart-gateway/internal/pkg/saelastic"
 )
 
 // TODO: Implement this as pluggable system instead
@@ -14,7 +13,7 @@ import (
 //EventHandler provides interface for all possible handler types
 type EventHandler interface {
 	//Processes the event
-	Handle(incoming.EventDataFormat, *saelastic.ElasticClient) (bool, error)
+	Handle(incoming.EventDataFormat, EventStore) (bool, error)
 	//Relevant should return true if the handler is relevant for the givent event and so the handler should be used
 	Relevant(incoming.EventDataFormat) bool
 }
@@ -93,7 +92,7 @@ func getOutputObject(path *list, data interface{}) (string, error) {
 
 //Handle saves the event as separate document to ES in case the result output contains more than one item.
 //Returns true if event processing should continue (eg. event should be saved to ES) or false if otherwise.
-func (hand ContainerHealthCheckHandler) Handle(event incoming.EventDataFormat, elasticClient *saelastic.ElasticClient) (bool, error) {
+func (hand ContainerHealthCheckHandler) Handle(event incoming.EventDataFormat, elasticClient EventStore) (bool, error) {
 	pathList := &list{
 		Key: "annotations",
 	}
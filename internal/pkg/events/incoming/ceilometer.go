@@ -3,10 +3,11 @@ package incoming
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"regexp"
 	"strings"
 	"time"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/logging"
 )
 
 //ceilomterGenericIndex value represents ElasticSearch index name for data from which it
@@ -100,7 +101,8 @@ func (evt *CeilometerEvent) sanitize(jsondata string) string {
 	if len(sub) == 2 {
 		sanitized = rexForNestedQuote.ReplaceAllString(sub[1], `"`)
 	} else {
-		log.Printf("Failed to find oslo.message in Ceilometer event: %s\n", jsondata)
+		// event is reported by caller in case it cannot be parsed
+		logger.Debug("Failed to find oslo.message in Ceilometer event", "event", logging.Truncate(jsondata))
 	}
 	// avoid getting payload data wrapped in array
 	item := rexForPayload.FindStringSubmatch(sanitized)
@@ -115,7 +117,6 @@ func (evt *CeilometerEvent) ParseEvent(data string) error {
 	evt.sanitized = evt.sanitize(data)
 	err := json.Unmarshal([]byte(evt.sanitized), &evt.parsed)
 	if err != nil {
-		log.Fatal(err)
 		return err
 	}
 	// transforms traits key into map[string]interface{}
@@ -130,16 +131,17 @@ func (evt *CeilometerEvent) ParseEvent(data string) error {
 							if len(typedValue) != 3 {
 								return fmt.Errorf("parsed invalid trait (%v) in event: %s", value, data)
 							}
-							if traitType, ok := typedValue[1].(float64); ok {
-								switch traitType {
-								case 2:
-									newTraits[typedValue[0].(string)] = typedValue[2].(float64)
-								default:
-									newTraits[typedValue[0].(string)] = typedValue[2].(string)
+							// trait is [name, type, value], numeric traits have float64 value and the others string
+							name, nameOk := typedValue[0].(string)
+							_, typeOk := typedValue[1].(float64)
+							switch traitValue := typedValue[2].(type) {
+							case float64, string:
+								if nameOk && typeOk {
+									newTraits[name] = traitValue
+									continue
 								}
-							} else {
-								return fmt.Errorf("parsed invalid trait (%v) in event: %s", value, data)
 							}
+							return fmt.Errorf("parsed invalid trait (%v) in event: %s", value, data)
 						} else {
 							return fmt.Errorf("parsed invalid trait (%v) in event: %s", value, data)
 						}
@@ -174,12 +176,12 @@ func (evt *CeilometerEvent) GeneratePrometheusAlert(generatorURL string) Prometh
 		AlertKeySurrogate{"event_type", "type"},
 	}
 	for _, renameCase := range surrogates {
-		if value, ok := evt.parsed[renameCase.Parsed]; ok {
-			alert.Labels[renameCase.Label] = value.(string)
+		if value, ok := evt.parsed[renameCase.Parsed].(string); ok {
+			alert.Labels[renameCase.Label] = value
 		}
 	}
-	if value, ok := evt.parsed["priority"]; ok {
-		if severity, ok := ceilometerAlertSeverity[value.(string)]; ok {
+	if value, ok := evt.parsed["priority"].(string); ok {
+		if severity, ok := ceilometerAlertSeverity[value]; ok {
 			alert.Labels["severity"] = severity
 		} else {
 			alert.Labels["severity"] = unknownSeverity
@@ -190,7 +192,9 @@ func (evt *CeilometerEvent) GeneratePrometheusAlert(generatorURL string) Prometh
 	if value, ok := evt.parsed["publisher_id"].(string); ok {
 		alert.Labels["sourceName"] = strings.Join([]string{"ceilometer", value}, "@")
 	}
-	assimilateMap(evt.parsed["payload"].(map[string]interface{}), &alert.Annotations)
+	// payload is missing in malformed events
+	payload, _ := evt.parsed["payload"].(map[string]interface{})
+	assimilateMap(payload, &alert.Annotations)
 	// set timestamp
 	if value, ok := evt.parsed["timestamp"].(string); ok {
 		// ensure timestamp is in RFC3339
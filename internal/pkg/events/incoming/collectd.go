@@ -3,7 +3,6 @@ package incoming
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"regexp"
 	"strings"
 	"time"
@@ -91,7 +90,6 @@ func (evt *CollectdEvent) ParseEvent(data string) error {
 	evt.sanitized = evt.sanitize(data)
 	err := json.Unmarshal([]byte(evt.sanitized), &evt.parsed)
 	if err != nil {
-		log.Fatal(err)
 		return err
 	}
 	return nil
@@ -99,11 +97,6 @@ func (evt *CollectdEvent) ParseEvent(data string) error {
 
 //assimilateMap recursively saves content of the given map to destination map of strings
 func assimilateMap(theMap map[string]interface{}, destination *map[string]string) {
-	defer func() { //recover from any panic
-		if r := recover(); r != nil {
-			log.Printf("Panic:recovered in assimilateMap %v\n", r)
-		}
-	}()
 	for key, val := range theMap {
 		switch value := val.(type) {
 		case map[string]interface{}:
@@ -122,9 +115,9 @@ func assimilateMap(theMap map[string]interface{}, destination *map[string]string
 			(*destination)[key] = fmt.Sprintf("%f", value)
 		case int:
 			(*destination)[key] = fmt.Sprintf("%d", value)
-		default:
+		case string:
 			// assimilate KV pair
-			(*destination)[key] = value.(string)
+			(*destination)[key] = value
 		}
 	}
 }
@@ -136,8 +129,11 @@ func (evt *CollectdEvent) GeneratePrometheusAlert(generatorURL string) Prometheu
 		Annotations:  make(map[string]string),
 		GeneratorURL: generatorURL,
 	}
-	assimilateMap(evt.parsed["labels"].(map[string]interface{}), &alert.Labels)
-	assimilateMap(evt.parsed["annotations"].(map[string]interface{}), &alert.Annotations)
+	// labels and annotations are missing in malformed events
+	labels, _ := evt.parsed["labels"].(map[string]interface{})
+	annotations, _ := evt.parsed["annotations"].(map[string]interface{})
+	assimilateMap(labels, &alert.Labels)
+	assimilateMap(annotations, &alert.Annotations)
 	if value, ok := evt.parsed["startsAt"].(string); ok {
 		// ensure timestamps is in RFC3339
 		for _, layout := range []string{time.RFC3339, time.RFC3339Nano, time.ANSIC, isoTimeLayout} {
@@ -160,7 +156,7 @@ func (evt *CollectdEvent) GeneratePrometheusAlert(generatorURL string) Prometheu
 	}
 
 	alert.SetName()
-	assimilateMap(evt.parsed["annotations"].(map[string]interface{}), &alert.Labels)
+	assimilateMap(annotations, &alert.Labels)
 	alert.SetSummary()
 
 	alert.Labels["alertsource"] = "SmartGateway"
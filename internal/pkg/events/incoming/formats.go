@@ -4,9 +4,12 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/infrawatch/smart-gateway/internal/pkg/logging"
 	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
 )
 
+var logger = logging.New("events/incoming")
+
 const (
 	isoTimeLayout   = "2006-01-02 15:04:05.000000"
 	unknownSeverity = "unknown"
@@ -92,6 +95,8 @@ func NewFromDataSource(source saconfig.DataSource) EventDataFormat {
 		return &CollectdEvent{}
 	case saconfig.DataSourceCeilometer:
 		return &CeilometerEvent{}
+	case saconfig.DataSourceUniversal:
+		return &UniversalEvent{}
 	}
 	return nil
 }
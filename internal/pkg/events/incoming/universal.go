@@ -0,0 +1,129 @@
+package incoming
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+//universalGenericIndex value represents ElasticSearch index name for universal events without alert name
+const universalGenericIndex = "universal_generic"
+
+var (
+	rexForIndexName        = regexp.MustCompile(`[^a-z0-9_]+`)
+	universalAlertSeverity = map[string]bool{
+		"critical": true,
+		"warning":  true,
+		"info":     true,
+	}
+)
+
+//UniversalEventData holds data of event in smart-gateway universal format, eg.:
+//  {"labels": {"alertname": "edge_disk_full", "instance": "edge-0", "device": "sda1"},
+//   "annotations": {"summary": "disk sda1 is full"}, "severity": "critical",
+//   "timestamp": "2020-03-04T09:00:00Z"}
+//Label "alertname" is required. Severity is one of critical, warning or info in any case, other values
+//are stored as unknown. Timestamp is in RFC3339 format, time of receiving is used when it is missing.
+type UniversalEventData struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	Severity    string            `json:"severity"`
+	Timestamp   string            `json:"timestamp"`
+}
+
+//UniversalEvent implements EventDataFormat interface and holds event message data in universal format.
+type UniversalEvent struct {
+	sanitized string
+	parsed    UniversalEventData
+	indexName string
+}
+
+//GetIndexName returns Elasticsearch index to which this event is or should be saved. The index name
+//is "universal_" followed by alert name of the event.
+func (evt *UniversalEvent) GetIndexName() string {
+	if evt.indexName == "" {
+		result := universalGenericIndex
+		name := strings.Trim(rexForIndexName.ReplaceAllString(strings.ToLower(evt.parsed.Labels["alertname"]), "_"), "_")
+		if name != "" {
+			result = "universal_" + name
+		}
+		evt.indexName = result
+	}
+	return evt.indexName
+}
+
+//GetRawData returns normalized event data.
+func (evt *UniversalEvent) GetRawData() interface{} {
+	return evt.parsed
+}
+
+//GetSanitized returns received event data
+func (evt *UniversalEvent) GetSanitized() string {
+	return evt.sanitized
+}
+
+//ParseEvent unmarshals and normalizes received event data. Event without alert name or with invalid
+//timestamp is reported as invalid, but it is kept normalized for storing.
+func (evt *UniversalEvent) ParseEvent(data string) error {
+	evt.sanitized = data
+	evt.indexName = ""
+	evt.parsed = UniversalEventData{}
+	err := json.Unmarshal([]byte(data), &evt.parsed)
+	if evt.parsed.Labels == nil {
+		evt.parsed.Labels = make(map[string]string)
+	}
+	if evt.parsed.Annotations == nil {
+		evt.parsed.Annotations = make(map[string]string)
+	}
+	evt.parsed.Severity = strings.ToLower(evt.parsed.Severity)
+	if !universalAlertSeverity[evt.parsed.Severity] {
+		evt.parsed.Severity = unknownSeverity
+	}
+	timestamp := time.Now().UTC()
+	if evt.parsed.Timestamp != "" {
+		stamp, perr := time.Parse(time.RFC3339Nano, evt.parsed.Timestamp)
+		if perr != nil && err == nil {
+			err = fmt.Errorf("invalid timestamp '%s', expected RFC3339 format", evt.parsed.Timestamp)
+		} else if perr == nil {
+			timestamp = stamp
+		}
+	}
+	evt.parsed.Timestamp = timestamp.Format(time.RFC3339Nano)
+	if err != nil {
+		return err
+	}
+	if evt.parsed.Labels["alertname"] == "" {
+		return fmt.Errorf("missing alertname label")
+	}
+	return nil
+}
+
+//GeneratePrometheusAlert generates PrometheusAlert from the event data
+func (evt *UniversalEvent) GeneratePrometheusAlert(generatorURL string) PrometheusAlert {
+	alert := PrometheusAlert{
+		Labels:       make(map[string]string, len(evt.parsed.Labels)+2),
+		Annotations:  make(map[string]string, len(evt.parsed.Annotations)),
+		GeneratorURL: generatorURL,
+	}
+	for key, value := range evt.parsed.Labels {
+		alert.Labels[key] = value
+	}
+	for key, value := range evt.parsed.Annotations {
+		alert.Annotations[key] = value
+	}
+	if stamp, err := time.Parse(time.RFC3339Nano, evt.parsed.Timestamp); err == nil {
+		alert.StartsAt = stamp.Format(time.RFC3339)
+	}
+	alert.Labels["severity"] = evt.parsed.Severity
+	alert.SetSummary()
+
+	alert.Labels["alertsource"] = "SmartGateway"
+	return alert
+}
+
+//GeneratePrometheusAlertBody generates alert body for Prometheus Alert manager API
+func (evt *UniversalEvent) GeneratePrometheusAlertBody(generatorURL string) ([]byte, error) {
+	return json.Marshal(evt.GeneratePrometheusAlert(generatorURL))
+}
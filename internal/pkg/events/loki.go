@@ -0,0 +1,203 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+//defaultLokiBatchSize is count of events pushed to Loki in single request when none is configured
+const defaultLokiBatchSize = 100
+
+//lokiStream holds entries of single Loki stream
+type lokiStream struct {
+	labels  map[string]string
+	entries []SinkEvent
+}
+
+//labelString returns labels of the stream in Prometheus format, eg. {host="compute-0", severity="critical"}
+func (ls *lokiStream) labelString() string {
+	names := make([]string, 0, len(ls.labels))
+	for name := range ls.labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", name, strconv.Quote(ls.labels[name])))
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+//LokiSink pushes processed events in batches to Loki. Sanitized event message is used as log line
+//and time the event was processed as its timestamp.
+type LokiSink struct {
+	eventSink
+	config saconfig.LokiConfig
+	labels []string
+	sender *httpSender
+}
+
+//NewLokiSink returns sink configured by given Loki configuration
+func NewLokiSink(config saconfig.LokiConfig) *LokiSink {
+	sink := &LokiSink{
+		config: config,
+		labels: config.Labels,
+		sender: newHTTPSender("loki", time.Duration(config.Timeout)*time.Second, config.Retries, time.Duration(config.RetryInterval)*time.Second),
+	}
+	if len(sink.labels) == 0 {
+		sink.labels = saconfig.LokiLabels
+	}
+	batchSize := config.BatchSize
+	if batchSize == 0 {
+		batchSize = defaultLokiBatchSize
+	}
+	sink.eventSink = newEventSink("loki", batchSize, time.Duration(config.BatchInterval)*time.Second, config.QueueSize, sink.send)
+	return sink
+}
+
+//streams groups given events to streams according to their labels preserving order of the events
+func (ls *LokiSink) streams(batch []SinkEvent) []*lokiStream {
+	streams := []*lokiStream{}
+	byLabels := make(map[string]*lokiStream)
+	for _, event := range batch {
+		labels := make(map[string]string, len(ls.labels)+len(ls.config.StaticLabels))
+		for name, value := range ls.config.StaticLabels {
+			labels[name] = value
+		}
+		for _, name := range ls.labels {
+			switch name {
+			case "host":
+				labels[name] = event.Host
+			case "index":
+				labels[name] = event.Index
+			case "severity":
+				labels[name] = event.Severity
+			}
+		}
+		stream := &lokiStream{labels: labels}
+		key := stream.labelString()
+		if existing, ok := byLabels[key]; ok {
+			stream = existing
+		} else {
+			byLabels[key] = stream
+			streams = append(streams, stream)
+		}
+		stream.entries = append(stream.entries, event)
+	}
+	return streams
+}
+
+//JSONBody returns request body of given batch of events in JSON format of Loki push API
+func (ls *LokiSink) JSONBody(batch []SinkEvent) ([]byte, error) {
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	request := struct {
+		Streams []stream `json:"streams"`
+	}{}
+	for _, s := range ls.streams(batch) {
+		values := make([][2]string, 0, len(s.entries))
+		for _, entry := range s.entries {
+			values = append(values, [2]string{strconv.FormatInt(entry.Time.UnixNano(), 10), entry.Raw})
+		}
+		request.Streams = append(request.Streams, stream{Stream: s.labels, Values: values})
+	}
+	return json.Marshal(request)
+}
+
+//ProtobufBody returns request body of given batch of events in protobuf format of Loki push API,
+//ie. snappy encoded logproto.PushRequest message
+func (ls *LokiSink) ProtobufBody(batch []SinkEvent) []byte {
+	var request []byte
+	for _, s := range ls.streams(batch) {
+		// logproto.StreamAdapter: labels = 1, entries = 2
+		var stream []byte
+		stream = protowire.AppendTag(stream, 1, protowire.BytesType)
+		stream = protowire.AppendString(stream, s.labelString())
+		for _, entry := range s.entries {
+			// google.protobuf.Timestamp: seconds = 1, nanos = 2
+			var timestamp []byte
+			timestamp = protowire.AppendTag(timestamp, 1, protowire.VarintType)
+			timestamp = protowire.AppendVarint(timestamp, uint64(entry.Time.Unix()))
+			timestamp = protowire.AppendTag(timestamp, 2, protowire.VarintType)
+			timestamp = protowire.AppendVarint(timestamp, uint64(entry.Time.Nanosecond()))
+			// logproto.EntryAdapter: timestamp = 1, line = 2
+			var item []byte
+			item = protowire.AppendTag(item, 1, protowire.BytesType)
+			item = protowire.AppendBytes(item, timestamp)
+			item = protowire.AppendTag(item, 2, protowire.BytesType)
+			item = protowire.AppendString(item, entry.Raw)
+			stream = protowire.AppendTag(stream, 2, protowire.BytesType)
+			stream = protowire.AppendBytes(stream, item)
+		}
+		// logproto.PushRequest: streams = 1
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, stream)
+	}
+	return snappyEncode(request)
+}
+
+//snappyEncode encodes given data in snappy block format required by Loki for protobuf requests.
+//Data are stored as literals only, ie. not compressed, which avoids dependency on snappy library.
+func snappyEncode(data []byte) []byte {
+	out := protowire.AppendVarint(nil, uint64(len(data)))
+	for len(data) > 0 {
+		n := len(data)
+		if n > 1<<16 {
+			n = 1 << 16
+		}
+		// literal tag holds length-1 in upper 6 bits for lengths up to 60, otherwise 60 or 61
+		// in upper bits marks that 1 or 2 bytes of little-endian length-1 follow
+		switch l := n - 1; {
+		case l < 60:
+			out = append(out, byte(l)<<2)
+		case l < 1<<8:
+			out = append(out, 60<<2, byte(l))
+		default:
+			out = append(out, 61<<2, byte(l), byte(l>>8))
+		}
+		out = append(out, data[:n]...)
+		data = data[n:]
+	}
+	return out
+}
+
+//send pushes given batch of events, failed requests are retried according to configuration of the sink
+func (ls *LokiSink) send(ctx context.Context, batch []SinkEvent) error {
+	var body []byte
+	contentType := "application/x-protobuf"
+	if ls.config.Format == "protobuf" {
+		body = ls.ProtobufBody(batch)
+	} else {
+		var err error
+		if body, err = ls.JSONBody(batch); err != nil {
+			return fmt.Errorf("failed to encode request body: %s", err)
+		}
+		contentType = "application/json"
+	}
+	return ls.sender.Send(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", ls.config.URL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", contentType)
+		if ls.config.TenantID != "" {
+			req.Header.Set("X-Scope-OrgID", ls.config.TenantID)
+		}
+		if ls.config.AuthUser != "" {
+			req.SetBasicAuth(ls.config.AuthUser, ls.config.AuthPass)
+		}
+		return req, nil
+	})
+}
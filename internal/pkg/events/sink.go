@@ -0,0 +1,225 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/events/incoming"
+	"github.com/infrawatch/smart-gateway/internal/pkg/logging"
+)
+
+//default values of sink options
+const (
+	defaultSinkBatchInterval = 5 * time.Second
+	defaultSinkQueueSize     = 1000
+	defaultSinkTimeout       = 10 * time.Second
+	defaultSinkRetryInterval = time.Second
+)
+
+//sinkDrops limits logging of events dropped due to full queue to one record per sink and minute
+var sinkDrops = logging.NewSampler(time.Minute)
+
+//EventSink sends processed events to external system
+type EventSink interface {
+	//Name returns name of the sink used in logs
+	Name() string
+	//Enqueue queues given event for sending, event is dropped in case the queue is full
+	Enqueue(SinkEvent)
+	//Spawn starts goroutine sending queued events
+	Spawn(*sync.WaitGroup)
+	//Close stops accepting events, goroutine spawned by Spawn ends once all queued events are sent
+	//or once given deadline passes, events which were not sent until then are dropped
+	Close(deadline time.Time)
+}
+
+//SinkEvent is data of single processed event passed to sinks
+type SinkEvent struct {
+	Index    string      //Elasticsearch index of the event
+	Severity string      //critical, warning, info or unknown
+	Host     string      //host which reported the event
+	Time     time.Time   //time the event was processed
+	Data     interface{} //parsed event data
+	Raw      string      //sanitized event message
+}
+
+//NewSinkEvent returns sink data of given processed event
+func NewSinkEvent(event incoming.EventDataFormat) SinkEvent {
+	alert := event.GeneratePrometheusAlert("")
+	return SinkEvent{
+		Index:    event.GetIndexName(),
+		Severity: alert.Labels["severity"],
+		Host:     alert.Labels["instance"],
+		Time:     time.Now(),
+		Data:     event.GetRawData(),
+		Raw:      event.GetSanitized(),
+	}
+}
+
+/************************** eventSink implementation ***************************/
+
+//eventSink queues events and sends them in batches using send function
+type eventSink struct {
+	name          string
+	batchSize     int
+	batchInterval time.Duration
+	queue         chan SinkEvent
+	send          func(context.Context, []SinkEvent) error
+	ctx           context.Context //cancelled once shutdown deadline passes
+	cancel        context.CancelFunc
+}
+
+//newEventSink returns sink sending batches of given size or smaller ones after given interval
+//using given function. Zero values are replaced with defaults.
+func newEventSink(name string, batchSize int, batchInterval time.Duration, queueSize int, send func(context.Context, []SinkEvent) error) eventSink {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	if batchInterval == 0 {
+		batchInterval = defaultSinkBatchInterval
+	}
+	if queueSize == 0 {
+		queueSize = defaultSinkQueueSize
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return eventSink{name: name, batchSize: batchSize, batchInterval: batchInterval, queue: make(chan SinkEvent, queueSize), send: send, ctx: ctx, cancel: cancel}
+}
+
+//Name returns name of the sink
+func (es *eventSink) Name() string {
+	return es.name
+}
+
+//Enqueue queues given event for sending. Event is dropped in case the queue is full.
+func (es *eventSink) Enqueue(event SinkEvent) {
+	select {
+	case es.queue <- event:
+	default:
+		if ok, suppressed := sinkDrops.Allow(es.name); ok {
+			logger.Warn("Sink queue is full, dropping event", "sink", es.name, "index", event.Index, "suppressed", suppressed)
+		}
+	}
+}
+
+//Close stops accepting events, goroutine spawned by Spawn ends once all queued events are sent
+//or once given deadline passes, sending is interrupted and remaining events are dropped then
+func (es *eventSink) Close(deadline time.Time) {
+	close(es.queue)
+	time.AfterFunc(time.Until(deadline), es.cancel)
+}
+
+//Spawn starts goroutine sending queued events in batches. Incomplete batch is sent after batch
+//interval. Goroutine ends once the sink is closed and all queued events are sent or the deadline
+//given to Close passes.
+func (es *eventSink) Spawn(wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(es.batchInterval)
+		defer ticker.Stop()
+		batch := make([]SinkEvent, 0, es.batchSize)
+		flush := func() {
+			if len(batch) == 0 {
+				return
+			}
+			if err := es.send(es.ctx, batch); err != nil {
+				logger.Error("Failed to send events", "sink", es.name, "events", len(batch), "error", err)
+			} else {
+				logger.Debug("Sent events", "sink", es.name, "events", len(batch))
+			}
+			batch = make([]SinkEvent, 0, es.batchSize)
+		}
+		for {
+			if es.ctx.Err() != nil {
+				// queue is already closed once the deadline passes
+				dropped := len(batch)
+				for range es.queue {
+					dropped++
+				}
+				if dropped > 0 {
+					logger.Warn("Shutdown deadline passed, dropping queued events", "sink", es.name, "events", dropped)
+				}
+				return
+			}
+			select {
+			case event, ok := <-es.queue:
+				if !ok {
+					flush()
+					logger.Debug("Closing sink", "sink", es.name)
+					return
+				}
+				batch = append(batch, event)
+				if len(batch) >= es.batchSize {
+					flush()
+				}
+			case <-ticker.C:
+				flush()
+			}
+		}
+	}()
+}
+
+/************************** httpSender implementation **************************/
+
+//httpSender sends HTTP requests and retries the failed ones
+type httpSender struct {
+	name          string
+	client        *http.Client
+	retries       int
+	retryInterval time.Duration
+}
+
+//newHTTPSender returns sender with given request timeout retrying failed requests given count
+//of times, the first retry is made after given interval which is doubled with each next retry
+func newHTTPSender(name string, timeout time.Duration, retries int, retryInterval time.Duration) *httpSender {
+	if timeout == 0 {
+		timeout = defaultSinkTimeout
+	}
+	if retryInterval == 0 {
+		retryInterval = defaultSinkRetryInterval
+	}
+	return &httpSender{name: name, client: &http.Client{Timeout: timeout}, retries: retries, retryInterval: retryInterval}
+}
+
+//do sends single request. Returns true in case failed request should be retried.
+func (hs *httpSender) do(req *http.Request) (bool, error) {
+	resp, err := hs.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		logger.Debug("Unexpected response", "sink", hs.name, "status", resp.Status, "body", logging.Truncate(string(respBody)))
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return false, nil
+}
+
+//Send sends requests created by given function until one succeeds, fails with error which should
+//not be retried, retries are exhausted or given context is done. Requests failed due to network errors
+//or with 429 or 5xx response status are retried.
+func (hs *httpSender) Send(ctx context.Context, newRequest func() (*http.Request, error)) error {
+	interval := hs.retryInterval
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return err
+		}
+		retry, err := hs.do(req.WithContext(ctx))
+		if err == nil || !retry || attempt >= hs.retries || ctx.Err() != nil {
+			return err
+		}
+		logger.Debug("Retrying request", "sink", hs.name, "attempt", attempt+1, "error", err)
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return err
+		}
+		interval *= 2
+	}
+}
@@ -0,0 +1,133 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"text/template"
+	"time"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
+)
+
+//WebhookSignatureHeader is header carrying HMAC-SHA256 signature of webhook request body in format "sha256=<hex>"
+const WebhookSignatureHeader = "X-Smart-Gateway-Signature"
+
+//WebhookData is data passed to webhook body template
+type WebhookData struct {
+	Event  SinkEvent   //the first event of the batch
+	Events []SinkEvent //all events of the batch
+}
+
+//WebhookSink sends processed events in batches to HTTP endpoint
+type WebhookSink struct {
+	eventSink
+	config     saconfig.WebhookConfig
+	index      *regexp.Regexp
+	severities map[string]bool
+	template   *template.Template
+	sender     *httpSender
+}
+
+//NewWebhookSink returns sink configured by given webhook configuration
+func NewWebhookSink(config saconfig.WebhookConfig) (*WebhookSink, error) {
+	name := config.Name
+	if name == "" {
+		name = config.URL
+	}
+	sink := &WebhookSink{
+		config:     config,
+		severities: make(map[string]bool, len(config.Severities)),
+		sender:     newHTTPSender(name, time.Duration(config.Timeout)*time.Second, config.Retries, time.Duration(config.RetryInterval)*time.Second),
+	}
+	sink.eventSink = newEventSink(name, config.BatchSize, time.Duration(config.BatchInterval)*time.Second, config.QueueSize, sink.send)
+
+	var err error
+	if config.IndexPattern != "" {
+		if sink.index, err = regexp.Compile(fmt.Sprintf("^(?:%s)$", config.IndexPattern)); err != nil {
+			return nil, fmt.Errorf("invalid index pattern of webhook %s: %s", name, err)
+		}
+	}
+	for _, severity := range config.Severities {
+		sink.severities[severity] = true
+	}
+	if config.Template != "" {
+		funcs := template.FuncMap{"json": func(value interface{}) (string, error) {
+			out, err := json.Marshal(value)
+			return string(out), err
+		}}
+		if sink.template, err = template.New(name).Funcs(funcs).Parse(config.Template); err != nil {
+			return nil, fmt.Errorf("invalid template of webhook %s: %s", name, err)
+		}
+	}
+	return sink, nil
+}
+
+//Accepts returns true in case given event passes index and severity filters of the sink
+func (ws *WebhookSink) Accepts(event SinkEvent) bool {
+	if ws.index != nil && !ws.index.MatchString(event.Index) {
+		return false
+	}
+	return len(ws.severities) == 0 || ws.severities[event.Severity]
+}
+
+//Enqueue queues given event for sending in case it passes filters of the sink. Event is dropped
+//in case the queue is full.
+func (ws *WebhookSink) Enqueue(event SinkEvent) {
+	if ws.Accepts(event) {
+		ws.eventSink.Enqueue(event)
+	}
+}
+
+//Body returns request body of given batch of events
+func (ws *WebhookSink) Body(batch []SinkEvent) ([]byte, error) {
+	if ws.template != nil {
+		var body bytes.Buffer
+		if err := ws.template.Execute(&body, WebhookData{Event: batch[0], Events: batch}); err != nil {
+			return nil, err
+		}
+		return body.Bytes(), nil
+	}
+	if ws.batchSize == 1 {
+		return json.Marshal(batch[0].Data)
+	}
+	data := make([]interface{}, 0, len(batch))
+	for _, event := range batch {
+		data = append(data, event.Data)
+	}
+	return json.Marshal(data)
+}
+
+//send sends given batch of events, failed requests are retried according to configuration of the sink
+func (ws *WebhookSink) send(ctx context.Context, batch []SinkEvent) error {
+	body, err := ws.Body(batch)
+	if err != nil {
+		return fmt.Errorf("failed to render request body: %s", err)
+	}
+	var signature string
+	if ws.config.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(ws.config.Secret))
+		mac.Write(body)
+		signature = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	return ws.sender.Send(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", ws.config.URL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for name, value := range ws.config.Headers {
+			req.Header.Set(name, value)
+		}
+		if signature != "" {
+			req.Header.Set(WebhookSignatureHeader, signature)
+		}
+		return req, nil
+	})
+}
@@ -0,0 +1,119 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/amqp10"
+	"github.com/infrawatch/smart-gateway/internal/pkg/api"
+	"github.com/infrawatch/smart-gateway/internal/pkg/cacheutil"
+	"github.com/infrawatch/smart-gateway/internal/pkg/events"
+	"github.com/infrawatch/smart-gateway/internal/pkg/logging"
+	"github.com/infrawatch/smart-gateway/internal/pkg/metrics"
+	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
+	"github.com/infrawatch/smart-gateway/internal/pkg/tsdb"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//CombinedHandlerHTML contains HTML for default endpoint in combined mode
+const CombinedHandlerHTML = `
+<html>
+	<head>
+		<title>Smart Gateway</title>
+	</head>
+	<body>
+		<h1>Smart Gateway</h1>
+		<ul>
+			<li><a href='/metrics'>/metrics</a> GET metric data of all hosts and of the gateway itself</li>
+			<li>/metrics/host/{host} GET metric data of given host</li>
+			<li>/federate?match[]={selector} GET metric data matching given series selectors</li>
+			<li>/alert POST alerts in JSON format on to AMQP message bus</li>
+			<li><a href='/healthz'>/healthz</a> GET health of both pipelines and their dependencies</li>
+			<li>/readyz GET readiness of both pipelines, responds with 503 when any of them is not ready</li>
+		</ul>
+	</body>
+</html>
+`
+
+var logger = logging.New("gateway")
+
+//loadConfig loads combined configuration. Logging is configured by the Metrics section, so its logging
+//options are copied to the Events section and both pipelines apply the same logging configuration.
+func loadConfig(configPath string) (*saconfig.CombinedConfiguration, error) {
+	conf, err := saconfig.LoadCombinedConfiguration(configPath)
+	if err != nil {
+		return nil, err
+	}
+	conf.Events.Debug = conf.Metrics.Debug
+	conf.Events.LogFormat = conf.Metrics.LogFormat
+	conf.Events.LogLevel = conf.Metrics.LogLevel
+	conf.Events.LogLevels = conf.Metrics.LogLevels
+	return conf, nil
+}
+
+//StartCombined is the entry point for running both metrics and events pipelines of smart-gateway
+//in one process. Pipelines share HTTP server, which exposes metrics of both, and signal handling.
+func StartCombined(configPath string, serviceType string, uniqueName string) {
+	var wg, httpWg sync.WaitGroup
+	finish, httpFinish := make(chan bool), make(chan bool)
+
+	amqp10.SpawnSignalHandler(finish, os.Interrupt, syscall.SIGTERM)
+
+	serverConfig, err := loadConfig(configPath)
+	if err != nil {
+		logger.Fatal("Config Parse Error", "error", err)
+	}
+	serverConfig.Metrics.ServiceType = serviceType
+	serverConfig.Events.ServiceType = serviceType
+
+	if !serverConfig.Metrics.CPUStats {
+		// Including these stats kills performance when Prometheus polls with multiple targets
+		prometheus.Unregister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+		prometheus.Unregister(prometheus.NewGoCollector())
+	}
+	handler := http.NewServeMux()
+	handler.Handle("/metrics", tsdb.NewInstrumentedExpositionHandler(prometheus.DefaultRegisterer, prometheus.DefaultGatherer))
+	handler.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(CombinedHandlerHTML))
+	})
+
+	metricsHealth := metrics.SpawnMetrics(&wg, finish, &serverConfig.Metrics, func() (*saconfig.MetricConfiguration, error) {
+		conf, err := loadConfig(configPath)
+		if err != nil {
+			return nil, err
+		}
+		return &conf.Metrics, nil
+	}, uniqueName+"-metrics", handler, metrics.Dependencies{})
+	eventsHandler := http.NewServeMux()
+	eventsHealth := events.SpawnEvents(&wg, finish, &serverConfig.Events, func() (*saconfig.EventConfiguration, error) {
+		conf, err := loadConfig(configPath)
+		if err != nil {
+			return nil, err
+		}
+		return &conf.Events, nil
+	}, uniqueName+"-events", eventsHandler, events.Dependencies{})
+	api.RegisterHealthHandlers(handler, map[string]*cacheutil.ApplicationHealthCache{"metrics": metricsHealth, "events": eventsHealth})
+	root, tlsConfig, err := metrics.NewExporterHandler(&serverConfig.Metrics, handler)
+	if err != nil {
+		logger.Fatal("Failed to configure HTTP server", "error", err)
+	}
+	// alert relay is protected by events API authentication instead of the exporter one
+	root.Handle("/alert", api.NewProtectedHandler(eventsHandler, serverConfig.Events.API))
+
+	address := fmt.Sprintf("%s:%d", serverConfig.Metrics.Exporterhost, serverConfig.Metrics.Exporterport)
+	shutdownTimeout := serverConfig.Metrics.ShutdownTimeout
+	if serverConfig.Events.ShutdownTimeout > shutdownTimeout {
+		shutdownTimeout = serverConfig.Events.ShutdownTimeout
+	}
+	api.SpawnHTTPServer(&httpWg, httpFinish, "HTTP", address, root, tlsConfig, time.Duration(shutdownTimeout)*time.Second)
+
+	// do not end until all loop goroutines ends, HTTP server is closed as the last one
+	wg.Wait()
+	close(httpFinish)
+	httpWg.Wait()
+	logger.Info("Exiting")
+}
@@ -0,0 +1,334 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+/***************************** Level implementation ****************************/
+
+//Level is severity of logged message
+type Level int
+
+const (
+	//LevelDebug marks messages useful for debugging only
+	LevelDebug Level = iota
+	//LevelInfo marks messages informing about normal operation
+	LevelInfo
+	//LevelWarn marks messages about unexpected situations which do not break operation
+	LevelWarn
+	//LevelError marks messages about failures
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+//String returns human readable level identification.
+func (lvl Level) String() string {
+	return levelNames[lvl]
+}
+
+//SetFromString resets value according to given human readable identification. Returns false if invalid identification was given.
+func (lvl *Level) SetFromString(name string) bool {
+	for index, value := range levelNames {
+		if strings.ToLower(name) == value {
+			*lvl = Level(index)
+			return true
+		}
+	}
+	return false
+}
+
+/**************************** Format implementation ****************************/
+
+//Format is format of logged records
+type Format int
+
+const (
+	//FormatLogfmt formats records as key=value pairs
+	FormatLogfmt Format = iota
+	//FormatJSON formats records as JSON objects
+	FormatJSON
+)
+
+var formatNames = []string{"logfmt", "json"}
+
+//String returns human readable format identification.
+func (format Format) String() string {
+	return formatNames[format]
+}
+
+//SetFromString resets value according to given human readable identification. Returns false if invalid identification was given.
+func (format *Format) SetFromString(name string) bool {
+	for index, value := range formatNames {
+		if strings.ToLower(name) == value {
+			*format = Format(index)
+			return true
+		}
+	}
+	return false
+}
+
+/*************************** Settings implementation ***************************/
+
+//MaxBodyLength is maximal length of message bodies included in log records, see Truncate
+var MaxBodyLength = 512
+
+//settings holds process wide logging configuration
+type settings struct {
+	lock   sync.RWMutex
+	out    io.Writer
+	format Format
+	level  Level
+	levels map[string]Level // component -> level
+}
+
+var current = &settings{out: os.Stderr, format: FormatLogfmt, level: LevelInfo, levels: map[string]Level{}}
+
+//Configure sets format of records, default level and levels of components (eg. "amqp10") overriding the default.
+func Configure(format Format, level Level, levels map[string]Level) {
+	componentLevels := make(map[string]Level, len(levels))
+	for component, lvl := range levels {
+		componentLevels[component] = lvl
+	}
+	current.lock.Lock()
+	defer current.lock.Unlock()
+	current.format = format
+	current.level = level
+	current.levels = componentLevels
+}
+
+//SetLevels sets default level and levels of components keeping format of records
+func SetLevels(level Level, levels map[string]Level) {
+	componentLevels := make(map[string]Level, len(levels))
+	for component, lvl := range levels {
+		componentLevels[component] = lvl
+	}
+	current.lock.Lock()
+	defer current.lock.Unlock()
+	current.level = level
+	current.levels = componentLevels
+}
+
+//Levels returns default level and levels of components
+func Levels() (Level, map[string]Level) {
+	current.lock.RLock()
+	defer current.lock.RUnlock()
+	levels := make(map[string]Level, len(current.levels))
+	for component, lvl := range current.levels {
+		levels[component] = lvl
+	}
+	return current.level, levels
+}
+
+//Setup configures logging from configuration options. Default level is "debug" when debug is true and "info"
+//otherwise in case level is empty. Empty format means logfmt.
+func Setup(debug bool, format string, level string, levels map[string]string) error {
+	var fmtID Format
+	if format != "" && !fmtID.SetFromString(format) {
+		return fmt.Errorf("invalid log format '%s'", format)
+	}
+	lvlID := LevelInfo
+	if debug {
+		lvlID = LevelDebug
+	}
+	if level != "" && !lvlID.SetFromString(level) {
+		return fmt.Errorf("invalid log level '%s'", level)
+	}
+	componentLevels := make(map[string]Level, len(levels))
+	for component, name := range levels {
+		var lvl Level
+		if !lvl.SetFromString(name) {
+			return fmt.Errorf("invalid log level '%s' of component %s", name, component)
+		}
+		componentLevels[component] = lvl
+	}
+	Configure(fmtID, lvlID, componentLevels)
+	return nil
+}
+
+//SetOutput sets writer to which records are written, standard error output is used by default
+func SetOutput(out io.Writer) {
+	current.lock.Lock()
+	defer current.lock.Unlock()
+	current.out = out
+}
+
+/**************************** Logger implementation ****************************/
+
+//Logger writes structured records of single component. Records consist of timestamp, level, component,
+//message and key-value pairs given to logging methods or to With.
+type Logger struct {
+	component string
+	fields    []interface{}
+}
+
+//New returns logger of given component. Component name is used for configuration of component's level.
+func New(component string) *Logger {
+	return &Logger{component: component}
+}
+
+//With returns logger which adds given key-value pairs to each record
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+	return &Logger{component: l.component, fields: fields}
+}
+
+//Enabled returns true if records of given level are written
+func (l *Logger) Enabled(level Level) bool {
+	current.lock.RLock()
+	defer current.lock.RUnlock()
+	threshold, ok := current.levels[l.component]
+	if !ok {
+		threshold = current.level
+	}
+	return level >= threshold
+}
+
+//Debug writes record of debug level
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LevelDebug, msg, keyvals)
+}
+
+//Info writes record of info level
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log(LevelInfo, msg, keyvals)
+}
+
+//Warn writes record of warn level
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.log(LevelWarn, msg, keyvals)
+}
+
+//Error writes record of error level
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+}
+
+//Fatal writes record of error level and exits the process
+func (l *Logger) Fatal(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+	os.Exit(1)
+}
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	record := make([]interface{}, 0, 8+len(l.fields)+len(keyvals))
+	record = append(record, "ts", time.Now().Format(time.RFC3339Nano), "level", level, "component", l.component, "msg", msg)
+	record = append(record, l.fields...)
+	record = append(record, keyvals...)
+	if len(record)%2 != 0 {
+		record = append(record, "MISSING")
+	}
+
+	current.lock.RLock()
+	defer current.lock.RUnlock()
+	var buffer bytes.Buffer
+	if current.format == FormatJSON {
+		encodeJSON(&buffer, record)
+	} else {
+		encodeLogfmt(&buffer, record)
+	}
+	// read lock allows concurrent logging, so writes have to be serialized separately
+	writeLock.Lock()
+	current.out.Write(buffer.Bytes())
+	writeLock.Unlock()
+}
+
+var writeLock sync.Mutex
+
+//stringValue returns string representation of given value
+func stringValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
+
+func encodeLogfmt(buffer *bytes.Buffer, record []interface{}) {
+	for index := 0; index < len(record); index += 2 {
+		if index > 0 {
+			buffer.WriteByte(' ')
+		}
+		buffer.WriteString(stringValue(record[index]))
+		buffer.WriteByte('=')
+		value := stringValue(record[index+1])
+		if value == "" || strings.ContainsAny(value, " =\"\t\r\n") || !utf8.ValidString(value) {
+			value = strconv.Quote(value)
+		}
+		buffer.WriteString(value)
+	}
+	buffer.WriteByte('\n')
+}
+
+func encodeJSON(buffer *bytes.Buffer, record []interface{}) {
+	buffer.WriteByte('{')
+	for index := 0; index < len(record); index += 2 {
+		if index > 0 {
+			buffer.WriteByte(',')
+		}
+		key, _ := json.Marshal(stringValue(record[index]))
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		var value interface{}
+		switch v := record[index+1].(type) {
+		case bool, int, int32, int64, uint, uint32, uint64, float32, float64:
+			value = v
+		default:
+			value = stringValue(v)
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			encoded, _ = json.Marshal(fmt.Sprint(value))
+		}
+		buffer.Write(encoded)
+	}
+	buffer.WriteString("}\n")
+}
+
+//Truncate shortens given message body to MaxBodyLength, so that logging of large messages does not flood the output
+func Truncate(body string) string {
+	if len(body) <= MaxBodyLength {
+		return body
+	}
+	return fmt.Sprintf("%s... (%d bytes)", body[:MaxBodyLength], len(body))
+}
+
+//RedactURL replaces password of user info in given URL by "xxxxx", so that the URL can be logged or exported
+//as metric label. URLs without scheme, eg. "user:pass@127.0.0.1:5672/collectd/telemetry", are accepted too.
+func RedactURL(url string) string {
+	prefix, rest := "", url
+	if index := strings.Index(url, "://"); index >= 0 {
+		prefix, rest = url[:index+3], url[index+3:]
+	}
+	authority := rest
+	if index := strings.IndexAny(rest, "/?#"); index >= 0 {
+		authority = rest[:index]
+	}
+	at := strings.LastIndex(authority, "@")
+	if at < 0 {
+		return url
+	}
+	user := authority[:at]
+	if index := strings.Index(user, ":"); index >= 0 {
+		user = user[:index] + ":xxxxx"
+	}
+	return prefix + user + rest[at:]
+}
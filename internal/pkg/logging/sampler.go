@@ -0,0 +1,44 @@
+package logging
+
+import (
+	"sync"
+	"time"
+)
+
+//Sampler limits logging of repeated messages (eg. parse errors of the same connection) to one record
+//per key in given interval
+type Sampler struct {
+	interval time.Duration
+	lock     sync.Mutex
+	samples  map[string]*sample
+}
+
+type sample struct {
+	logged     time.Time
+	suppressed int
+}
+
+//NewSampler returns sampler allowing one record per key in given interval
+func NewSampler(interval time.Duration) *Sampler {
+	return &Sampler{interval: interval, samples: make(map[string]*sample)}
+}
+
+//Allow returns true in case record of given key should be logged together with count of records
+//suppressed since the last allowed one
+func (s *Sampler) Allow(key string) (bool, int) {
+	now := time.Now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	smpl, ok := s.samples[key]
+	if !ok {
+		s.samples[key] = &sample{logged: now}
+		return true, 0
+	}
+	if now.Sub(smpl.logged) < s.interval {
+		smpl.suppressed++
+		return false, smpl.suppressed
+	}
+	suppressed := smpl.suppressed
+	smpl.logged, smpl.suppressed = now, 0
+	return true, suppressed
+}
@@ -2,10 +2,13 @@ package incoming
 
 import (
 	"fmt"
-	"log"
+	"path"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/infrawatch/smart-gateway/internal/pkg/logging"
 	jsoniter "github.com/json-iterator/go"
 )
 
@@ -17,6 +20,125 @@ var (
 
 const defaultCeilometerInterval = 5.0
 
+// ceilometerUnits translates Ceilometer counter units to OpenMetrics base units
+var ceilometerUnits = map[string]string{
+	"B":       "bytes",
+	"B/s":     "bytes_per_second",
+	"s":       "seconds",
+	"sec":     "seconds",
+	"packet":  "packets",
+	"packets": "packets",
+	"request": "requests",
+	"%":       "percent",
+}
+
+var (
+	ceilometerLock = new(sync.RWMutex)
+	// ceilometerLabels maps label names to paths of values in sample payload
+	ceilometerLabels = map[string][]string{}
+	// ceilometerIntervals maps meter names or glob patterns to polling intervals
+	ceilometerIntervals     = map[string]float64{}
+	ceilometerIntervalCache = map[string]float64{}
+	ceilometerInterval      = defaultCeilometerInterval
+)
+
+//SetCeilometerLabels activates labels generated from Ceilometer sample payload. Given map contains
+//label names as keys and dot separated paths to the value in payload (eg. "resource_metadata.host").
+func SetCeilometerLabels(labels map[string]string) error {
+	paths := make(map[string][]string, len(labels))
+	for name, valuePath := range labels {
+		if !labelNameRe.MatchString(name) {
+			return fmt.Errorf("invalid label name '%s'", name)
+		}
+		if valuePath == "" {
+			return fmt.Errorf("empty payload path for label '%s'", name)
+		}
+		paths[name] = strings.Split(valuePath, ".")
+	}
+
+	ceilometerLock.Lock()
+	defer ceilometerLock.Unlock()
+	ceilometerLabels = paths
+	return nil
+}
+
+//SetCeilometerIntervals sets polling intervals of Ceilometer meters. Given map contains meter names
+//or glob patterns (eg. "disk.*") as keys and intervals in seconds as values. Meters not matching any
+//key will use given default interval (or defaultCeilometerInterval if the given one is not positive).
+func SetCeilometerIntervals(intervals map[string]float64, defaultInterval float64) error {
+	for meter, interval := range intervals {
+		if _, err := path.Match(meter, ""); err != nil {
+			return fmt.Errorf("invalid meter pattern '%s': %s", meter, err)
+		}
+		if interval <= 0 {
+			return fmt.Errorf("invalid interval %f for meter '%s'", interval, meter)
+		}
+	}
+	if defaultInterval <= 0 {
+		defaultInterval = defaultCeilometerInterval
+	}
+
+	ceilometerLock.Lock()
+	defer ceilometerLock.Unlock()
+	ceilometerIntervals = make(map[string]float64, len(intervals))
+	for meter, interval := range intervals {
+		ceilometerIntervals[meter] = interval
+	}
+	ceilometerIntervalCache = make(map[string]float64)
+	ceilometerInterval = defaultInterval
+	return nil
+}
+
+//meterInterval returns configured interval for given meter. Exact meter name has precedence
+//over patterns and the longest matching pattern has precedence over shorter ones.
+func meterInterval(meter string) float64 {
+	ceilometerLock.RLock()
+	if interval, ok := ceilometerIntervalCache[meter]; ok {
+		ceilometerLock.RUnlock()
+		return interval
+	}
+	interval, ok := ceilometerIntervals[meter]
+	if !ok {
+		interval = ceilometerInterval
+		matched := ""
+		for pattern, value := range ceilometerIntervals {
+			if ok, _ := path.Match(pattern, meter); ok && len(pattern) > len(matched) {
+				matched = pattern
+				interval = value
+			}
+		}
+	}
+	ceilometerLock.RUnlock()
+
+	ceilometerLock.Lock()
+	defer ceilometerLock.Unlock()
+	ceilometerIntervalCache[meter] = interval
+	return interval
+}
+
+//payloadValue returns string representation of value on given path in payload
+func payloadValue(payload map[string]interface{}, valuePath []string) (string, bool) {
+	var current interface{} = payload
+	for _, key := range valuePath {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if current, ok = obj[key]; !ok {
+			return "", false
+		}
+	}
+	switch value := current.(type) {
+	case string:
+		return value, true
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(value), true
+	}
+	return "", false
+}
+
 // CeilometerMetric struct represents a single instance of metric data formated and sent by Ceilometer
 type CeilometerMetric struct {
 	WithDataSource
@@ -39,7 +161,9 @@ func (c *CeilometerMetric) getwholeID() string {
 		if cnt, ok := c.Payload["counter_name"]; ok {
 			c.wholeID = cnt.(string)
 		} else {
-			log.Printf("Did not find counter_name in metric payload: %v\n", c.Payload)
+			if ok, suppressed := warnings.Allow("counter_name"); ok {
+				logger.Warn("Did not find counter_name in metric payload", "payload", logging.Truncate(fmt.Sprint(c.Payload)), "suppressed", suppressed)
+			}
 			c.wholeID = "unknown"
 		}
 	}
@@ -85,8 +209,8 @@ func (c *CeilometerMetric) SetData(data MetricDataFormat) {
 		values := make([]float64, 0, 1)
 		if val, ok := ceilo.Payload["counter_volume"]; ok {
 			values = append(values, val.(float64))
-		} else {
-			log.Printf("Did not find counter_volume in metric payload: %v\n", ceilo.Payload)
+		} else if ok, suppressed := warnings.Allow("counter_volume"); ok {
+			logger.Warn("Did not find counter_volume in metric payload", "payload", logging.Truncate(fmt.Sprint(ceilo.Payload)), "suppressed", suppressed)
 		}
 		c.Values = values
 		c.SetNew(true)
@@ -102,7 +226,8 @@ func (c *CeilometerMetric) sanitize(data string) string {
 	if len(sub) == 2 {
 		sanitized = rexForNestedQuote.ReplaceAllString(sub[1], `"`)
 	} else {
-		log.Printf("Failed to find oslo.message in given message: %s\n", data)
+		// message is reported by caller in case it cannot be parsed
+		logger.Debug("Failed to find oslo.message in given message", "message", logging.Truncate(data))
 	}
 	// avoid getting payload data wrapped in array
 	item := rexForPayload.FindStringSubmatch(sanitized)
@@ -166,12 +291,11 @@ func (c *CeilometerMetric) ParseInputByte(data []byte) error {
 	return err
 }
 
-//GetInterval returns hardcoded defaultCeilometerInterval, because Ceilometer metricDesc
+//GetInterval returns configured polling interval of the meter, because Ceilometer metricDesc
 //does not contain interval information (are not periodically sent at all) and any reasonable
-//interval might be needed for expiry setting for Prometheus
-//TODO: Make this configurable
+//interval is needed for expiry setting for Prometheus
 func (c *CeilometerMetric) GetInterval() float64 {
-	return defaultCeilometerInterval
+	return meterInterval(c.getwholeID())
 }
 
 //SetNew ...
@@ -189,6 +313,14 @@ func (c *CeilometerMetric) ISNew() bool {
 //GetLabels ...
 func (c *CeilometerMetric) GetLabels() map[string]string {
 	labels := make(map[string]string)
+	ceilometerLock.RLock()
+	for name, valuePath := range ceilometerLabels {
+		if value, ok := payloadValue(c.Payload, valuePath); ok {
+			labels[name] = value
+		}
+	}
+	ceilometerLock.RUnlock()
+	// labels generated below have precedence over configured metadata labels
 	if c.TypeInstance != "" {
 		labels[c.Plugin] = c.TypeInstance
 	} else {
@@ -223,6 +355,15 @@ func (c *CeilometerMetric) GetMetricName(index int) string {
 	return strings.Join(nameParts, "_")
 }
 
+//GetMetricUnit returns counter_unit of the meter translated to OpenMetrics base unit. Empty string
+//is returned for units which do not have base unit equivalent (eg. "ns" or "MB").
+func (c *CeilometerMetric) GetMetricUnit(index int) string {
+	if cunit, ok := c.Payload["counter_unit"].(string); ok {
+		return ceilometerUnits[cunit]
+	}
+	return ""
+}
+
 //GetMetricDesc ...
 func (c *CeilometerMetric) GetMetricDesc(index int) string {
 	dstype := "counter"
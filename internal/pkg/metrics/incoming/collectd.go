@@ -2,7 +2,6 @@ package incoming
 
 import (
 	"fmt"
-	"log"
 	"strconv"
 
 	"collectd.org/cdtime"
@@ -50,7 +49,6 @@ func (c *CollectdMetric) ParseInputByte(data []byte) error {
 	var json = jsoniter.ConfigCompatibleWithStandardLibrary
 	err := json.Unmarshal(data, &cparse)
 	if err != nil {
-		log.Printf("Error parsing InputByte: %s", err)
 		return err
 	}
 	c1 := cparse[0]
@@ -120,7 +118,6 @@ func (c *CollectdMetric) ParseInputJSON(jsonString string) ([]MetricDataFormat,
 	var json = jsoniter.ConfigCompatibleWithStandardLibrary
 	err := json.Unmarshal(jsonBlob, &collect)
 	if err != nil {
-		log.Println("Error parsing json:", err)
 		return nil, err
 	}
 	retDtype := make([]MetricDataFormat, len(collect))
@@ -133,8 +130,37 @@ func (c *CollectdMetric) ParseInputJSON(jsonString string) ([]MetricDataFormat,
 
 /*************************** tsdb.TSDB interface *****************************/
 
+//mappingFor returns configured mapping for the metric and template variables for the data source on given index
+func (c CollectdMetric) mappingFor(index int) (*collectdMapping, map[string]string) {
+	mapping, vars := findCollectdMapping(&c)
+	if mapping != nil {
+		vars["dsname"] = c.DSName(index)
+		if index < len(c.Dstypes) {
+			vars["dstype"] = c.Dstypes[index]
+		}
+	}
+	return mapping, vars
+}
+
 //GetLabels ...
 func (c CollectdMetric) GetLabels() map[string]string {
+	return c.GetMetricLabels(0)
+}
+
+//GetMetricLabels returns labels for the data source on given index. Labels are generated
+//by matching collectd mapping if there is any, otherwise default labelling is used.
+func (c CollectdMetric) GetMetricLabels(index int) map[string]string {
+	if mapping, vars := c.mappingFor(index); mapping != nil && mapping.config.Labels != nil {
+		labels := make(map[string]string, len(mapping.config.Labels)+1)
+		for name, template := range mapping.config.Labels {
+			labels[name] = expand(template, vars)
+		}
+		if _, ok := labels["instance"]; !ok {
+			labels["instance"] = c.Host
+		}
+		return labels
+	}
+
 	labels := map[string]string{}
 	if c.PluginInstance != "" {
 		labels[c.Plugin] = c.PluginInstance
@@ -163,6 +189,9 @@ func (c CollectdMetric) GetLabels() map[string]string {
 
 //GetMetricDesc   newDesc converts one data source of a value list to a Prometheus description.
 func (c CollectdMetric) GetMetricDesc(index int) string {
+	if mapping, vars := c.mappingFor(index); mapping != nil && mapping.config.Help != "" {
+		return expand(mapping.config.Help, vars)
+	}
 	help := fmt.Sprintf("Service Telemetry exporter: '%s' Type: '%s' Dstype: '%s' Dsname: '%s'",
 		c.Plugin, c.Type, c.Dstypes[index], c.DSName(index))
 	return help
@@ -170,6 +199,10 @@ func (c CollectdMetric) GetMetricDesc(index int) string {
 
 //GetMetricName  ...
 func (c CollectdMetric) GetMetricName(index int) string {
+	if mapping, vars := c.mappingFor(index); mapping != nil && mapping.config.Name != "" {
+		return expand(mapping.config.Name, vars)
+	}
+
 	name := "collectd_" + c.Plugin + "_" + c.Type
 	if c.Plugin == c.Type {
 		name = "collectd_" + c.Type
@@ -186,6 +219,15 @@ func (c CollectdMetric) GetMetricName(index int) string {
 	return name
 }
 
+//GetMetricUnit returns unit of the data source on given index. Collectd does not transfer units,
+//so only units given by matching collectd mapping are known.
+func (c CollectdMetric) GetMetricUnit(index int) string {
+	if mapping, vars := c.mappingFor(index); mapping != nil {
+		return expand(mapping.config.Unit, vars)
+	}
+	return ""
+}
+
 //GetItemKey  ...
 func (c CollectdMetric) GetItemKey() string {
 	name := c.Plugin + "_" + c.Type
@@ -1,9 +1,18 @@
 package incoming
 
 import (
+	"time"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/logging"
 	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
 )
 
+var (
+	logger = logging.New("metrics/incoming")
+	// warnings about invalid data are logged at most once per minute
+	warnings = logging.NewSampler(time.Minute)
+)
+
 //MetricDataFormat   ...
 type MetricDataFormat interface {
 	GetName() string
@@ -36,6 +45,8 @@ func NewFromDataSource(source saconfig.DataSource) MetricDataFormat {
 		return newCollectdMetric( /*...*/ )
 	case saconfig.DataSourceCeilometer:
 		return newCeilometerMetric()
+	case saconfig.DataSourceUniversal:
+		return newUniversalMetric()
 	}
 	return nil
 }
@@ -47,6 +58,8 @@ func NewFromDataSourceName(source string) MetricDataFormat {
 		return newCollectdMetric( /*...*/ )
 	case saconfig.DataSourceCeilometer.String():
 		return newCeilometerMetric()
+	case saconfig.DataSourceUniversal.String():
+		return newUniversalMetric()
 	}
 	return nil
 }
@@ -64,6 +77,12 @@ func newCeilometerMetric() *CeilometerMetric {
 	return metric
 }
 
+func newUniversalMetric() *UniversalMetric {
+	metric := new(UniversalMetric)
+	metric.DataSource = saconfig.DataSourceUniversal
+	return metric
+}
+
 //ParseByte  parse incoming data
 func ParseByte(dataItem MetricDataFormat, data []byte) error {
 	return dataItem.ParseInputByte(data)
@@ -0,0 +1,126 @@
+package incoming
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
+)
+
+var (
+	labelNameRe = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+	mappingLock = new(sync.RWMutex)
+	// collectdMappings holds compiled mapping table in order of configuration
+	collectdMappings []*collectdMapping
+)
+
+//collectdMapping is compiled form of saconfig.CollectdMetricMapping
+type collectdMapping struct {
+	plugin         *regexp.Regexp
+	pluginInstance *regexp.Regexp
+	typ            *regexp.Regexp
+	typeInstance   *regexp.Regexp
+	config         saconfig.CollectdMetricMapping
+}
+
+//newCollectdMapping validates and compiles given mapping
+func newCollectdMapping(conf saconfig.CollectdMetricMapping) (*collectdMapping, error) {
+	var err error
+	mapping := collectdMapping{config: conf}
+	patterns := []struct {
+		name    string
+		pattern string
+		target  **regexp.Regexp
+	}{
+		{"Plugin", conf.Plugin, &mapping.plugin},
+		{"PluginInstance", conf.PluginInstance, &mapping.pluginInstance},
+		{"Type", conf.Type, &mapping.typ},
+		{"TypeInstance", conf.TypeInstance, &mapping.typeInstance},
+	}
+	for _, item := range patterns {
+		if *item.target, err = saconfig.CompileMappingPattern(item.pattern); err != nil {
+			return nil, fmt.Errorf("invalid %s pattern '%s': %s", item.name, item.pattern, err)
+		}
+	}
+	for label := range conf.Labels {
+		if !labelNameRe.MatchString(label) {
+			return nil, fmt.Errorf("invalid label name '%s'", label)
+		}
+	}
+	return &mapping, nil
+}
+
+//match returns true and template variables extracted from given metric if the metric matches the mapping
+func (m *collectdMapping) match(c *CollectdMetric) (bool, map[string]string) {
+	vars := map[string]string{
+		"host":            c.Host,
+		"plugin":          c.Plugin,
+		"plugin_instance": c.PluginInstance,
+		"type":            c.Type,
+		"type_instance":   c.TypeInstance,
+	}
+	checks := []struct {
+		rex   *regexp.Regexp
+		value string
+	}{
+		{m.plugin, c.Plugin},
+		{m.pluginInstance, c.PluginInstance},
+		{m.typ, c.Type},
+		{m.typeInstance, c.TypeInstance},
+	}
+	for _, check := range checks {
+		if check.rex == nil {
+			continue
+		}
+		submatch := check.rex.FindStringSubmatch(check.value)
+		if submatch == nil {
+			return false, nil
+		}
+		for index, name := range check.rex.SubexpNames() {
+			if name != "" {
+				vars[name] = submatch[index]
+			}
+		}
+	}
+	return true, vars
+}
+
+//expand replaces ${var} and $var references in given template with values from vars
+func expand(template string, vars map[string]string) string {
+	return os.Expand(template, func(key string) string {
+		return vars[key]
+	})
+}
+
+//SetCollectdMappings validates and activates mapping table used for naming and labelling of collectd
+//metrics. Mappings are evaluated in given order and the first matching one is used. Metrics which
+//do not match any mapping are named and labelled by the default scheme.
+func SetCollectdMappings(mappings []saconfig.CollectdMetricMapping) error {
+	compiled := make([]*collectdMapping, 0, len(mappings))
+	for index, conf := range mappings {
+		mapping, err := newCollectdMapping(conf)
+		if err != nil {
+			return fmt.Errorf("collectd mapping %d: %s", index, err)
+		}
+		compiled = append(compiled, mapping)
+	}
+
+	mappingLock.Lock()
+	defer mappingLock.Unlock()
+	collectdMappings = compiled
+	return nil
+}
+
+//findCollectdMapping returns first mapping matching given metric together with extracted template variables
+func findCollectdMapping(c *CollectdMetric) (*collectdMapping, map[string]string) {
+	mappingLock.RLock()
+	defer mappingLock.RUnlock()
+	for _, mapping := range collectdMappings {
+		if ok, vars := mapping.match(c); ok {
+			return mapping, vars
+		}
+	}
+	return nil, nil
+}
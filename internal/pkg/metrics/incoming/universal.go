@@ -0,0 +1,215 @@
+package incoming
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
+	jsoniter "github.com/json-iterator/go"
+)
+
+//Types of universal metrics
+const (
+	UniversalGauge   = "gauge"
+	UniversalCounter = "counter"
+)
+
+//defaults of optional fields of universal metrics
+const (
+	defaultUniversalInterval = 10.0
+	defaultUniversalInstance = "universal"
+)
+
+var metricNameRe = regexp.MustCompile("^[a-zA-Z_:][a-zA-Z0-9_:]*$")
+
+//UniversalMetric struct represents single metric in smart-gateway universal format. Messages contain
+//JSON list of metrics, eg.:
+//  [{"name": "edge_cpu_seconds_total", "labels": {"instance": "edge-0", "cpu": "0"}, "value": 1234.5,
+//    "type": "counter", "timestamp": 1583312400.0, "interval": 10}]
+//Name and value are required. Type is "gauge" (default) or "counter", timestamp is Unix time in seconds
+//(time of receiving by default) and interval is collection interval in seconds (10 by default). Metrics
+//are cached by value of "instance" label, which is "universal" in case it is missing.
+type UniversalMetric struct {
+	WithDataSource
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels"`
+	Value     *float64          `json:"value"`
+	Type      string            `json:"type"`
+	Timestamp float64           `json:"timestamp"`
+	Interval  float64           `json:"interval"`
+	new       bool
+}
+
+//validate checks required fields of the metric and sets defaults of optional ones
+func (u *UniversalMetric) validate() error {
+	if !metricNameRe.MatchString(u.Name) {
+		return fmt.Errorf("invalid metric name '%s'", u.Name)
+	}
+	if u.Value == nil {
+		return fmt.Errorf("missing value of metric '%s'", u.Name)
+	}
+	for name := range u.Labels {
+		if !labelNameRe.MatchString(name) {
+			return fmt.Errorf("invalid label name '%s' of metric '%s'", name, u.Name)
+		}
+	}
+	switch u.Type {
+	case "":
+		u.Type = UniversalGauge
+	case UniversalGauge, UniversalCounter:
+	default:
+		return fmt.Errorf("invalid type '%s' of metric '%s', expected %s or %s", u.Type, u.Name, UniversalGauge, UniversalCounter)
+	}
+	if u.Interval < 0 {
+		return fmt.Errorf("invalid interval %f of metric '%s'", u.Interval, u.Name)
+	}
+	return nil
+}
+
+/*************************** MetricDataFormat interface ****************************/
+
+//GetName returns name of the metric
+func (u *UniversalMetric) GetName() string {
+	return u.Name
+}
+
+//GetValues returns value of the metric
+func (u *UniversalMetric) GetValues() []float64 {
+	if u.Value == nil {
+		return []float64{}
+	}
+	return []float64{*u.Value}
+}
+
+//GetKey returns value of "instance" label, which identifies host reporting the metric. Metrics without
+//the label are reported by host "universal".
+func (u *UniversalMetric) GetKey() string {
+	if instance, ok := u.Labels["instance"]; ok && instance != "" {
+		return instance
+	}
+	return defaultUniversalInstance
+}
+
+//GetItemKey returns name of the metric together with its sorted labels
+func (u *UniversalMetric) GetItemKey() string {
+	names := make([]string, 0, len(u.Labels))
+	for name := range u.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%q", name, u.Labels[name]))
+	}
+	return fmt.Sprintf("%s{%s}", u.Name, strings.Join(parts, ","))
+}
+
+//GetInterval returns collection interval of the metric
+func (u *UniversalMetric) GetInterval() float64 {
+	if u.Interval > 0 {
+		return u.Interval
+	}
+	return defaultUniversalInterval
+}
+
+//SetData copies data of given universal metric
+func (u *UniversalMetric) SetData(data MetricDataFormat) {
+	if universal, ok := data.(*UniversalMetric); ok {
+		u.DataSource = universal.DataSource
+		u.Name = universal.Name
+		u.Labels = universal.Labels
+		u.Value = universal.Value
+		u.Type = universal.Type
+		u.Timestamp = universal.Timestamp
+		u.Interval = universal.Interval
+		u.SetNew(true)
+	}
+}
+
+//ParseInputJSON parses list of universal metrics. Invalid metrics are skipped and reported
+//in returned error together with valid metrics of the message.
+func (u *UniversalMetric) ParseInputJSON(jsonString string) ([]MetricDataFormat, error) {
+	parsed := []UniversalMetric{}
+	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+	if err := json.Unmarshal([]byte(jsonString), &parsed); err != nil {
+		return nil, err
+	}
+	now := float64(time.Now().UnixNano()) / 1e9
+	metrics := make([]MetricDataFormat, 0, len(parsed))
+	problems := []string{}
+	for index := range parsed {
+		metric := &parsed[index]
+		if err := metric.validate(); err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if metric.Timestamp == 0 {
+			metric.Timestamp = now
+		}
+		metric.DataSource = saconfig.DataSourceUniversal
+		metric.SetNew(true)
+		metrics = append(metrics, metric)
+	}
+	if len(problems) > 0 {
+		return metrics, fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return metrics, nil
+}
+
+//ParseInputByte parses the first metric of given list of universal metrics
+func (u *UniversalMetric) ParseInputByte(data []byte) error {
+	metrics, err := u.ParseInputJSON(string(data))
+	if err != nil {
+		return err
+	}
+	if len(metrics) == 0 {
+		return fmt.Errorf("no metric found")
+	}
+	u.SetData(metrics[0])
+	return nil
+}
+
+//SetNew ...
+func (u *UniversalMetric) SetNew(new bool) {
+	u.new = new
+}
+
+//ISNew ...
+func (u *UniversalMetric) ISNew() bool {
+	return u.new
+}
+
+/*************************** tsdb.TSDB interface *****************************/
+
+//GetLabels returns labels of the metric
+func (u *UniversalMetric) GetLabels() map[string]string {
+	labels := make(map[string]string, len(u.Labels))
+	for name, value := range u.Labels {
+		labels[name] = value
+	}
+	return labels
+}
+
+//GetMetricName returns name of the metric
+func (u *UniversalMetric) GetMetricName(index int) string {
+	return u.Name
+}
+
+//GetMetricDesc returns help of the metric
+func (u *UniversalMetric) GetMetricDesc(index int) string {
+	return fmt.Sprintf("Service Telemetry exporter: '%s' Type: '%s'", u.Name, u.Type)
+}
+
+//GetMetricUnit returns empty string, universal format does not transfer units
+func (u *UniversalMetric) GetMetricUnit(index int) string {
+	return ""
+}
+
+//GetTime returns time of the metric
+func (u *UniversalMetric) GetTime() time.Time {
+	seconds := int64(u.Timestamp)
+	return time.Unix(seconds, int64((u.Timestamp-float64(seconds))*1e9))
+}
@@ -1,26 +1,25 @@
 package metrics
 
 import (
-	"flag"
+	"crypto/tls"
 	"fmt"
-	"log"
-	"math/rand"
 	"net/http"
 	"net/http/pprof"
 	"os"
 	"reflect"
-	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
-	"github.com/MakeNowJust/heredoc"
 	"github.com/infrawatch/smart-gateway/internal/pkg/amqp10"
 	"github.com/infrawatch/smart-gateway/internal/pkg/api"
 	"github.com/infrawatch/smart-gateway/internal/pkg/cacheutil"
+	"github.com/infrawatch/smart-gateway/internal/pkg/logging"
 	"github.com/infrawatch/smart-gateway/internal/pkg/metrics/incoming"
 	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
+	"github.com/infrawatch/smart-gateway/internal/pkg/tsdb"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 //MetricHandlerHTML contains HTML for default endpoint
@@ -30,43 +29,84 @@ const MetricHandlerHTML = `
 		<body>
 			<h1>Collectd Exporter</h1>
 			<p><a href='/metrics'>Metrics</a></p>
+			<p><a href='/healthz'>Health</a></p>
 		</body>
 </html>
 `
 
 var (
-	debugm = func(format string, data ...interface{}) {} // Default no debugging output
-	debugs = func(count int) {}                          // Default no debugging output
+	logger = logging.New("metrics")
+	//parseFailures limits logging of parse failures to one record per connection and minute
+	parseFailures = logging.NewSampler(time.Minute)
 )
 
 /*************** HTTP HANDLER***********************/
 type cacheHandler struct {
 	useTimestamp bool
+	exportRates  bool
 	cache        *cacheutil.IncomingDataCache
 	appstate     *api.MetricHandler
+	hosts        map[string]bool   // hosts to be exported, all hosts when nil
+	filter       tsdb.MetricFilter // filter of exported metrics, all metrics when nil
+	readOnly     bool              // exports last values of cached metrics without marking them exported
+}
+
+//withFilter returns copy of the handler exporting last values of cached metrics of given hosts matching
+//given filter. The copy does not modify the cache, so it does not take metrics away from other scrapers,
+//and it does not export metrics of the gateway itself.
+func (c *cacheHandler) withFilter(hosts []string, filter tsdb.MetricFilter) *cacheHandler {
+	filtered := *c
+	filtered.filter = filter
+	filtered.readOnly = true
+	if len(hosts) > 0 {
+		filtered.hosts = make(map[string]bool, len(hosts))
+		for _, host := range hosts {
+			filtered.hosts[host] = true
+		}
+	}
+	return &filtered
 }
 
 // Describe implements prometheus.Collector.
 func (c *cacheHandler) Describe(ch chan<- *prometheus.Desc) {
-	c.appstate.Describe(ch)
+	if !c.readOnly {
+		c.appstate.Describe(ch)
+	}
 }
 
 //Collect implements prometheus.Collector.
 //need improvement add lock etc etc
 func (c *cacheHandler) Collect(ch chan<- prometheus.Metric) {
 	//lastPull.Set(float64(time.Now().UnixNano()) / 1e9)
-	c.appstate.Collect(ch)
+	if !c.readOnly {
+		c.appstate.Collect(ch)
+	}
 	var metricCount int
 	//ch <- lastPull
 	lock, allHosts := c.cache.GetHosts()
 	defer lock.Unlock()
-	debugm("Debug:Prometheus is requesting to scrape metrics...")
+	logger.Debug("Prometheus is requesting to scrape metrics")
 	for key, plugin := range allHosts {
-		debugm("Debug:Getting metrics for host %s  with total plugin size %d\n", key, plugin.Size())
-		metricCount = plugin.FlushPrometheusMetric(c.useTimestamp, ch)
-		if metricCount > 0 {
-			// add heart if there is atleast one new metrics for the host
-			debugm("Debug:Adding heartbeat for host %s.", key)
+		if c.hosts != nil && !c.hosts[key] {
+			continue
+		}
+		logger.Debug("Getting metrics for host", "host", key, "plugins", plugin.Size())
+		if c.readOnly {
+			// host is alive as long as any of its matching metrics is cached
+			metricCount = plugin.CollectFilteredPrometheusMetric(c.useTimestamp, c.exportRates, c.filter, ch)
+			if metricCount > 0 {
+				cacheutil.AddHeartBeat(key, 1.0, ch)
+			} else {
+				cacheutil.AddHeartBeat(key, 0.0, ch)
+			}
+			cacheutil.AddMetricsByHostCount(key, float64(metricCount), ch)
+			continue
+		}
+		metricCount = plugin.FlushPrometheusMetric(c.useTimestamp, c.exportRates, ch)
+		if metricCount > 0 || plugin.Restored() {
+			// add heart if there is atleast one new metrics for the host or host's data
+			// were restored from snapshot and did not expire yet
+			logger.Debug("Adding heartbeat for host", "host", key)
 			cacheutil.AddHeartBeat(key, 1.0, ch)
 		} else {
 			cacheutil.AddHeartBeat(key, 0.0, ch)
@@ -75,143 +115,352 @@ func (c *cacheHandler) Collect(ch chan<- prometheus.Metric) {
 		cacheutil.AddMetricsByHostCount(key, float64(metricCount), ch)
 		//this will clean up all zero plugins
 		if plugin.Size() == 0 {
-			debugm("Debug:Cleaning all empty plugins.")
-			debugm("Debug:Deleting host key %s\n", key)
 			delete(allHosts, key)
-			debugm("Debug:Cleaned up cache for host %s", key)
+			logger.Debug("Cleaned up cache for host", "host", key)
 		}
 	}
 }
 
-/*************** main routine ***********************/
-// metricusage and command-line flags
-func metricusage() {
-	doc := heredoc.Doc(`
-	********************* config *********************
-	$go run cmd/main.go -config smartgateway_config.json -servicetype metrics
-	**************************************************`)
-
-	fmt.Fprintln(os.Stderr, `Required commandline argument missing`)
-	fmt.Fprintln(os.Stdout, doc)
-	flag.PrintDefaults()
+//filteredMetricsHandler serves only metrics of hosts given in path (/metrics/host/{host}) and/or metrics
+//matching selectors given in match[] query parameters. Last values of all cached metrics are served
+//and the cache is not modified, so the scrapes do not take metrics away from /metrics or each other.
+func filteredMetricsHandler(cache *cacheHandler, collectors ...prometheus.Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var hosts []string
+		if strings.HasPrefix(r.URL.Path, "/metrics/host/") {
+			host := strings.Trim(strings.TrimPrefix(r.URL.Path, "/metrics/host/"), "/")
+			if host == "" {
+				http.Error(w, "host name is missing in the path", http.StatusBadRequest)
+				return
+			}
+			hosts = append(hosts, host)
+		}
+		match := r.URL.Query()["match[]"]
+		if len(hosts) == 0 && len(match) == 0 {
+			http.Error(w, "at least one match[] parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		registry := prometheus.NewRegistry()
+		var gatherer prometheus.Gatherer = registry
+		if len(match) > 0 {
+			selectors, err := tsdb.ParseSelectors(match)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			registry.MustRegister(cache.withFilter(hosts, selectors))
+			gatherer = tsdb.NewFilteredGatherer(registry, selectors)
+		} else {
+			registry.MustRegister(cache.withFilter(hosts, nil))
+		}
+		if len(hosts) == 0 {
+			// metrics of the gateway itself are not related to any host
+			registry.MustRegister(collectors...)
+		}
+		tsdb.NewExpositionHandler(gatherer).ServeHTTP(w, r)
+	})
 }
 
-//StartMetrics ... entry point to metrics
-func StartMetrics() {
-	var wg sync.WaitGroup
-	finish := make(chan bool)
+/*************** configuration ***********************/
+//prepareConfiguration fills options which are derived from other options of loaded configuration
+func prepareConfiguration(serverConfig *saconfig.MetricConfiguration) {
+	if len(serverConfig.AMQP1MetricURL) > 0 {
+		serverConfig.AMQP1Connections = []saconfig.AMQPConnection{
+			saconfig.AMQPConnection{
+				URL:          serverConfig.AMQP1MetricURL,
+				DataSourceID: saconfig.DataSourceCollectd,
+				DataSource:   "collectd",
+			},
+		}
+	}
+}
 
-	amqp10.SpawnSignalHandler(finish, os.Interrupt)
+//setupLogging configures format and levels of logging
+func setupLogging(serverConfig *saconfig.MetricConfiguration) error {
+	return logging.Setup(serverConfig.Debug, serverConfig.LogFormat, serverConfig.LogLevel, serverConfig.LogLevels)
+}
 
-	// set flags for parsing options
-	flag.Usage = metricusage
-	fServiceType := flag.String("servicetype", "metrics", "Metric type")
-	fConfigLocation := flag.String("config", "", "Path to configuration file.")
-	fUniqueName := flag.String("uname", "metrics-"+strconv.Itoa(rand.Intn(100)), "Unique name across application")
-	flag.Parse()
+//applyMappings configures naming and labeling of metrics created from incoming data
+func applyMappings(serverConfig *saconfig.MetricConfiguration) error {
+	if err := incoming.SetCollectdMappings(serverConfig.CollectdMappings); err != nil {
+		return err
+	}
+	if err := incoming.SetCeilometerLabels(serverConfig.CeilometerMetadataLabels); err != nil {
+		return err
+	}
+	return incoming.SetCeilometerIntervals(serverConfig.CeilometerIntervals, serverConfig.CeilometerDefaultInterval)
+}
 
-	var serverConfig *saconfig.MetricConfiguration
-	if len(*fConfigLocation) > 0 { //load configuration
-		conf, err := saconfig.LoadConfiguration(*fConfigLocation, "metric")
-		if err != nil {
-			log.Fatal("Config Parse Error: ", err)
+//reloadConfiguration loads configuration again and applies changes of options which do not require
+//restart. Returns updated configuration or nil in case there is no change to apply.
+func reloadConfiguration(loadConfig func() (*saconfig.MetricConfiguration, error), running *saconfig.MetricConfiguration) *saconfig.MetricConfiguration {
+	logger.Info("Reloading metrics configuration")
+	reloaded, err := loadConfig()
+	if err != nil {
+		logger.Error("Failed to reload configuration, keeping current one", "error", err)
+		return nil
+	}
+	reloaded.ServiceType = running.ServiceType
+	prepareConfiguration(reloaded)
+
+	reloadable, restart := saconfig.ChangedOptions(running, reloaded)
+	if len(restart) > 0 {
+		logger.Warn("Ignoring change of option(s), restart is required to apply it", "options", strings.Join(restart, ","))
+	}
+	if len(reloadable) == 0 {
+		logger.Info("No configuration change to apply")
+		return nil
+	}
+
+	updated := *running
+	updated.Debug = reloaded.Debug
+	updated.AMQP1MetricURL = reloaded.AMQP1MetricURL
+	updated.AMQP1Connections = reloaded.AMQP1Connections
+	updated.CollectdMappings = reloaded.CollectdMappings
+	updated.CeilometerMetadataLabels = reloaded.CeilometerMetadataLabels
+	updated.CeilometerIntervals = reloaded.CeilometerIntervals
+	updated.CeilometerDefaultInterval = reloaded.CeilometerDefaultInterval
+	updated.Readiness = reloaded.Readiness
+	updated.LogFormat = reloaded.LogFormat
+	updated.LogLevel = reloaded.LogLevel
+	updated.LogLevels = reloaded.LogLevels
+	if err := applyMappings(&updated); err != nil {
+		logger.Error("Failed to reload configuration, keeping current one", "error", err)
+		if err := applyMappings(running); err != nil {
+			logger.Error("Failed to restore metric mappings", "error", err)
 		}
-		serverConfig = conf.(*saconfig.MetricConfiguration)
-		serverConfig.ServiceType = *fServiceType
-	} else {
-		metricusage()
-		os.Exit(1)
+		return nil
 	}
-	if serverConfig.Debug {
-		debugm = func(format string, data ...interface{}) { log.Printf(format, data...) }
+	if err := setupLogging(&updated); err != nil {
+		logger.Error("Failed to reconfigure logging", "error", err)
 	}
+	logger.Info("Applied change of option(s)", "options", strings.Join(reloadable, ","))
+	return &updated
+}
 
-	if len(serverConfig.AMQP1MetricURL) == 0 && len(serverConfig.AMQP1Connections) == 0 {
-		log.Println("Configuration option 'AMQP1MetricURL' or 'AMQP1Connections' is required")
-		metricusage()
-		os.Exit(1)
+/*************** main routine ***********************/
+//Dependencies holds external systems used by metrics pipeline. Collectors are registered to default
+//Prometheus registry when Registerer is nil.
+type Dependencies struct {
+	Registerer prometheus.Registerer
+}
+
+//RegisterDebugHandlers registers pprof handlers to given multiplexer
+func RegisterDebugHandlers(handler *http.ServeMux) {
+	handler.HandleFunc("/debug/pprof/", pprof.Index)
+	handler.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	handler.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	handler.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	handler.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+//NewExporterHandler returns handler of metrics exporter serving endpoints registered to given multiplexer
+//and pprof endpoints together with TLS configuration of the exporter (nil for plain HTTP). Requests are
+//authenticated according to web configuration file, pprof endpoints are protected by PprofUsers instead
+//in case they are configured and probe endpoints are not authenticated. Other routes can be registered
+//to returned multiplexer.
+func NewExporterHandler(serverConfig *saconfig.MetricConfiguration, handler *http.ServeMux) (*http.ServeMux, *tls.Config, error) {
+	webConfig := &saconfig.WebConfig{}
+	if serverConfig.WebConfigFile != "" {
+		var err error
+		if webConfig, err = saconfig.LoadWebConfig(serverConfig.WebConfigFile); err != nil {
+			return nil, nil, err
+		}
+	}
+	var tlsConfig *tls.Config
+	if webConfig.TLSServerConfig != nil {
+		var err error
+		if tlsConfig, err = api.NewWebTLSConfig(webConfig.TLSServerConfig); err != nil {
+			return nil, nil, err
+		}
+	}
+	protect := func(h http.Handler, users map[string]string) http.Handler {
+		if len(users) == 0 {
+			return h
+		}
+		return api.NewBasicAuthHandler(h, users)
 	}
 
-	if len(serverConfig.AMQP1MetricURL) > 0 {
-		serverConfig.AMQP1Connections = []saconfig.AMQPConnection{
-			saconfig.AMQPConnection{
-				URL:          serverConfig.AMQP1MetricURL,
-				DataSourceID: saconfig.DataSourceCollectd,
-				DataSource:   "collectd",
-			},
+	root := http.NewServeMux()
+	root.Handle("/", protect(handler, webConfig.BasicAuthUsers))
+	for _, path := range api.ProbePaths {
+		root.Handle(path, handler)
+	}
+	if serverConfig.DisablePprof {
+		root.Handle("/debug/pprof/", http.NotFoundHandler())
+	} else {
+		debug := http.NewServeMux()
+		RegisterDebugHandlers(debug)
+		users := webConfig.BasicAuthUsers
+		if len(serverConfig.PprofUsers) > 0 {
+			users = serverConfig.PprofUsers
 		}
+		root.Handle("/debug/pprof/", protect(debug, users))
+	}
+	return root, tlsConfig, nil
+}
+
+//SpawnMetrics spawns metrics pipeline consisting of AMQP1.0 listeners, metric processor and cache server.
+//Endpoints exporting cached metrics are registered to given multiplexer, but the HTTP server has to be
+//started by caller. Configuration is reloaded using loadConfig on SIGHUP. When finish channel is closed,
+//receiving of new messages is stopped and already received messages are processed before all goroutines end.
+//External systems can be replaced by given dependencies. Returns health cache of the pipeline.
+func SpawnMetrics(wg *sync.WaitGroup, finish chan bool, serverConfig *saconfig.MetricConfiguration, loadConfig func() (*saconfig.MetricConfiguration, error), uniqueName string, handler *http.ServeMux, deps Dependencies) *cacheutil.ApplicationHealthCache {
+	prepareConfiguration(serverConfig)
+	if err := setupLogging(serverConfig); err != nil {
+		logger.Fatal("Config Parse Error", "error", err)
+	}
+
+	if err := applyMappings(serverConfig); err != nil {
+		logger.Fatal("Config Parse Error", "error", err)
+	}
+	if len(serverConfig.CollectdMappings) > 0 {
+		logger.Info("Loaded collectd metric mappings", "count", len(serverConfig.CollectdMappings))
 	}
 
 	for _, conn := range serverConfig.AMQP1Connections {
-		log.Printf("AMQP1.0 %s listen address configured at %s\n", conn.DataSource, conn.URL)
+		logger.Info("AMQP1.0 listen address configured", "datasource", conn.DataSource, "url", logging.RedactURL(conn.URL))
 	}
 
 	applicationHealth := cacheutil.NewApplicationHealthCache()
+	applicationHealth.SetReadiness(serverConfig.Readiness)
 	metricHandler := api.NewAppStateMetricHandler(applicationHealth)
 	amqpHandler := amqp10.NewAMQPHandler("Metric Consumer")
 	//Cache sever to process and serve the exporter
 	cacheServer := cacheutil.NewCacheServer(cacheutil.MAXTTL, serverConfig.Debug)
-	cacheHandler := &cacheHandler{useTimestamp: serverConfig.UseTimeStamp, cache: cacheServer.GetCache(), appstate: metricHandler}
-	prometheus.MustRegister(cacheHandler, amqpHandler)
+	processed := make(chan bool) // closed when metric processor ends
 
-	if !serverConfig.CPUStats {
-		// Including these stats kills performance when Prometheus polls with multiple targets
-		prometheus.Unregister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
-		prometheus.Unregister(prometheus.NewGoCollector())
+	if serverConfig.CacheSnapshotFile != "" {
+		count, err := cacheServer.GetCache().RestoreSnapshot(serverConfig.CacheSnapshotFile)
+		if err != nil {
+			logger.Error("Failed to restore cache snapshot", "file", serverConfig.CacheSnapshotFile, "error", err)
+		} else {
+			logger.Info("Restored cached items from snapshot", "file", serverConfig.CacheSnapshotFile, "count", count)
+		}
+		// final snapshot is written after all received metrics were processed
+		cacheServer.SpawnSnapshotWriter(wg, processed, serverConfig.CacheSnapshotFile, time.Duration(serverConfig.CacheSnapshotInterval)*time.Second)
 	}
-	//Set up Metric Exporter
-	handler := http.NewServeMux()
-	handler.Handle("/metrics", promhttp.Handler())
-	handler.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(MetricHandlerHTML))
-	})
-	// Register pprof handlers
-	handler.HandleFunc("/debug/pprof/", pprof.Index)
-	handler.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
-	handler.HandleFunc("/debug/pprof/profile", pprof.Profile)
-	handler.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
-	handler.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	cacheHandler := &cacheHandler{useTimestamp: serverConfig.UseTimeStamp, exportRates: serverConfig.ExportCounterRates, cache: cacheServer.GetCache(), appstate: metricHandler}
+	registerer := deps.Registerer
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	registerer.MustRegister(cacheHandler, amqpHandler)
 
-	debugm("Debug: Config %#v\n", serverConfig)
-	//run exporter for prometheus to scrape
-	go func() {
-		metricsURL := fmt.Sprintf("%s:%d", serverConfig.Exporterhost, serverConfig.Exporterport)
-		log.Printf("Metric server at : %s\n", metricsURL)
-		log.Fatal(http.ListenAndServe(metricsURL, handler))
-	}()
-	time.Sleep(2 * time.Second)
-	log.Println("HTTP server is ready....")
+	handler.Handle("/metrics/host/", filteredMetricsHandler(cacheHandler, cacheHandler.appstate, amqpHandler))
+	handler.Handle("/federate", filteredMetricsHandler(cacheHandler, cacheHandler.appstate, amqpHandler))
+	if serverConfig.AdminToken != "" {
+		api.RegisterAdminHandler(handler, cacheServer.GetCache(), serverConfig.AdminToken)
+		logger.Info("Admin API enabled", "path", api.AdminPrefix)
+	}
+	logger.Debug("Configuration loaded", "config", fmt.Sprintf("%+v", saconfig.Redacted(serverConfig)))
 
 	// AMQP connection(s)
-	processingCases, qpidStatusCases, amqpServers := amqp10.CreateMessageLoopComponents(serverConfig, finish, amqpHandler, *fUniqueName)
-	amqp10.SpawnQpidStatusReporter(&wg, applicationHealth, qpidStatusCases)
+	processingCases, qpidStatusCases, amqpServers := amqp10.CreateMessageLoopComponents(serverConfig, finish, amqpHandler, uniqueName)
+	stopReporter := amqp10.SpawnQpidStatusReporter(wg, applicationHealth, qpidStatusCases, amqpServers)
+	processingCases = append(processingCases, reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(amqp10.SpawnReloadSignalHandler()),
+	})
 
 	// spawn metric processor
 	wg.Add(1)
-	go func() {
+	go func(runningConfig *saconfig.MetricConfiguration) {
 		defer wg.Done()
-		finishCase := len(processingCases) - 1
+		defer close(processed)
+		process := func(item amqp10.AMQPServerItem, msg string) {
+			logger.Debug("Received message", "url", logging.RedactURL(item.Server.GetURL()), "datasource", item.DataSource, "body", logging.Truncate(msg))
+			start := time.Now()
+			stats := item.Server.GetStats()
+			metric := incoming.NewFromDataSource(item.DataSource)
+			applicationHealth.MessageReceived(item.Server.GetURL(), item.DataSource.String())
+			metrics, err := metric.ParseInputJSON(msg)
+			if err != nil {
+				stats.IncParseFailures()
+				if ok, suppressed := parseFailures.Allow(item.Server.GetURL()); ok {
+					logger.Warn("Failed to parse received metrics", "url", logging.RedactURL(item.Server.GetURL()), "datasource", item.DataSource,
+						"error", err, "body", logging.Truncate(msg), "suppressed", suppressed)
+				}
+			}
+			for _, m := range metrics {
+				item.Server.UpdateMinCollectInterval(m.GetInterval())
+				cacheServer.Put(m)
+			}
+			logger.Debug("Parsed metrics", "count", len(metrics))
+			stats.IncProcessed(time.Since(start))
+		}
 	processingLoop:
 		for {
+			// cases for connections are followed by finish and reload cases
+			finishCase, reloadCase := len(amqpServers), len(amqpServers)+1
 			switch index, msg, _ := reflect.Select(processingCases); index {
 			case finishCase:
 				break processingLoop
-			default:
-				debugm("Debug: Getting incoming data from notifier channel : %#v\n", msg)
-				metric := incoming.NewFromDataSource(amqpServers[index].DataSource)
-				amqpServers[index].Server.GetHandler().IncTotalMsgProcessed()
-				metrics, _ := metric.ParseInputJSON(msg.String())
-				for _, m := range metrics {
-					amqpServers[index].Server.UpdateMinCollectInterval(m.GetInterval())
-					cacheServer.Put(m)
+			case reloadCase:
+				updatedConfig := reloadConfiguration(loadConfig, runningConfig)
+				if updatedConfig == nil {
+					continue
 				}
-				debugs(len(metrics))
+				runningConfig = updatedConfig
+				stopReporter()
+				processingCases, qpidStatusCases, amqpServers = amqp10.UpdateMessageLoopComponents(runningConfig, finish, amqpHandler, uniqueName, processingCases, amqpServers)
+				stopReporter = amqp10.SpawnQpidStatusReporter(wg, applicationHealth, qpidStatusCases, amqpServers)
+				applicationHealth.SetReadiness(runningConfig.Readiness)
+			default:
+				process(amqpServers[index], msg.String())
+				amqpServers[index].Server.Processed()
 			}
 		}
-		log.Println("Closing event processor.")
-	}()
+		logger.Info("Draining received metrics")
+		amqp10.DrainMessages(amqpServers, time.Duration(runningConfig.ShutdownTimeout)*time.Second, process)
+		cacheServer.Flush()
+		logger.Info("Closing metric processor")
+	}(serverConfig)
+	return applicationHealth
+}
+
+//StartMetrics is the entry point for running smart-gateway in metrics mode
+func StartMetrics(configPath string, serviceType string, uniqueName string) {
+	var wg, httpWg sync.WaitGroup
+	finish, httpFinish := make(chan bool), make(chan bool)
+
+	amqp10.SpawnSignalHandler(finish, os.Interrupt, syscall.SIGTERM)
+
+	loadConfig := func() (*saconfig.MetricConfiguration, error) {
+		return saconfig.LoadMetricConfiguration(configPath)
+	}
+	serverConfig, err := loadConfig()
+	if err != nil {
+		logger.Fatal("Config Parse Error", "error", err)
+	}
+	serverConfig.ServiceType = serviceType
+
+	if !serverConfig.CPUStats {
+		// Including these stats kills performance when Prometheus polls with multiple targets
+		prometheus.Unregister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+		prometheus.Unregister(prometheus.NewGoCollector())
+	}
+	//Set up Metric Exporter
+	handler := http.NewServeMux()
+	handler.Handle("/metrics", tsdb.NewInstrumentedExpositionHandler(prometheus.DefaultRegisterer, prometheus.DefaultGatherer))
+	handler.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(MetricHandlerHTML))
+	})
+	applicationHealth := SpawnMetrics(&wg, finish, serverConfig, loadConfig, uniqueName, handler, Dependencies{})
+	api.RegisterHealthHandlers(handler, map[string]*cacheutil.ApplicationHealthCache{"metrics": applicationHealth})
+	exporter, tlsConfig, err := NewExporterHandler(serverConfig, handler)
+	if err != nil {
+		logger.Fatal("Failed to configure metrics exporter", "error", err)
+	}
+
+	//run exporter for prometheus to scrape
+	metricsURL := fmt.Sprintf("%s:%d", serverConfig.Exporterhost, serverConfig.Exporterport)
+	api.SpawnHTTPServer(&httpWg, httpFinish, "metric", metricsURL, exporter, tlsConfig, time.Duration(serverConfig.ShutdownTimeout)*time.Second)
 
-	// do not end until all loop goroutines ends
+	// do not end until all loop goroutines ends, HTTP server is closed as the last one
 	wg.Wait()
-	log.Println("Exiting")
+	close(httpFinish)
+	httpWg.Wait()
+	logger.Info("Exiting")
 }
@@ -1,10 +1,10 @@
 package saconfig
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"net"
+	"regexp"
+	"strings"
 )
 
 /************************** DataSource implementation **************************/
@@ -46,12 +46,161 @@ type AMQPConnection struct {
 	DataSourceID DataSource
 }
 
+/************************* KafkaConfig implementation *************************/
+
+//KafkaScheme is scheme of connection and publish URLs served by Kafka instead of AMQP1.0,
+//eg. kafka://broker-0:9092,broker-1:9092/collectd-telemetry
+const KafkaScheme = "kafka"
+
+//kafkaTopicRe matches valid names of Kafka topics
+var kafkaTopicRe = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,249}$`)
+
+//IsKafkaURL returns true in case given connection or publish URL is served by Kafka
+func IsKafkaURL(value string) bool {
+	return strings.HasPrefix(value, KafkaScheme+"://")
+}
+
+//ParseKafkaURL returns brokers and topic of given Kafka URL
+func ParseKafkaURL(value string) ([]string, string, error) {
+	if !IsKafkaURL(value) {
+		return nil, "", fmt.Errorf("expected %s:// scheme, got '%s'", KafkaScheme, value)
+	}
+	hosts, topic := strings.TrimPrefix(value, KafkaScheme+"://"), ""
+	if index := strings.Index(hosts, "/"); index >= 0 {
+		hosts, topic = hosts[:index], hosts[index+1:]
+	}
+	if !kafkaTopicRe.MatchString(topic) {
+		return nil, "", fmt.Errorf("invalid topic '%s' of URL '%s'", topic, value)
+	}
+	brokers := strings.Split(hosts, ",")
+	for _, broker := range brokers {
+		if host, port, err := net.SplitHostPort(broker); err != nil || host == "" || port == "" {
+			return nil, "", fmt.Errorf("invalid broker '%s' of URL '%s', expected host:port", broker, value)
+		}
+	}
+	return brokers, topic, nil
+}
+
+//Mechanisms of SASL authentication to Kafka brokers
+const (
+	KafkaSASLPlain       = "PLAIN"
+	KafkaSASLScramSHA256 = "SCRAM-SHA-256"
+	KafkaSASLScramSHA512 = "SCRAM-SHA-512"
+)
+
+//KafkaConfig holds client settings of all Kafka connections and publish URLs
+type KafkaConfig struct {
+	GroupID        string `json:"GroupID"`        //consumer group of connections, "smart-gateway" by default
+	StartOffset    string `json:"StartOffset"`    //"latest" (default) or "earliest", used for partitions without committed offset of the group
+	CommitInterval int    `json:"CommitInterval"` //time in seconds between commits of processed offsets, offset of each message is committed once it is processed if zero
+	UseTLS         bool   `json:"UseTls"`
+	TLSServerName  string `json:"TlsServerName"`
+	TLSCaCert      string `json:"TlsCaCert"`     //CA certificate verifying brokers, system CAs are used if empty
+	TLSClientCert  string `json:"TlsClientCert"` //client certificate presented to brokers
+	TLSClientKey   string `json:"TlsClientKey"`
+	SASLMechanism  string `json:"SASLMechanism"` //"PLAIN", "SCRAM-SHA-256" or "SCRAM-SHA-512", SASL authentication is not used if empty
+	SASLUser       string `json:"SASLUser"`
+	SASLPass       string `json:"SASLPass"`
+	SASLPassFile   string `json:"SASLPassFile"` //path to file containing SASLPass
+}
+
+/************************** MQTTConfig implementation *************************/
+
+//MQTTScheme is scheme of connection URLs subscribed at MQTT broker instead of AMQP1.0. Path of the URL
+//is topic filter which can contain + and # wildcards, eg. mqtt://broker:1883/edge/+/collectd/#
+const MQTTScheme = "mqtt"
+
+//IsMQTTURL returns true in case given connection URL is served by MQTT broker
+func IsMQTTURL(value string) bool {
+	return strings.HasPrefix(value, MQTTScheme+"://")
+}
+
+//ParseMQTTURL returns broker address and topic filter of given MQTT URL
+func ParseMQTTURL(value string) (string, string, error) {
+	if !IsMQTTURL(value) {
+		return "", "", fmt.Errorf("expected %s:// scheme, got '%s'", MQTTScheme, value)
+	}
+	broker, topic := strings.TrimPrefix(value, MQTTScheme+"://"), ""
+	if index := strings.Index(broker, "/"); index >= 0 {
+		broker, topic = broker[:index], broker[index+1:]
+	}
+	if host, port, err := net.SplitHostPort(broker); err != nil || host == "" || port == "" {
+		return "", "", fmt.Errorf("invalid broker '%s' of URL '%s', expected host:port", broker, value)
+	}
+	if topic == "" || len(topic) > 65535 || strings.ContainsRune(topic, 0) {
+		return "", "", fmt.Errorf("invalid topic filter '%s' of URL '%s'", topic, value)
+	}
+	levels := strings.Split(topic, "/")
+	for index, level := range levels {
+		// wildcards have to occupy whole level and multi-level wildcard has to be the last one
+		if (strings.Contains(level, "+") && level != "+") || (strings.Contains(level, "#") && (level != "#" || index != len(levels)-1)) {
+			return "", "", fmt.Errorf("invalid wildcard in topic filter '%s' of URL '%s'", topic, value)
+		}
+	}
+	return broker, topic, nil
+}
+
+//MQTTConfig holds client settings of all MQTT connections
+type MQTTConfig struct {
+	ClientID          string `json:"ClientID"`          //prefix of client identifiers of connections, UniqueName is used by default
+	QoS               int    `json:"QoS" env:"QOS"`     //maximum QoS of subscriptions, 0 (default), 1 or 2
+	PersistentSession bool   `json:"PersistentSession"` //broker keeps subscriptions and queues QoS 1 and 2 messages while the gateway is disconnected
+	KeepAlive         int    `json:"KeepAlive"`         //time in seconds between keepalive pings, 30 by default
+	Username          string `json:"Username"`
+	Password          string `json:"Password"`
+	PasswordFile      string `json:"PasswordFile"` //path to file containing Password
+	UseTLS            bool   `json:"UseTls"`
+	TLSServerName     string `json:"TlsServerName"`
+	TLSCaCert         string `json:"TlsCaCert"`     //CA certificate verifying brokers, system CAs are used if empty
+	TLSClientCert     string `json:"TlsClientCert"` //client certificate presented to brokers
+	TLSClientKey      string `json:"TlsClientKey"`
+}
+
+/********************* ReadinessConfig implementation ************************/
+
+//ReadinessConfig holds thresholds crossing of which makes the gateway not ready. Zero disables given check.
+type ReadinessConfig struct {
+	MaxMessageAge           int `json:"MaxMessageAge"`           //time in seconds since the last received message
+	MaxElasticFailures      int `json:"MaxElasticFailures"`      //count of consecutive failed Elasticsearch requests
+	MaxAlertManagerFailures int `json:"MaxAlertManagerFailures"` //count of consecutive failed Alertmanager notifications
+}
+
 /********************* EventConfiguration implementation *********************/
 
+//Authentication methods of API routes
+const (
+	APIAuthNone   = "none"
+	APIAuthBearer = "bearer"
+	APIAuthBasic  = "basic"
+)
+
 //EventAPIConfig ...
 type EventAPIConfig struct {
-	APIEndpointURL  string `json:"APIEndpointURL"`  //API endpoint
-	AMQP1PublishURL string `json:"AMQP1PublishURL"` // new amqp address to send notifications
+	APIEndpointURL  string            `json:"APIEndpointURL"`  //API endpoint
+	AMQP1PublishURL string            `json:"AMQP1PublishURL"` // new amqp address to send notifications
+	TLSCert         string            `json:"TlsCert"`         //server certificate, API is served over HTTPS when set
+	TLSKey          string            `json:"TlsKey"`          //server certificate key
+	TLSClientCA     string            `json:"TlsClientCa"`     //CA certificate verifying client certificates, client certificates are required when set
+	Auth            string            `json:"Auth"`            //authentication of all routes: "none" (default), "bearer" or "basic"
+	RouteAuth       map[string]string `json:"RouteAuth"`       //path (eg. "/alert", covering paths below it too) -> authentication overriding Auth
+	AuthToken       string            `json:"AuthToken"`       //token of bearer authentication
+	AuthTokenFile   string            `json:"AuthTokenFile"`   //path to file containing AuthToken
+	AuthUser        string            `json:"AuthUser"`        //user name of basic authentication
+	AuthPass        string            `json:"AuthPass"`        //password of basic authentication
+	AuthPassFile    string            `json:"AuthPassFile"`    //path to file containing AuthPass
+	MaxBodySize     int64             `json:"MaxBodySize"`     //size limit of request bodies in bytes, 1MiB by default
+	RateLimit       float64           `json:"RateLimit"`       //requests per second allowed to each client, rate is not limited if zero
+	RateBurst       int               `json:"RateBurst"`       //count of requests each client can make at once, defaults to RateLimit
+	SplitAlerts     bool              `json:"SplitAlerts"`     //relays each alert of Alertmanager webhook in separate message
+	PublishTimeout  int               `json:"PublishTimeout"`  //time in seconds given to the bus to accept relayed alerts, 10 by default
+	PublishTargets  []PublishTarget   `json:"PublishTargets"`  //addresses receiving relayed alerts matching their labels in addition to AMQP1PublishURL
+}
+
+//PublishTarget is AMQP1.0 address receiving relayed alerts which match all its label matchers
+type PublishTarget struct {
+	URL     string            `json:"URL"`
+	Match   map[string]string `json:"Match"`   //label -> value alert has to have
+	MatchRE map[string]string `json:"MatchRE"` //label -> regular expression value of alert's label has to match fully
 }
 
 //HandlerPath holds information about location of handler plugin and a data source type stream it should be applied on
@@ -62,85 +211,215 @@ type HandlerPath struct {
 
 //EventConfiguration ...
 type EventConfiguration struct {
-	Debug               bool             `json:"Debug"`
-	AMQP1EventURL       string           `json:"AMQP1EventURL"`
-	AMQP1Connections    []AMQPConnection `json:"AMQP1Connections"`
-	ElasticHostURL      string           `json:"ElasticHostURL"`
-	UseBasicAuth        bool             `json:"UseBasicAuth"`
-	ElasticUser         string           `json:"ElasticUser"`
-	ElasticPass         string           `json:"ElasticPass"`
-	API                 EventAPIConfig   `json:"API"`
-	AlertManagerURL     string           `json:"AlertManagerURL"`
-	AlertManagerEnabled bool             `json:"AlertManagerEnabled"`
-	APIEnabled          bool             `json:"APIEnabled"`
-	PublishEventEnabled bool             `json:"PublishEventEnabled"`
-	ResetIndex          bool             `json:"ResetIndex"`
-	Prefetch            int              `json:"Prefetch"`
-	UniqueName          string           `json:"UniqueName"`
-	ServiceType         string           `json:"ServiceType"`
-	IgnoreString        string           `json:"-"` //TODO(mmagr): ?
-	UseTLS              bool             `json:"UseTls"`
-	TLSServerName       string           `json:"TlsServerName"`
-	TLSClientCert       string           `json:"TlsClientCert"`
-	TLSClientKey        string           `json:"TlsClientKey"`
-	TLSCaCert           string           `json:"TlsCaCert"`
-	HandlerPlugins      []HandlerPath    `json:"HandlerPlugin"`
+	Debug               bool              `json:"Debug"`
+	AMQP1EventURL       string            `json:"AMQP1EventURL"`
+	AMQP1Connections    []AMQPConnection  `json:"AMQP1Connections"`
+	Kafka               KafkaConfig       `json:"Kafka"` //client settings of connections and publish URLs with kafka:// scheme
+	MQTT                MQTTConfig        `json:"MQTT"`  //client settings of connections with mqtt:// scheme
+	ElasticHostURL      string            `json:"ElasticHostURL"`
+	UseBasicAuth        bool              `json:"UseBasicAuth"`
+	ElasticUser         string            `json:"ElasticUser"`
+	ElasticPass         string            `json:"ElasticPass"`
+	ElasticPassFile     string            `json:"ElasticPassFile"` //path to file containing ElasticPass
+	API                 EventAPIConfig    `json:"API"`
+	AlertManagerURL     string            `json:"AlertManagerURL"`
+	AlertManagerEnabled bool              `json:"AlertManagerEnabled"`
+	APIEnabled          bool              `json:"APIEnabled"`
+	PublishEventEnabled bool              `json:"PublishEventEnabled"`
+	ResetIndex          bool              `json:"ResetIndex"`
+	Prefetch            int               `json:"Prefetch"`
+	UniqueName          string            `json:"UniqueName"`
+	ServiceType         string            `json:"ServiceType"`
+	IgnoreString        string            `json:"-"` //TODO(mmagr): ?
+	UseTLS              bool              `json:"UseTls"`
+	TLSServerName       string            `json:"TlsServerName"`
+	TLSClientCert       string            `json:"TlsClientCert"`
+	TLSClientKey        string            `json:"TlsClientKey"`
+	TLSCaCert           string            `json:"TlsCaCert"`
+	HandlerPlugins      []HandlerPath     `json:"HandlerPlugin"`
+	ShutdownTimeout     int               `json:"ShutdownTimeout"` //time in seconds given to processing of already received events, to sending of queued events to sinks and to active API requests on shutdown
+	Readiness           ReadinessConfig   `json:"Readiness"`
+	LogFormat           string            `json:"LogFormat"` //"logfmt" (default) or "json"
+	LogLevel            string            `json:"LogLevel"`  //"debug", "info", "warn" or "error", defaults to "debug" if Debug is enabled and to "info" otherwise
+	LogLevels           map[string]string `json:"LogLevels"` //component (eg. "amqp10") -> level overriding LogLevel
+	Webhooks            []WebhookConfig   `json:"Webhooks"`  //HTTP endpoints receiving processed events
+	Loki                LokiConfig        `json:"Loki"`
+}
+
+//LokiConfig holds configuration of sink pushing processed events to Loki
+type LokiConfig struct {
+	URL           string            `json:"URL"`           //URL of push API, eg. http://loki:3100/loki/api/v1/push, events are not pushed if empty
+	Format        string            `json:"Format"`        //"json" (default) or "protobuf"
+	Labels        []string          `json:"Labels"`        //event fields used as stream labels (host, index and severity), all by default
+	StaticLabels  map[string]string `json:"StaticLabels"`  //labels added to all streams
+	TenantID      string            `json:"TenantID"`      //tenant sent in X-Scope-OrgID header
+	AuthUser      string            `json:"AuthUser"`      //user name of basic authentication
+	AuthPass      string            `json:"AuthPass"`      //password of basic authentication
+	AuthPassFile  string            `json:"AuthPassFile"`  //path to file containing AuthPass
+	BatchSize     int               `json:"BatchSize"`     //count of events pushed in single request, 100 by default
+	BatchInterval int               `json:"BatchInterval"` //time in seconds after which incomplete batch is pushed, 5 by default
+	QueueSize     int               `json:"QueueSize"`     //count of events waiting for pushing, 1000 by default, events are dropped when the queue is full
+	Timeout       int               `json:"Timeout"`       //request timeout in seconds, 10 by default
+	Retries       int               `json:"Retries"`       //count of retries of requests failed with network error, 429 or 5xx status
+	RetryInterval int               `json:"RetryInterval"` //time in seconds before the first retry, doubled with each retry, 1 by default
+}
+
+//WebhookConfig holds configuration of webhook sink POSTing processed events to HTTP endpoint
+type WebhookConfig struct {
+	Name          string            `json:"Name"` //name of the sink used in logs, defaults to URL
+	URL           string            `json:"URL"`
+	Headers       map[string]string `json:"Headers"`       //additional request headers
+	Template      string            `json:"Template"`      //Go template of request body, JSON of event data (array of them for batches) by default
+	Secret        string            `json:"Secret"`        //key of HMAC-SHA256 signature of request body sent in X-Smart-Gateway-Signature header
+	SecretFile    string            `json:"SecretFile"`    //path to file containing Secret
+	BatchSize     int               `json:"BatchSize"`     //count of events sent in single request, 1 by default
+	BatchInterval int               `json:"BatchInterval"` //time in seconds after which incomplete batch is sent, 5 by default
+	QueueSize     int               `json:"QueueSize"`     //count of events waiting for sending, 1000 by default, events are dropped when the queue is full
+	Timeout       int               `json:"Timeout"`       //request timeout in seconds, 10 by default
+	Retries       int               `json:"Retries"`       //count of retries of requests failed with network error, 429 or 5xx status
+	RetryInterval int               `json:"RetryInterval"` //time in seconds before the first retry, doubled with each retry, 1 by default
+	IndexPattern  string            `json:"IndexPattern"`  //regular expression Elasticsearch index of sent events has to match fully
+	Severities    []string          `json:"Severities"`    //severities (critical, warning, info or unknown) of sent events, all by default
 }
 
 /******************** MetricConfiguration implementation *********************/
 
+//CollectdMetricMapping defines name, help text and labels of Prometheus metrics created from collectd
+//data matching given plugin and type patterns. All patterns are anchored regular expressions
+//and empty pattern matches anything. Named capture groups of the instance patterns together
+//with ${host}, ${plugin}, ${plugin_instance}, ${type}, ${type_instance}, ${dsname} and ${dstype}
+//can be used in Name, Help and Labels templates. Unit is announced in OpenMetrics exposition
+//in case the metric name ends with it (eg. "seconds" for "node_cpu_seconds_total").
+type CollectdMetricMapping struct {
+	Plugin         string            `json:"Plugin"`
+	PluginInstance string            `json:"PluginInstance"`
+	Type           string            `json:"Type"`
+	TypeInstance   string            `json:"TypeInstance"`
+	Name           string            `json:"Name"`
+	Help           string            `json:"Help"`
+	Unit           string            `json:"Unit"`
+	Labels         map[string]string `json:"Labels"`
+}
+
+//CompileMappingPattern compiles pattern of collectd mapping as anchored regular expression.
+//Empty pattern results in nil.
+func CompileMappingPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(fmt.Sprintf("^(?:%s)$", pattern))
+}
+
 //MetricConfiguration ...
 type MetricConfiguration struct {
-	Debug            bool             `json:"Debug"`
-	AMQP1MetricURL   string           `json:"AMQP1MetricURL"`
-	AMQP1Connections []AMQPConnection `json:"AMQP1Connections"`
-	CPUStats         bool             `json:"CPUStats"`
-	Exporterhost     string           `json:"Exporterhost"`
-	Exporterport     int              `json:"Exporterport"`
-	Prefetch         int              `json:"Prefetch"`
-	DataCount        int              `json:"DataCount"` //-1 for ever which is default //TODO(mmagr): config implementation does not have a way to for default value, implement one?
-	UseTimeStamp     bool             `json:"UseTimeStamp"`
-	UniqueName       string           `json:"UniqueName"`
-	ServiceType      string           `json:"ServiceType"`
-	IgnoreString     string           `json:"-"` //TODO(mmagr): ?
+	Debug                     bool                    `json:"Debug"`
+	AMQP1MetricURL            string                  `json:"AMQP1MetricURL"`
+	AMQP1Connections          []AMQPConnection        `json:"AMQP1Connections"`
+	Kafka                     KafkaConfig             `json:"Kafka"` //client settings of connections with kafka:// scheme
+	MQTT                      MQTTConfig              `json:"MQTT"`  //client settings of connections with mqtt:// scheme
+	CPUStats                  bool                    `json:"CPUStats"`
+	Exporterhost              string                  `json:"Exporterhost"`
+	Exporterport              int                     `json:"Exporterport"`
+	Prefetch                  int                     `json:"Prefetch"`
+	DataCount                 int                     `json:"DataCount"` //-1 for ever which is default //TODO(mmagr): config implementation does not have a way to for default value, implement one?
+	UseTimeStamp              bool                    `json:"UseTimeStamp"`
+	UniqueName                string                  `json:"UniqueName"`
+	ServiceType               string                  `json:"ServiceType"`
+	IgnoreString              string                  `json:"-"` //TODO(mmagr): ?
+	CollectdMappings          []CollectdMetricMapping `json:"CollectdMappings"`
+	CeilometerMetadataLabels  map[string]string       `json:"CeilometerMetadataLabels"` //label name -> payload path, eg. "resource_metadata.display_name"
+	CeilometerIntervals       map[string]float64      `json:"CeilometerIntervals"`      //meter name or glob pattern -> polling interval in seconds
+	CeilometerDefaultInterval float64                 `json:"CeilometerDefaultInterval"`
+	ExportCounterRates        bool                    `json:"ExportCounterRates"`    //expose per-second rate gauges of collectd counter and derive values
+	CacheSnapshotFile         string                  `json:"CacheSnapshotFile"`     //path to snapshot of metrics cache restored on start, snapshots are disabled if empty
+	CacheSnapshotInterval     int                     `json:"CacheSnapshotInterval"` //interval of cache snapshots in seconds
+	ShutdownTimeout           int                     `json:"ShutdownTimeout"`       //time in seconds given to processing of already received metrics and to active scrapes on shutdown
+	Readiness                 ReadinessConfig         `json:"Readiness"`
+	WebConfigFile             string                  `json:"WebConfigFile"`  //path to web configuration file (TLS and basic auth) of metrics exporter
+	DisablePprof              bool                    `json:"DisablePprof"`   //disables /debug/pprof endpoints of metrics exporter
+	PprofUsers                map[string]string       `json:"PprofUsers"`     //user name -> bcrypt hash of password of users allowed to access /debug/pprof endpoints
+	AdminToken                string                  `json:"AdminToken"`     //bearer token of admin API requests, admin API is disabled if empty
+	AdminTokenFile            string                  `json:"AdminTokenFile"` //path to file containing AdminToken
+	LogFormat                 string                  `json:"LogFormat"`      //"logfmt" (default) or "json"
+	LogLevel                  string                  `json:"LogLevel"`       //"debug", "info", "warn" or "error", defaults to "debug" if Debug is enabled and to "info" otherwise
+	LogLevels                 map[string]string       `json:"LogLevels"`      //component (eg. "amqp10") -> level overriding LogLevel
+}
+
+/******************** CombinedConfiguration implementation *******************/
+
+//CombinedConfiguration holds configuration of both metrics and events pipelines running in one process
+type CombinedConfiguration struct {
+	Metrics MetricConfiguration `json:"Metrics"`
+	Events  EventConfiguration  `json:"Events"`
 }
 
 /*****************************************************************************/
 
-//LoadConfiguration loads and unmarshals configuration file by given path and type
-func LoadConfiguration(path string, confType string) (interface{}, error) {
-	file, err := ioutil.ReadFile(path)
-	if err != nil {
-		log.Fatal("Config File Missing.", err)
+//LoadMetricConfiguration loads, parses and validates metrics configuration file in JSON or YAML format.
+//Options are overridden from environment as described in OverridesUsage.
+func LoadMetricConfiguration(path string) (*MetricConfiguration, error) {
+	config := new(MetricConfiguration)
+	if err := loadFile(path, config); err != nil {
+		return nil, err
 	}
+	errs := &ValidationError{Path: path}
+	applyOverrides(config, errs)
+	if len(errs.Problems) > 0 {
+		return nil, errs
+	}
+	if err := config.Validate(path); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
 
-	var config interface{}
-	switch confType {
-	case "metric":
-		config = new(MetricConfiguration)
-	case "event":
-		config = new(EventConfiguration)
+//LoadEventConfiguration loads, parses and validates events configuration file in JSON or YAML format.
+//Options are overridden from environment as described in OverridesUsage.
+func LoadEventConfiguration(path string) (*EventConfiguration, error) {
+	config := new(EventConfiguration)
+	if err := loadFile(path, config); err != nil {
+		return nil, err
+	}
+	errs := &ValidationError{Path: path}
+	applyOverrides(config, errs)
+	if len(errs.Problems) > 0 {
+		return nil, errs
 	}
-	err = json.Unmarshal(file, &config)
+	if err := config.Validate(path); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
 
-	var connections []AMQPConnection
+//LoadCombinedConfiguration loads, parses and validates combined configuration file in JSON or YAML format.
+//Options are overridden from environment as described in OverridesUsage.
+func LoadCombinedConfiguration(path string) (*CombinedConfiguration, error) {
+	config := new(CombinedConfiguration)
+	if err := loadFile(path, config); err != nil {
+		return nil, err
+	}
+	errs := &ValidationError{Path: path}
+	applyOverrides(config, errs)
+	if len(errs.Problems) > 0 {
+		return nil, errs
+	}
+	if err := config.Validate(path); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+//LoadConfiguration loads and unmarshals configuration file by given path and type ("metric", "event" or "combined").
+//
+//Deprecated: use LoadMetricConfiguration or LoadEventConfiguration instead.
+func LoadConfiguration(path string, confType string) (interface{}, error) {
 	switch confType {
 	case "metric":
-		connections = config.(*MetricConfiguration).AMQP1Connections
+		return LoadMetricConfiguration(path)
 	case "event":
-		connections = config.(*EventConfiguration).AMQP1Connections
-	}
-	for index, conn := range connections {
-		var dts *DataSource
-		switch confType {
-		case "metric":
-			dts = &config.(*MetricConfiguration).AMQP1Connections[index].DataSourceID
-		case "event":
-			dts = &config.(*EventConfiguration).AMQP1Connections[index].DataSourceID
-		}
-		if ok := dts.SetFromString(conn.DataSource); !ok {
-			err = fmt.Errorf("invalid AMQP connection data source '%s'", conn.DataSource)
-		}
+		return LoadEventConfiguration(path)
+	case "combined":
+		return LoadCombinedConfiguration(path)
 	}
-	return config, err
+	return nil, fmt.Errorf("unknown configuration type '%s'", confType)
 }
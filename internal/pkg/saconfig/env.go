@@ -0,0 +1,278 @@
+package saconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/logging"
+)
+
+//EnvPrefix is prefix of environment variables overriding configuration options
+const EnvPrefix = "SG_"
+
+//fileSuffix is suffix of options and environment variables pointing to file containing the option value
+const fileSuffix = "File"
+
+//OverridesUsage describes precedence of configuration sources
+const OverridesUsage = `Configuration option values are taken from following sources, later ones take precedence:
+  1. configuration file given by -config (JSON, or YAML in case of .yaml/.yml extension)
+  2. <Option>File options of configuration file containing path to file with the value
+     of <Option>, eg. ElasticPassFile for ElasticPass; trailing new lines are removed
+  3. SG_<OPTION> environment variables, eg. SG_ELASTIC_PASS for ElasticPass; list and
+     object options are given in JSON, eg. SG_AMQP1_CONNECTIONS='[{"URL": "...", "DataSource": "collectd"}]'
+     and nested options are joined by underscore, eg. SG_API_API_ENDPOINT_URL for API.APIEndpointURL
+  4. SG_<OPTION>_FILE environment variables containing path to file with the value
+     of string option, eg. SG_ELASTIC_PASS_FILE=/run/secrets/elastic-pass
+Setting both SG_<OPTION> and SG_<OPTION>_FILE is an error.`
+
+//envName converts configuration option name to environment variable name, eg. "AMQP1MetricURL"
+//is converted to "AMQP1_METRIC_URL"
+func envName(option string) string {
+	runes := []rune(option)
+	var name strings.Builder
+	for index, r := range runes {
+		if index > 0 && unicode.IsUpper(r) {
+			prev := runes[index-1]
+			nextLower := index+1 < len(runes) && unicode.IsLower(runes[index+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				name.WriteRune('_')
+			}
+		}
+		name.WriteRune(unicode.ToUpper(r))
+	}
+	return name.String()
+}
+
+//optionEnvName returns environment variable name of the option stored in given field without prefix,
+//env tag overrides name converted from option name, eg. `env:"QOS"` for option QoS
+func optionEnvName(field reflect.StructField) string {
+	if tag := field.Tag.Get("env"); tag != "" {
+		return tag
+	}
+	return envName(optionName(field))
+}
+
+//optionName returns name of the configuration option stored in given field
+func optionName(field reflect.StructField) string {
+	if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag != "" {
+		return tag
+	}
+	return field.Name
+}
+
+//configOption describes single configuration option which can be overridden from environment
+type configOption struct {
+	path  string // path of the option, eg. "API.APIEndpointURL"
+	env   string // name of environment variable, eg. "SG_API_API_ENDPOINT_URL"
+	value reflect.Value
+}
+
+//configOptions returns all options of given configuration structure. Nested structures are flattened.
+func configOptions(config reflect.Value, path string, env string) []configOption {
+	options := []configOption{}
+	for index := 0; index < config.NumField(); index++ {
+		field := config.Type().Field(index)
+		if field.PkgPath != "" || field.Tag.Get("json") == "-" {
+			continue
+		}
+		name := optionName(field)
+		option := configOption{path: joinPath(path, name), env: env + optionEnvName(field), value: config.Field(index)}
+		if field.Type.Kind() == reflect.Struct {
+			options = append(options, configOptions(option.value, option.path, option.env+"_")...)
+		} else {
+			options = append(options, option)
+		}
+	}
+	return options
+}
+
+//setOptionValue sets given string representation of value to the option
+func setOptionValue(option reflect.Value, value string) error {
+	switch option.Kind() {
+	case reflect.String:
+		option.SetString(value)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("expected boolean, got '%s'", value)
+		}
+		option.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("expected integer, got '%s'", value)
+		}
+		option.SetInt(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("expected number, got '%s'", value)
+		}
+		option.SetFloat(parsed)
+	default:
+		target := reflect.New(option.Type())
+		if err := json.Unmarshal([]byte(value), target.Interface()); err != nil {
+			return fmt.Errorf("expected JSON value: %s", err)
+		}
+		option.Set(target.Elem())
+	}
+	return nil
+}
+
+//readSecretFile returns content of given file without trailing new lines
+func readSecretFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+//applyOverrides loads values of options from files given by <Option>File options and then overrides
+//options of given configuration by SG_ environment variables. <Option>File options of list items,
+//eg. Webhooks[0].SecretFile, are loaded at last. All problems are reported in errs.
+func applyOverrides(config interface{}, errs *ValidationError) {
+	options := configOptions(reflect.ValueOf(config).Elem(), "", EnvPrefix)
+	byPath := make(map[string]configOption, len(options))
+	for _, option := range options {
+		byPath[option.path] = option
+	}
+	isFileOption := func(option configOption) bool {
+		_, ok := byPath[strings.TrimSuffix(option.path, fileSuffix)]
+		return ok && strings.HasSuffix(option.path, fileSuffix)
+	}
+
+	for _, option := range options {
+		if !isFileOption(option) || option.value.String() == "" {
+			continue
+		}
+		if content, err := readSecretFile(option.value.String()); err != nil {
+			errs.addProblem(option.path, "%s", err)
+		} else {
+			byPath[strings.TrimSuffix(option.path, fileSuffix)].value.SetString(content)
+		}
+	}
+
+	for _, option := range options {
+		if isFileOption(option) {
+			// SG_<OPTION>_FILE is handled together with <Option> below
+			continue
+		}
+		value, isSet := os.LookupEnv(option.env)
+		if isSet {
+			if err := setOptionValue(option.value, value); err != nil {
+				errs.addProblem(option.env, "%s", err)
+			}
+		}
+		path, ok := os.LookupEnv(option.env + "_FILE")
+		if !ok || option.value.Kind() != reflect.String {
+			continue
+		}
+		if isSet {
+			errs.addProblem(option.env+"_FILE", "cannot be used together with %s", option.env)
+		} else if content, err := readSecretFile(path); err != nil {
+			errs.addProblem(option.env+"_FILE", "%s", err)
+		} else {
+			option.value.SetString(content)
+			if fileOption, ok := byPath[option.path+fileSuffix]; ok {
+				fileOption.value.SetString(path)
+			}
+		}
+	}
+
+	for _, option := range options {
+		if option.value.Kind() == reflect.Slice {
+			loadItemFileOptions(option.value, option.path, errs)
+		}
+	}
+}
+
+//loadItemFileOptions loads values of options of given list items from files given by <Option>File options
+func loadItemFileOptions(list reflect.Value, path string, errs *ValidationError) {
+	for index := 0; index < list.Len(); index++ {
+		item := list.Index(index)
+		if item.Kind() != reflect.Struct {
+			return
+		}
+		for fieldIndex := 0; fieldIndex < item.NumField(); fieldIndex++ {
+			field := item.Type().Field(fieldIndex)
+			fileName := item.Field(fieldIndex)
+			if field.PkgPath != "" || !strings.HasSuffix(field.Name, fileSuffix) || fileName.Kind() != reflect.String || fileName.String() == "" {
+				continue
+			}
+			target := item.FieldByName(strings.TrimSuffix(field.Name, fileSuffix))
+			if !target.IsValid() || target.Kind() != reflect.String {
+				continue
+			}
+			if content, err := readSecretFile(fileName.String()); err != nil {
+				errs.addProblem(fmt.Sprintf("%s[%d].%s", path, index, optionName(field)), "%s", err)
+			} else {
+				target.SetString(content)
+			}
+		}
+	}
+}
+
+//PrintEnvironmentUsage prints precedence of configuration sources and list of environment
+//variables which can be used for overriding options of given configuration structure
+func PrintEnvironmentUsage(out io.Writer, config interface{}) {
+	fmt.Fprintln(out, OverridesUsage)
+	fmt.Fprintln(out, "Environment variables:")
+	for _, option := range configOptions(reflect.ValueOf(config).Elem(), "", EnvPrefix) {
+		fmt.Fprintf(out, "  %-36s %s\n", option.env, option.path)
+	}
+}
+
+//Redacted returns copy of given configuration structure with blank secret options, ie. options which
+//can be loaded from <Option>File, and their file options, so that the configuration can be logged.
+//Passwords of URL options are redacted too.
+func Redacted(config interface{}) interface{} {
+	value := reflect.ValueOf(config)
+	if value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+	copied := reflect.New(value.Type())
+	if content, err := json.Marshal(value.Interface()); err == nil {
+		json.Unmarshal(content, copied.Interface())
+	}
+	redact(copied.Elem())
+	return copied.Elem().Interface()
+}
+
+//redact blanks secret options of given configuration value and its nested structures
+func redact(value reflect.Value) {
+	switch value.Kind() {
+	case reflect.Struct:
+		for index := 0; index < value.NumField(); index++ {
+			field := value.Type().Field(index)
+			if field.PkgPath != "" {
+				continue
+			}
+			if fileField, ok := value.Type().FieldByName(field.Name + fileSuffix); ok && field.Type.Kind() == reflect.String {
+				value.Field(index).SetString("")
+				value.FieldByIndex(fileField.Index).SetString("")
+				continue
+			}
+			if field.Type.Kind() == reflect.String && strings.HasSuffix(field.Name, "URL") {
+				value.Field(index).SetString(logging.RedactURL(value.Field(index).String()))
+				continue
+			}
+			redact(value.Field(index))
+		}
+	case reflect.Slice, reflect.Array:
+		for index := 0; index < value.Len(); index++ {
+			redact(value.Index(index))
+		}
+	case reflect.Ptr:
+		if !value.IsNil() {
+			redact(value.Elem())
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package saconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//ValidationError holds all problems found in configuration file
+type ValidationError struct {
+	Path     string
+	Problems []string
+}
+
+//Error returns all problems, one per line
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid configuration file %s:\n  - %s", e.Path, strings.Join(e.Problems, "\n  - "))
+}
+
+//addProblem records problem found on given path of configuration
+func (e *ValidationError) addProblem(path string, format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf("%s: %s", path, fmt.Sprintf(format, args...)))
+}
+
+//isYAML returns true if given file should be parsed as YAML according to its extension
+func isYAML(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+//parseFile reads configuration file and returns its content as JSON together with generic
+//representation of the content used for checking of the structure
+func parseFile(path string) ([]byte, interface{}, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var content interface{}
+	if isYAML(path) {
+		if err := yaml.Unmarshal(file, &content); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse YAML file %s: %s", path, err)
+		}
+		if file, err = json.Marshal(content); err != nil {
+			return nil, nil, fmt.Errorf("failed to convert YAML file %s: %s", path, err)
+		}
+	} else if err := json.Unmarshal(file, &content); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse JSON file %s: %s", path, err)
+	}
+	return file, content, nil
+}
+
+//joinPath returns path of the key in given object path
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+//structField returns field of given struct type which would be used by encoding/json for given key
+func structField(typ reflect.Type, key string) (reflect.StructField, bool) {
+	var folded *reflect.StructField
+	for index := 0; index < typ.NumField(); index++ {
+		field := typ.Field(index)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		if name == key {
+			return field, true
+		}
+		if folded == nil && strings.EqualFold(name, key) {
+			folded = &field
+		}
+	}
+	if folded != nil {
+		return *folded, true
+	}
+	return reflect.StructField{}, false
+}
+
+//checkStructure walks generic content of configuration file and reports unknown keys and values
+//of types not corresponding to the configuration structure
+func checkStructure(content interface{}, typ reflect.Type, path string, errs *ValidationError) {
+	if content == nil {
+		return
+	}
+	kindName := map[reflect.Kind]string{reflect.Struct: "object", reflect.Map: "object", reflect.Slice: "list",
+		reflect.String: "string", reflect.Bool: "boolean", reflect.Float64: "number", reflect.Float32: "number"}
+	expected, ok := kindName[typ.Kind()]
+	if !ok {
+		expected = "integer"
+	}
+
+	switch typ.Kind() {
+	case reflect.Struct, reflect.Map:
+		object, ok := content.(map[string]interface{})
+		if !ok {
+			if path == "" {
+				path = "(root)"
+			}
+			errs.addProblem(path, "expected %s", expected)
+			return
+		}
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if typ.Kind() == reflect.Map {
+				checkStructure(object[key], typ.Elem(), joinPath(path, key), errs)
+			} else if field, ok := structField(typ, key); ok {
+				checkStructure(object[key], field.Type, joinPath(path, key), errs)
+			} else {
+				errs.addProblem(joinPath(path, key), "unknown configuration option")
+			}
+		}
+	case reflect.Slice:
+		list, ok := content.([]interface{})
+		if !ok {
+			errs.addProblem(path, "expected %s", expected)
+			return
+		}
+		for index, item := range list {
+			checkStructure(item, typ.Elem(), fmt.Sprintf("%s[%d]", path, index), errs)
+		}
+	case reflect.String:
+		if _, ok := content.(string); !ok {
+			errs.addProblem(path, "expected %s", expected)
+		}
+	case reflect.Bool:
+		if _, ok := content.(bool); !ok {
+			errs.addProblem(path, "expected %s", expected)
+		}
+	case reflect.Float32, reflect.Float64:
+		switch content.(type) {
+		case float64, int:
+		default:
+			errs.addProblem(path, "expected %s", expected)
+		}
+	default:
+		switch value := content.(type) {
+		case int:
+		case float64:
+			if value != math.Trunc(value) {
+				errs.addProblem(path, "expected %s", expected)
+			}
+		default:
+			errs.addProblem(path, "expected %s", expected)
+		}
+	}
+}
+
+//loadFile parses configuration file in JSON or YAML format to given configuration structure.
+//All unknown options and options of invalid type are reported together in ValidationError.
+func loadFile(path string, config interface{}) error {
+	data, content, err := parseFile(path)
+	if err != nil {
+		return err
+	}
+	errs := &ValidationError{Path: path}
+	checkStructure(content, reflect.TypeOf(config).Elem(), "", errs)
+	if len(errs.Problems) > 0 {
+		return errs
+	}
+	return json.Unmarshal(data, config)
+}
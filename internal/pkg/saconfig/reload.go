@@ -0,0 +1,58 @@
+package saconfig
+
+import (
+	"reflect"
+)
+
+//reloadableOptions contains options of each configuration type which can be changed without restart
+var reloadableOptions = map[reflect.Type]map[string]bool{
+	reflect.TypeOf(MetricConfiguration{}): {
+		"Debug":                     true,
+		"AMQP1MetricURL":            true,
+		"AMQP1Connections":          true,
+		"CollectdMappings":          true,
+		"CeilometerMetadataLabels":  true,
+		"CeilometerIntervals":       true,
+		"CeilometerDefaultInterval": true,
+		"Readiness":                 true,
+		"LogFormat":                 true,
+		"LogLevel":                  true,
+		"LogLevels":                 true,
+	},
+	reflect.TypeOf(EventConfiguration{}): {
+		"Debug":               true,
+		"AMQP1EventURL":       true,
+		"AMQP1Connections":    true,
+		"AlertManagerURL":     true,
+		"AlertManagerEnabled": true,
+		"HandlerPlugin":       true,
+		"Readiness":           true,
+		"LogFormat":           true,
+		"LogLevel":            true,
+		"LogLevels":           true,
+	},
+}
+
+//ChangedOptions compares running configuration with reloaded one and returns names of changed options
+//which can be applied without restart and names of changed options which require restart.
+//Both configurations have to be pointers to the same configuration type.
+func ChangedOptions(running interface{}, reloaded interface{}) ([]string, []string) {
+	var reloadable, restart []string
+	old, updated := reflect.ValueOf(running).Elem(), reflect.ValueOf(reloaded).Elem()
+	for index := 0; index < old.NumField(); index++ {
+		field := old.Type().Field(index)
+		if field.PkgPath != "" || field.Tag.Get("json") == "-" {
+			continue
+		}
+		if reflect.DeepEqual(old.Field(index).Interface(), updated.Field(index).Interface()) {
+			continue
+		}
+		name := optionName(field)
+		if reloadableOptions[old.Type()][name] {
+			reloadable = append(reloadable, name)
+		} else {
+			restart = append(restart, name)
+		}
+	}
+	return reloadable, restart
+}
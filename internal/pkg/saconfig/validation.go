@@ -0,0 +1,504 @@
+package saconfig
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/logging"
+)
+
+//validateConnections checks AMQP connections and sets their DataSourceID
+func validateConnections(connections []AMQPConnection, path string, errs *ValidationError) {
+	for index := range connections {
+		connPath := fmt.Sprintf("%s[%d]", path, index)
+		if connections[index].URL == "" {
+			errs.addProblem(connPath+".URL", "value is required")
+		}
+		validateKafkaURL(connections[index].URL, connPath+".URL", errs)
+		validateMQTTURL(connections[index].URL, connPath+".URL", errs)
+		if ok := connections[index].DataSourceID.SetFromString(connections[index].DataSource); !ok {
+			errs.addProblem(connPath+".DataSource", "invalid data source '%s'", connections[index].DataSource)
+		}
+	}
+}
+
+//validateKafkaURL checks brokers and topic of given URL in case it has kafka:// scheme
+func validateKafkaURL(value string, path string, errs *ValidationError) {
+	if !IsKafkaURL(value) {
+		return
+	}
+	if _, _, err := ParseKafkaURL(value); err != nil {
+		errs.addProblem(path, "%s", err)
+	}
+}
+
+//validateKafka checks client settings of Kafka connections
+func validateKafka(config KafkaConfig, path string, errs *ValidationError) {
+	if config.StartOffset != "" && config.StartOffset != "latest" && config.StartOffset != "earliest" {
+		errs.addProblem(joinPath(path, "StartOffset"), "invalid offset '%s', expected latest or earliest", config.StartOffset)
+	}
+	if config.CommitInterval < 0 {
+		errs.addProblem(joinPath(path, "CommitInterval"), "value cannot be negative")
+	}
+	if (config.TLSClientCert == "") != (config.TLSClientKey == "") {
+		errs.addProblem(joinPath(path, "TlsClientCert"), "TlsClientCert and TlsClientKey have to be set together")
+	}
+	switch config.SASLMechanism {
+	case "":
+	case KafkaSASLPlain, KafkaSASLScramSHA256, KafkaSASLScramSHA512:
+		if config.SASLUser == "" || config.SASLPass == "" {
+			errs.addProblem(joinPath(path, "SASLUser"), "SASLUser and SASLPass are required for SASL authentication")
+		}
+	default:
+		errs.addProblem(joinPath(path, "SASLMechanism"), "invalid mechanism '%s', expected one of %s, %s or %s",
+			config.SASLMechanism, KafkaSASLPlain, KafkaSASLScramSHA256, KafkaSASLScramSHA512)
+	}
+}
+
+//validateMQTTURL checks broker and topic filter of given URL in case it has mqtt:// scheme
+func validateMQTTURL(value string, path string, errs *ValidationError) {
+	if !IsMQTTURL(value) {
+		return
+	}
+	if _, _, err := ParseMQTTURL(value); err != nil {
+		errs.addProblem(path, "%s", err)
+	}
+}
+
+//validateMQTT checks client settings of MQTT connections
+func validateMQTT(config MQTTConfig, path string, errs *ValidationError) {
+	validateRange(config.QoS, 0, 2, joinPath(path, "QoS"), errs)
+	if config.KeepAlive < 0 {
+		errs.addProblem(joinPath(path, "KeepAlive"), "value cannot be negative")
+	}
+	if config.Password != "" && config.Username == "" {
+		errs.addProblem(joinPath(path, "Username"), "value is required when Password is set")
+	}
+	if (config.TLSClientCert == "") != (config.TLSClientKey == "") {
+		errs.addProblem(joinPath(path, "TlsClientCert"), "TlsClientCert and TlsClientKey have to be set together")
+	}
+}
+
+//validatePublishURL checks URL messages are published to, MQTT is supported only for connections
+func validatePublishURL(value string, path string, errs *ValidationError) {
+	validateKafkaURL(value, path, errs)
+	if IsMQTTURL(value) {
+		errs.addProblem(path, "%s:// scheme is supported only for connections", MQTTScheme)
+	}
+}
+
+//validateHTTPURL checks that given value is absolute HTTP(S) URL
+func validateHTTPURL(value string, path string, errs *ValidationError) {
+	parsed, err := url.Parse(value)
+	if err != nil {
+		errs.addProblem(path, "invalid URL: %s", err)
+	} else if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		errs.addProblem(path, "expected absolute http(s) URL, got '%s'", value)
+	}
+}
+
+//validateRange checks that given integer value is in given range
+func validateRange(value int, min int, max int, path string, errs *ValidationError) {
+	if value < min || value > max {
+		errs.addProblem(path, "value %d is out of range %d-%d", value, min, max)
+	}
+}
+
+//validateReadiness checks readiness thresholds
+func validateReadiness(readiness ReadinessConfig, path string, errs *ValidationError) {
+	thresholds := []struct {
+		name  string
+		value int
+	}{
+		{"MaxMessageAge", readiness.MaxMessageAge},
+		{"MaxElasticFailures", readiness.MaxElasticFailures},
+		{"MaxAlertManagerFailures", readiness.MaxAlertManagerFailures},
+	}
+	for _, threshold := range thresholds {
+		if threshold.value < 0 {
+			errs.addProblem(joinPath(path, threshold.name), "value cannot be negative")
+		}
+	}
+}
+
+//validateAPI checks authentication, TLS and limits of events API
+func validateAPI(config EventAPIConfig, path string, errs *ValidationError) {
+	methods := map[string]bool{}
+	checkMethod := func(method string, methodPath string) {
+		switch method {
+		case APIAuthNone:
+		case APIAuthBearer, APIAuthBasic:
+			methods[method] = true
+		default:
+			errs.addProblem(methodPath, "invalid authentication '%s', expected one of none, bearer or basic", method)
+		}
+	}
+	if config.Auth != "" {
+		checkMethod(config.Auth, joinPath(path, "Auth"))
+	}
+	routes := make([]string, 0, len(config.RouteAuth))
+	for route := range config.RouteAuth {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		routePath := joinPath(joinPath(path, "RouteAuth"), route)
+		if !strings.HasPrefix(route, "/") {
+			errs.addProblem(routePath, "route has to start with '/'")
+		}
+		checkMethod(config.RouteAuth[route], routePath)
+	}
+	if methods[APIAuthBearer] && config.AuthToken == "" {
+		errs.addProblem(joinPath(path, "AuthToken"), "value is required for bearer authentication")
+	}
+	if methods[APIAuthBasic] && (config.AuthUser == "" || config.AuthPass == "") {
+		errs.addProblem(joinPath(path, "AuthUser"), "AuthUser and AuthPass are required for basic authentication")
+	}
+	if (config.TLSCert == "") != (config.TLSKey == "") {
+		errs.addProblem(joinPath(path, "TlsCert"), "TlsCert and TlsKey have to be set together")
+	}
+	if config.TLSClientCA != "" && config.TLSCert == "" {
+		errs.addProblem(joinPath(path, "TlsClientCa"), "value requires TlsCert and TlsKey")
+	}
+	if config.MaxBodySize < 0 {
+		errs.addProblem(joinPath(path, "MaxBodySize"), "value cannot be negative")
+	}
+	if config.RateLimit < 0 {
+		errs.addProblem(joinPath(path, "RateLimit"), "value cannot be negative")
+	}
+	if config.RateBurst < 0 {
+		errs.addProblem(joinPath(path, "RateBurst"), "value cannot be negative")
+	}
+	if config.PublishTimeout < 0 {
+		errs.addProblem(joinPath(path, "PublishTimeout"), "value cannot be negative")
+	}
+	validatePublishURL(config.AMQP1PublishURL, joinPath(path, "AMQP1PublishURL"), errs)
+	for index, target := range config.PublishTargets {
+		targetPath := fmt.Sprintf("%s[%d]", joinPath(path, "PublishTargets"), index)
+		if target.URL == "" {
+			errs.addProblem(targetPath+".URL", "value is required")
+		}
+		validatePublishURL(target.URL, targetPath+".URL", errs)
+		labels := make([]string, 0, len(target.MatchRE))
+		for label := range target.MatchRE {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			if _, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", target.MatchRE[label])); err != nil {
+				errs.addProblem(joinPath(targetPath+".MatchRE", label), "invalid regular expression: %s", err)
+			}
+		}
+	}
+}
+
+//WebhookSeverities contains severities of events which can be used in webhook filters
+var WebhookSeverities = []string{"critical", "warning", "info", "unknown"}
+
+//validateWebhooks checks webhook sinks
+func validateWebhooks(webhooks []WebhookConfig, path string, errs *ValidationError) {
+	for index, webhook := range webhooks {
+		webhookPath := fmt.Sprintf("%s[%d]", path, index)
+		if webhook.URL == "" {
+			errs.addProblem(webhookPath+".URL", "value is required")
+		} else {
+			validateHTTPURL(webhook.URL, webhookPath+".URL", errs)
+		}
+		options := []struct {
+			name  string
+			value int
+		}{
+			{"BatchSize", webhook.BatchSize},
+			{"BatchInterval", webhook.BatchInterval},
+			{"QueueSize", webhook.QueueSize},
+			{"Timeout", webhook.Timeout},
+			{"Retries", webhook.Retries},
+			{"RetryInterval", webhook.RetryInterval},
+		}
+		for _, option := range options {
+			if option.value < 0 {
+				errs.addProblem(webhookPath+"."+option.name, "value cannot be negative")
+			}
+		}
+		if _, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", webhook.IndexPattern)); err != nil {
+			errs.addProblem(webhookPath+".IndexPattern", "invalid regular expression: %s", err)
+		}
+		for _, severity := range webhook.Severities {
+			known := false
+			for _, name := range WebhookSeverities {
+				known = known || severity == name
+			}
+			if !known {
+				errs.addProblem(webhookPath+".Severities", "invalid severity '%s', expected one of %s", severity, strings.Join(WebhookSeverities, ", "))
+			}
+		}
+	}
+}
+
+//validateCollectdMappings checks that mappings have at least one valid pattern and valid label names
+func validateCollectdMappings(mappings []CollectdMetricMapping, path string, errs *ValidationError) {
+	for index, mapping := range mappings {
+		mappingPath := fmt.Sprintf("%s[%d]", path, index)
+		if mapping.Plugin == "" && mapping.PluginInstance == "" && mapping.Type == "" && mapping.TypeInstance == "" {
+			errs.addProblem(mappingPath, "at least one of Plugin, PluginInstance, Type or TypeInstance patterns is required")
+		}
+		patterns := []struct {
+			name    string
+			pattern string
+		}{
+			{"Plugin", mapping.Plugin},
+			{"PluginInstance", mapping.PluginInstance},
+			{"Type", mapping.Type},
+			{"TypeInstance", mapping.TypeInstance},
+		}
+		for _, item := range patterns {
+			if _, err := CompileMappingPattern(item.pattern); err != nil {
+				errs.addProblem(joinPath(mappingPath, item.name), "invalid pattern '%s': %s", item.pattern, err)
+			}
+		}
+		labels := make([]string, 0, len(mapping.Labels))
+		for label := range mapping.Labels {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			if !labelNameRe.MatchString(label) {
+				errs.addProblem(joinPath(mappingPath, "Labels"), "invalid label name '%s'", label)
+			}
+		}
+	}
+}
+
+//LokiLabels contains event fields which can be used as Loki stream labels
+var LokiLabels = []string{"host", "index", "severity"}
+
+//labelNameRe matches valid Prometheus and Loki label names
+var labelNameRe = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+//validateLoki checks configuration of Loki sink
+func validateLoki(config LokiConfig, path string, errs *ValidationError) {
+	if config.URL == "" {
+		return
+	}
+	validateHTTPURL(config.URL, joinPath(path, "URL"), errs)
+	if config.Format != "" && config.Format != "json" && config.Format != "protobuf" {
+		errs.addProblem(joinPath(path, "Format"), "invalid format '%s', expected json or protobuf", config.Format)
+	}
+	for _, label := range config.Labels {
+		known := false
+		for _, name := range LokiLabels {
+			known = known || label == name
+		}
+		if !known {
+			errs.addProblem(joinPath(path, "Labels"), "invalid label '%s', expected one of %s", label, strings.Join(LokiLabels, ", "))
+		}
+	}
+	labels := make([]string, 0, len(config.StaticLabels))
+	for label := range config.StaticLabels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		if !labelNameRe.MatchString(label) {
+			errs.addProblem(joinPath(joinPath(path, "StaticLabels"), label), "invalid label name")
+		}
+	}
+	if config.AuthUser != "" && config.AuthPass == "" {
+		errs.addProblem(joinPath(path, "AuthPass"), "value is required when AuthUser is set")
+	}
+	options := []struct {
+		name  string
+		value int
+	}{
+		{"BatchSize", config.BatchSize},
+		{"BatchInterval", config.BatchInterval},
+		{"QueueSize", config.QueueSize},
+		{"Timeout", config.Timeout},
+		{"Retries", config.Retries},
+		{"RetryInterval", config.RetryInterval},
+	}
+	for _, option := range options {
+		if option.value < 0 {
+			errs.addProblem(joinPath(path, option.name), "value cannot be negative")
+		}
+	}
+}
+
+//validateLogging checks logging format and levels
+func validateLogging(format string, level string, levels map[string]string, errs *ValidationError) {
+	var fmtID logging.Format
+	if format != "" && !fmtID.SetFromString(format) {
+		errs.addProblem("LogFormat", "invalid log format '%s'", format)
+	}
+	var lvlID logging.Level
+	if level != "" && !lvlID.SetFromString(level) {
+		errs.addProblem("LogLevel", "invalid log level '%s'", level)
+	}
+	components := make([]string, 0, len(levels))
+	for component := range levels {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+	for _, component := range components {
+		if !lvlID.SetFromString(levels[component]) {
+			errs.addProblem(joinPath("LogLevels", component), "invalid log level '%s'", levels[component])
+		}
+	}
+}
+
+//Validate checks values of metrics configuration loaded from given path and reports all problems at once
+func (config *MetricConfiguration) Validate(path string) error {
+	errs := &ValidationError{Path: path}
+	if config.AMQP1MetricURL == "" && len(config.AMQP1Connections) == 0 {
+		errs.addProblem("AMQP1MetricURL", "'AMQP1MetricURL' or 'AMQP1Connections' is required")
+	}
+	validateKafkaURL(config.AMQP1MetricURL, "AMQP1MetricURL", errs)
+	validateMQTTURL(config.AMQP1MetricURL, "AMQP1MetricURL", errs)
+	validateConnections(config.AMQP1Connections, "AMQP1Connections", errs)
+	validateKafka(config.Kafka, "Kafka", errs)
+	validateMQTT(config.MQTT, "MQTT", errs)
+	validateRange(config.Exporterport, 1, 65535, "Exporterport", errs)
+	if config.Prefetch < 0 {
+		errs.addProblem("Prefetch", "value cannot be negative")
+	}
+	if config.DataCount < -1 {
+		errs.addProblem("DataCount", "value cannot be lower than -1")
+	}
+	validateCollectdMappings(config.CollectdMappings, "CollectdMappings", errs)
+	meters := make([]string, 0, len(config.CeilometerIntervals))
+	for meter := range config.CeilometerIntervals {
+		meters = append(meters, meter)
+	}
+	sort.Strings(meters)
+	for _, meter := range meters {
+		if config.CeilometerIntervals[meter] <= 0 {
+			errs.addProblem(joinPath("CeilometerIntervals", meter), "interval has to be positive")
+		}
+	}
+	if config.CeilometerDefaultInterval < 0 {
+		errs.addProblem("CeilometerDefaultInterval", "value cannot be negative")
+	}
+	if config.CacheSnapshotInterval < 0 {
+		errs.addProblem("CacheSnapshotInterval", "value cannot be negative")
+	}
+	if config.ShutdownTimeout < 0 {
+		errs.addProblem("ShutdownTimeout", "value cannot be negative")
+	}
+	validateReadiness(config.Readiness, "Readiness", errs)
+	validateLogging(config.LogFormat, config.LogLevel, config.LogLevels, errs)
+	if config.WebConfigFile != "" {
+		if _, err := LoadWebConfig(config.WebConfigFile); err != nil {
+			if webErrs, ok := err.(*ValidationError); ok {
+				for _, problem := range webErrs.Problems {
+					errs.addProblem("WebConfigFile", "%s", problem)
+				}
+			} else {
+				errs.addProblem("WebConfigFile", "%s", err)
+			}
+		}
+	}
+	validateBasicAuthUsers(config.PprofUsers, "PprofUsers", errs)
+	if len(errs.Problems) > 0 {
+		return errs
+	}
+	return nil
+}
+
+//Validate checks values of events configuration loaded from given path and reports all problems at once
+func (config *EventConfiguration) Validate(path string) error {
+	errs := &ValidationError{Path: path}
+	if config.AMQP1EventURL == "" && len(config.AMQP1Connections) == 0 {
+		errs.addProblem("AMQP1EventURL", "'AMQP1EventURL' or 'AMQP1Connections' is required")
+	}
+	validateKafkaURL(config.AMQP1EventURL, "AMQP1EventURL", errs)
+	validateMQTTURL(config.AMQP1EventURL, "AMQP1EventURL", errs)
+	validateConnections(config.AMQP1Connections, "AMQP1Connections", errs)
+	validateKafka(config.Kafka, "Kafka", errs)
+	validateMQTT(config.MQTT, "MQTT", errs)
+	if config.ElasticHostURL == "" {
+		errs.addProblem("ElasticHostURL", "value is required")
+	} else {
+		validateHTTPURL(config.ElasticHostURL, "ElasticHostURL", errs)
+	}
+	if config.AlertManagerURL != "" {
+		validateHTTPURL(config.AlertManagerURL, "AlertManagerURL", errs)
+	}
+	if config.UseBasicAuth && config.ElasticUser == "" {
+		errs.addProblem("ElasticUser", "value is required when UseBasicAuth is enabled")
+	}
+	if config.UseTLS {
+		options := []struct {
+			name  string
+			value string
+		}{
+			{"TlsClientCert", config.TLSClientCert},
+			{"TlsClientKey", config.TLSClientKey},
+			{"TlsCaCert", config.TLSCaCert},
+		}
+		for _, option := range options {
+			if option.value == "" {
+				errs.addProblem(option.name, "value is required when UseTls is enabled")
+			}
+		}
+	}
+	if config.Prefetch < 0 {
+		errs.addProblem("Prefetch", "value cannot be negative")
+	}
+	if config.ShutdownTimeout < 0 {
+		errs.addProblem("ShutdownTimeout", "value cannot be negative")
+	}
+	validateReadiness(config.Readiness, "Readiness", errs)
+	validateLogging(config.LogFormat, config.LogLevel, config.LogLevels, errs)
+	validateAPI(config.API, "API", errs)
+	validateWebhooks(config.Webhooks, "Webhooks", errs)
+	validateLoki(config.Loki, "Loki", errs)
+	for index, handler := range config.HandlerPlugins {
+		handlerPath := fmt.Sprintf("HandlerPlugin[%d]", index)
+		if handler.Path == "" {
+			errs.addProblem(handlerPath+".Path", "value is required")
+		}
+		var source DataSource
+		if !source.SetFromString(handler.DataSource) {
+			errs.addProblem(handlerPath+".DataSource", "invalid data source '%s'", handler.DataSource)
+		}
+	}
+	if len(errs.Problems) > 0 {
+		return errs
+	}
+	return nil
+}
+
+//Validate checks values of combined configuration loaded from given path and reports all problems at once
+func (config *CombinedConfiguration) Validate(path string) error {
+	errs := &ValidationError{Path: path}
+	sections := []struct {
+		name string
+		err  error
+	}{
+		{"Metrics", config.Metrics.Validate(path)},
+		{"Events", config.Events.Validate(path)},
+	}
+	for _, section := range sections {
+		if sectionErrs, ok := section.err.(*ValidationError); ok {
+			for _, problem := range sectionErrs.Problems {
+				errs.Problems = append(errs.Problems, joinPath(section.name, problem))
+			}
+		}
+	}
+	if config.Events.API.APIEndpointURL != "" {
+		errs.addProblem("Events.API.APIEndpointURL", "HTTP server is shared in combined mode, Metrics.Exporterhost and Metrics.Exporterport are used instead")
+	}
+	if config.Events.API.TLSCert != "" || config.Events.API.TLSClientCA != "" {
+		errs.addProblem("Events.API.TlsCert", "HTTP server is shared in combined mode, TLS is configured by Metrics.WebConfigFile instead")
+	}
+	if config.Events.LogFormat != "" || config.Events.LogLevel != "" || len(config.Events.LogLevels) > 0 {
+		errs.addProblem("Events", "logging is shared in combined mode, Metrics.LogFormat, Metrics.LogLevel and Metrics.LogLevels are used instead")
+	}
+	if len(errs.Problems) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package saconfig
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+//TLSServerConfig holds TLS options of web configuration file
+type TLSServerConfig struct {
+	CertFile       string `yaml:"cert_file"`
+	KeyFile        string `yaml:"key_file"`
+	ClientCAFile   string `yaml:"client_ca_file"`
+	ClientAuthType string `yaml:"client_auth_type"` //defaults to RequireAndVerifyClientCert when client_ca_file is set
+	MinVersion     string `yaml:"min_version"`      //TLS10, TLS11, TLS12 (default) or TLS13
+	MaxVersion     string `yaml:"max_version"`
+}
+
+//WebConfig holds content of web configuration file in format of Prometheus exporter toolkit. Relative
+//paths are resolved against directory of the file.
+type WebConfig struct {
+	TLSServerConfig *TLSServerConfig  `yaml:"tls_server_config"`
+	BasicAuthUsers  map[string]string `yaml:"basic_auth_users"` //user name -> bcrypt hash of password
+}
+
+//TLSVersions contains TLS versions which can be used in web configuration file
+var TLSVersions = map[string]uint16{
+	"TLS10": 0x0301,
+	"TLS11": 0x0302,
+	"TLS12": 0x0303,
+	"TLS13": 0x0304,
+}
+
+//ClientAuthTypes contains client certificate policies which can be used in web configuration file
+var ClientAuthTypes = []string{"NoClientCert", "RequestClientCert", "RequireAnyClientCert", "VerifyClientCertIfGiven", "RequireAndVerifyClientCert"}
+
+//validateBasicAuthUsers checks that passwords of given users are bcrypt hashes
+func validateBasicAuthUsers(users map[string]string, path string, errs *ValidationError) {
+	names := make([]string, 0, len(users))
+	for name := range users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := bcrypt.Cost([]byte(users[name])); err != nil {
+			errs.addProblem(joinPath(path, name), "invalid bcrypt hash: %s", err)
+		}
+	}
+}
+
+//LoadWebConfig loads and validates web configuration file
+func LoadWebConfig(path string) (*WebConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &WebConfig{}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse web configuration file %s: %s", path, err)
+	}
+
+	errs := &ValidationError{Path: path}
+	if tlsConfig := config.TLSServerConfig; tlsConfig != nil {
+		for _, file := range []*string{&tlsConfig.CertFile, &tlsConfig.KeyFile, &tlsConfig.ClientCAFile} {
+			if *file != "" && !filepath.IsAbs(*file) {
+				*file = filepath.Join(filepath.Dir(path), *file)
+			}
+		}
+		if tlsConfig.CertFile == "" || tlsConfig.KeyFile == "" {
+			errs.addProblem("tls_server_config", "cert_file and key_file are required")
+		}
+		if tlsConfig.ClientAuthType == "" && tlsConfig.ClientCAFile != "" {
+			tlsConfig.ClientAuthType = "RequireAndVerifyClientCert"
+		}
+		known := false
+		for _, authType := range ClientAuthTypes {
+			known = known || tlsConfig.ClientAuthType == authType
+		}
+		if !known && tlsConfig.ClientAuthType != "" {
+			errs.addProblem("tls_server_config.client_auth_type", "invalid client auth type '%s'", tlsConfig.ClientAuthType)
+		}
+		if (tlsConfig.ClientAuthType == "VerifyClientCertIfGiven" || tlsConfig.ClientAuthType == "RequireAndVerifyClientCert") && tlsConfig.ClientCAFile == "" {
+			errs.addProblem("tls_server_config.client_ca_file", "value is required for client auth type %s", tlsConfig.ClientAuthType)
+		}
+		for _, version := range []struct{ name, value string }{{"min_version", tlsConfig.MinVersion}, {"max_version", tlsConfig.MaxVersion}} {
+			if _, ok := TLSVersions[version.value]; !ok && version.value != "" {
+				errs.addProblem(joinPath("tls_server_config", version.name), "invalid TLS version '%s'", version.value)
+			}
+		}
+	}
+	validateBasicAuthUsers(config.BasicAuthUsers, "basic_auth_users", errs)
+	if len(errs.Problems) > 0 {
+		return nil, errs
+	}
+	return config, nil
+}
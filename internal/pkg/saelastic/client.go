@@ -8,16 +8,16 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/infrawatch/smart-gateway/internal/pkg/logging"
 	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
 	"github.com/olivere/elastic"
 )
 
-var debuges = func(format string, data ...interface{}) {} // Default no debugging output
+var logger = logging.New("saelastic")
 
 //ElasticClient  ....
 type ElasticClient struct {
@@ -37,13 +37,13 @@ func (ec *ElasticClient) InitAllMappings() {
 func createTLSClient(serverName string, certFile string, keyFile string, caFile string) (*http.Client, error) {
 	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal("Failed to load client certificate", "cert", certFile, "key", keyFile, "error", err)
 		return &http.Client{}, err
 	}
 
 	ca, err := ioutil.ReadFile(caFile)
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal("Failed to load CA certificate", "ca", caFile, "error", err)
 		return &http.Client{}, err
 	}
 	certPool := x509.NewCertPool()
@@ -58,7 +58,7 @@ func createTLSClient(serverName string, certFile string, keyFile string, caFile
 	} else {
 		tlsConfig.ServerName = serverName
 	}
-	debuges("InsecureSkipVerify is set to %t", tlsConfig.InsecureSkipVerify)
+	logger.Debug("TLS client configured", "insecure_skip_verify", tlsConfig.InsecureSkipVerify)
 
 	return &http.Client{
 		Transport: &http.Transport{TLSClientConfig: tlsConfig},
@@ -67,10 +67,6 @@ func createTLSClient(serverName string, certFile string, keyFile string, caFile
 
 //CreateClient   ....
 func CreateClient(config saconfig.EventConfiguration) (*ElasticClient, error) {
-	if config.Debug {
-		debuges = func(format string, data ...interface{}) { log.Printf(format, data...) }
-	}
-
 	var elasticClient *ElasticClient
 	elasticOpts := []elastic.ClientOptionFunc{elastic.SetHealthcheckInterval(5 * time.Second), elastic.SetURL(config.ElasticHostURL)}
 	// add transport with TLS enabled in case it is required
@@ -94,7 +90,7 @@ func CreateClient(config saconfig.EventConfiguration) (*ElasticClient, error) {
 	if config.ResetIndex {
 		elasticClient.InitAllMappings()
 	}
-	debuges("Debug:ElasticSearch client created.")
+	logger.Debug("Elasticsearch client created", "url", logging.RedactURL(config.ElasticHostURL))
 	return elasticClient, nil
 }
 
@@ -114,7 +110,7 @@ func (ec *ElasticClient) CreateIndex(index string, mapping string) {
 	exists, err := ec.client.IndexExists(string(index)).Do(ec.ctx)
 	if err != nil {
 		// Handle error nothing to do index exists
-		debuges("Debug:ElasticSearch indexExists returned an error: %s", err)
+		logger.Debug("Failed to check index existence", "index", index, "error", err)
 	}
 	if !exists {
 		// Index does not exist yet.
@@ -126,7 +122,7 @@ func (ec *ElasticClient) CreateIndex(index string, mapping string) {
 		}
 		if !createIndex.Acknowledged {
 			// Not acknowledged
-			log.Println("Index Not acknowledged")
+			logger.Warn("Index creation not acknowledged", "index", index)
 		}
 	}
 
@@ -135,7 +131,6 @@ func (ec *ElasticClient) CreateIndex(index string, mapping string) {
 //genUUIDv4  ...
 func genUUIDv4() string {
 	id, _ := uuid.NewV4()
-	debuges("Debug:github.com/satori/go.uuid:   %s\n", id)
 	return id.String()
 }
 
@@ -143,9 +138,8 @@ func genUUIDv4() string {
 func genHashedID(jsondata interface{}) string {
 	dataBytes, err := json.Marshal(jsondata)
 	if err != nil {
-		log.Printf("Error during event encoding: %v\n", err)
 		uuid := genUUIDv4()
-		log.Printf("Will return a UUID string instead (duplicate events may occur): %v\n", uuid)
+		logger.Warn("Failed to encode event, using UUID as document ID instead (duplicate events may occur)", "id", uuid, "error", err)
 		return uuid
 	}
 	eventHashBytes := sha1.Sum(dataBytes)
@@ -158,7 +152,6 @@ func (ec *ElasticClient) Create(indexname string, indextype string, jsondata int
 	ctx := ec.ctx
 	id := genHashedID(jsondata)
 
-	debuges("Debug:Printing body %s\n", jsondata)
 	result, err := ec.client.Index().
 		Index(string(indexname)).
 		Type(string(indextype)).
@@ -167,10 +160,10 @@ func (ec *ElasticClient) Create(indexname string, indextype string, jsondata int
 		Do(ctx)
 	if err != nil {
 		// Handle error
-		debuges("Create document Error %#v", err)
+		logger.Debug("Failed to create document", "index", indexname, "id", id, "error", err)
 		return id, err
 	}
-	debuges("Debug:Indexed  %s to index %s, type %s\n", result.Id, result.Index, result.Type)
+	logger.Debug("Indexed document", "index", result.Index, "type", result.Type, "id", result.Id)
 	// Flush to make sure the documents got written.
 	// Flush asks Elasticsearch to free memory from the index and
 	// flush data to disk.
@@ -189,7 +182,7 @@ func (ec *ElasticClient) DeleteIndex(index string) error {
 		return err
 	}
 	if !deleteIndex.Acknowledged {
-		debuges("Debug:ElasticSearch DeleteIndex not acknowledged")
+		logger.Debug("Index deletion not acknowledged", "index", index)
 	}
 	return nil
 }
@@ -216,7 +209,7 @@ func (ec *ElasticClient) Get(indexname string, indextype string, id string) (*el
 		return nil, err
 	}
 	if result.Found {
-		debuges("Debug:Got document %s in version %d from index %s, type %s\n", result.Id, result.Version, result.Index, result.Type)
+		logger.Debug("Got document", "index", result.Index, "type", result.Type, "id", result.Id, "version", result.Version)
 	}
 	return result, nil
 }
@@ -235,6 +228,6 @@ func (ec *ElasticClient) Search(indexname string) *elastic.SearchResult {
 		// Handle error
 		panic(err)
 	}
-	debuges("Debug:Query took %d milliseconds\n", searchResult.TookInMillis)
+	logger.Debug("Query finished", "index", indexname, "took_ms", searchResult.TookInMillis)
 	return searchResult
 }
@@ -0,0 +1,114 @@
+package tsdb
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+)
+
+var (
+	logger   = logging.New("tsdb")
+	unitLock = new(sync.RWMutex)
+	// metricUnits maps metric names to units reported in OpenMetrics exposition, empty unit marks
+	// metrics reported with conflicting units
+	metricUnits = map[string]string{}
+)
+
+//setMetricUnit records unit of given metric. OpenMetrics requires the unit to be suffix
+//of the metric name, so units of metrics not named accordingly are ignored. Metrics reported
+//with different units by different sources are exposed without unit.
+func setMetricUnit(metricName string, unit string) {
+	familyName := strings.TrimSuffix(metricName, "_total")
+	if unit == "" || !strings.HasSuffix(familyName, "_"+unit) {
+		return
+	}
+	unitLock.RLock()
+	recorded, known := metricUnits[metricName]
+	unitLock.RUnlock()
+	if known && recorded == unit {
+		return
+	}
+	unitLock.Lock()
+	defer unitLock.Unlock()
+	if recorded, known = metricUnits[metricName]; !known {
+		metricUnits[metricName] = unit
+	} else if recorded != "" && recorded != unit {
+		logger.Warn("Metric reported with conflicting units, exposing it without unit", "metric", metricName, "units", []string{recorded, unit})
+		metricUnits[metricName] = ""
+	}
+}
+
+//GetMetricUnit returns unit recorded for given metric name or empty string if the unit is unknown
+func GetMetricUnit(metricName string) string {
+	unitLock.RLock()
+	defer unitLock.RUnlock()
+	return metricUnits[metricName]
+}
+
+//NewExpositionHandler returns HTTP handler exposing metrics of given gatherer. The format is negotiated
+//according to Accept header of the request, so the metrics are served in OpenMetrics format including
+//# UNIT lines and _created samples of counters for scrapers preferring it and in text format 0.0.4 otherwise.
+//Names of metric families are the same in both formats. The response is compressed for scrapers accepting
+//gzip encoding. Exemplars are not exposed, as neither collectd nor Ceilometer send trace identifiers which
+//they could refer to.
+func NewExpositionHandler(gatherer prometheus.Gatherer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		families, err := gatherer.Gather()
+		if err != nil {
+			logger.Error("Failed to gather metrics", "error", err)
+			if len(families) == 0 {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+		w.Header().Set("Content-Type", string(format))
+		var out io.Writer = w
+		if gzipAccepted(r.Header) {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			defer gz.Close()
+			out = gz
+		}
+		encoder := expfmt.NewEncoder(out, format, expfmt.WithCreatedLines(), expfmt.WithUnit())
+		for _, family := range families {
+			if unit := GetMetricUnit(family.GetName()); unit != "" {
+				family.Unit = &unit
+			}
+			if err := encoder.Encode(family); err != nil {
+				logger.Error("Failed to encode metric family", "family", family.GetName(), "error", err)
+				return
+			}
+		}
+		if closer, ok := encoder.(expfmt.Closer); ok {
+			if err := closer.Close(); err != nil {
+				logger.Error("Failed to finalize metrics exposition", "error", err)
+			}
+		}
+	})
+}
+
+//NewInstrumentedExpositionHandler returns exposition handler of given gatherer instrumented with
+//promhttp_metric_handler_* metrics registered to given registerer
+func NewInstrumentedExpositionHandler(registerer prometheus.Registerer, gatherer prometheus.Gatherer) http.Handler {
+	return promhttp.InstrumentMetricHandler(registerer, NewExpositionHandler(gatherer))
+}
+
+//gzipAccepted returns true if the client accepts gzip encoded response
+func gzipAccepted(header http.Header) bool {
+	for _, part := range strings.Split(header.Get("Accept-Encoding"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "gzip" || strings.HasPrefix(part, "gzip;") {
+			return true
+		}
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package tsdb
 import (
 	"fmt"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/infrawatch/smart-gateway/internal/pkg/metrics/incoming"
@@ -22,6 +23,7 @@ type TSDB interface {
 	GetLabels() map[string]string
 	GetMetricName(index int) string
 	GetMetricDesc(index int) string
+	GetMetricUnit(index int) string
 }
 
 var (
@@ -52,14 +54,41 @@ func AddMetricsByHost(instance string, value float64) (prometheus.Metric, error)
 	return prometheus.NewConstMetric(desc, valueType, value)
 }
 
+//NewPrometheusRateMetric creates gauge with per-second rate of collectd counter or derive data source.
+//Name of the gauge is the name of counter metric with "_total" suffix replaced by "_per_second".
+func NewPrometheusRateMetric(usetimestamp bool, collectd *incoming.CollectdMetric, index int, rate float64) (prometheus.Metric, error) {
+	metricName := metricNameRe.ReplaceAllString(collectd.GetMetricName(index), "_")
+	metricName = strings.TrimSuffix(metricName, "_total") + "_per_second"
+	help := fmt.Sprintf("Per-second rate computed by Smart Gateway from %s", collectd.GetMetricDesc(index))
+
+	plabels := prometheus.Labels{}
+	for key, value := range collectd.GetMetricLabels(index) {
+		plabels[key] = value
+	}
+	desc := prometheus.NewDesc(metricName, help, []string{}, plabels)
+	if usetimestamp {
+		return prometheus.NewMetricWithTimestamp(
+			collectd.Time.Time(),
+			prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, rate),
+		), nil
+	}
+	return prometheus.NewConstMetric(desc, prometheus.GaugeValue, rate)
+}
+
 //NewPrometheusMetric converts one data source of a value list to a Prometheus metric.
 func NewPrometheusMetric(usetimestamp bool, format string, metric incoming.MetricDataFormat, index int) (prometheus.Metric, error) {
+	return NewPrometheusMetricWithCreated(usetimestamp, format, metric, index, time.Time{})
+}
+
+//NewPrometheusMetricWithCreated converts one data source of a value list to a Prometheus metric.
+//Counters will contain given created timestamp unless it is zero.
+func NewPrometheusMetricWithCreated(usetimestamp bool, format string, metric incoming.MetricDataFormat, index int, created time.Time) (prometheus.Metric, error) {
 	var (
-		timestamp        time.Time
-		valueType        prometheus.ValueType
-		metricName, help string
-		labels           map[string]string
-		value            float64
+		timestamp              time.Time
+		valueType              prometheus.ValueType
+		metricName, help, unit string
+		labels                 map[string]string
+		value                  float64
 	)
 
 	if format == saconfig.DataSourceCollectd.String() {
@@ -75,7 +104,8 @@ func NewPrometheusMetric(usetimestamp bool, format string, metric incoming.Metri
 		timestamp = collectd.Time.Time()
 		help = collectd.GetMetricDesc(index)
 		metricName = metricNameRe.ReplaceAllString(collectd.GetMetricName(index), "_")
-		labels = collectd.GetLabels()
+		labels = collectd.GetMetricLabels(index)
+		unit = collectd.GetMetricUnit(index)
 		value = collectd.Values[index]
 	} else if format == saconfig.DataSourceCeilometer.String() {
 		ceilometer := metric.(*incoming.CeilometerMetric)
@@ -110,19 +140,43 @@ func NewPrometheusMetric(usetimestamp bool, format string, metric incoming.Metri
 		help = ""
 		metricName = metricNameRe.ReplaceAllString(ceilometer.GetMetricName(index), "_")
 		labels = ceilometer.GetLabels()
+		unit = ceilometer.GetMetricUnit(index)
 		value = ceilometer.Values[index]
+	} else if format == saconfig.DataSourceUniversal.String() {
+		universal := metric.(*incoming.UniversalMetric)
+		switch universal.Type {
+		case incoming.UniversalGauge:
+			valueType = prometheus.GaugeValue
+		case incoming.UniversalCounter:
+			valueType = prometheus.CounterValue
+		default:
+			return nil, fmt.Errorf("unknown name of value type: %s", universal.Type)
+		}
+		timestamp = universal.GetTime()
+		help = universal.GetMetricDesc(index)
+		metricName = universal.GetMetricName(index)
+		labels = universal.GetLabels()
+		unit = universal.GetMetricUnit(index)
+		value = universal.GetValues()[index]
 	}
+	setMetricUnit(metricName, unit)
 
 	plabels := prometheus.Labels{}
 	for key, value := range labels {
 		plabels[key] = value
 	}
 	desc := prometheus.NewDesc(metricName, help, []string{}, plabels)
-	if usetimestamp {
-		return prometheus.NewMetricWithTimestamp(
-			timestamp,
-			prometheus.MustNewConstMetric(desc, valueType, value),
-		), nil
+	var (
+		promMetric prometheus.Metric
+		err        error
+	)
+	if valueType == prometheus.CounterValue && !created.IsZero() {
+		promMetric, err = prometheus.NewConstMetricWithCreatedTimestamp(desc, valueType, value, created)
+	} else {
+		promMetric, err = prometheus.NewConstMetric(desc, valueType, value)
 	}
-	return prometheus.NewConstMetric(desc, valueType, value)
+	if err != nil || !usetimestamp {
+		return promMetric, err
+	}
+	return prometheus.NewMetricWithTimestamp(timestamp, promMetric), nil
 }
@@ -0,0 +1,170 @@
+package tsdb
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/metrics/incoming"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// metricNameLabel is pseudo label used in selectors for matching metric names
+const metricNameLabel = "__name__"
+
+var (
+	selectorNameRe    = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*`)
+	selectorMatcherRe = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|!~|!=|=)\s*("(?:[^"\\]|\\.)*")\s*(,|$)`)
+)
+
+//MetricFilter decides whether metric of given name and labels should be exported
+type MetricFilter interface {
+	Matches(name string, labels map[string]string) bool
+}
+
+//labelMatcher matches single label of a metric
+type labelMatcher struct {
+	name     string
+	operator string
+	value    string
+	rex      *regexp.Regexp
+}
+
+func (m *labelMatcher) matches(value string) bool {
+	switch m.operator {
+	case "=":
+		return value == m.value
+	case "!=":
+		return value != m.value
+	case "=~":
+		return m.rex.MatchString(value)
+	default:
+		return !m.rex.MatchString(value)
+	}
+}
+
+//Selector is a Prometheus series selector, eg. collectd_cpu_total{instance="host1",type=~"user|system"}
+type Selector struct {
+	matchers []*labelMatcher
+}
+
+//ParseSelector parses series selector in Prometheus syntax. Metric name and label matchers
+//using =, !=, =~ and !~ operators are supported. Regular expressions are fully anchored.
+func ParseSelector(selector string) (*Selector, error) {
+	sel := &Selector{}
+	rest := strings.TrimSpace(selector)
+	if name := selectorNameRe.FindString(rest); name != "" {
+		sel.matchers = append(sel.matchers, &labelMatcher{name: metricNameLabel, operator: "=", value: name})
+		rest = strings.TrimSpace(rest[len(name):])
+	}
+	if rest != "" {
+		if !strings.HasPrefix(rest, "{") || !strings.HasSuffix(rest, "}") {
+			return nil, fmt.Errorf("invalid selector '%s'", selector)
+		}
+		rest = strings.TrimSpace(rest[1 : len(rest)-1])
+		for rest != "" {
+			parts := selectorMatcherRe.FindStringSubmatch(rest)
+			if parts == nil {
+				return nil, fmt.Errorf("invalid label matcher '%s' in selector '%s'", rest, selector)
+			}
+			value, err := strconv.Unquote(parts[3])
+			if err != nil {
+				return nil, fmt.Errorf("invalid label value %s in selector '%s'", parts[3], selector)
+			}
+			matcher := &labelMatcher{name: parts[1], operator: parts[2], value: value}
+			if strings.HasSuffix(matcher.operator, "~") {
+				if matcher.rex, err = regexp.Compile(fmt.Sprintf("^(?:%s)$", value)); err != nil {
+					return nil, fmt.Errorf("invalid regular expression '%s' in selector '%s': %s", value, selector, err)
+				}
+			}
+			sel.matchers = append(sel.matchers, matcher)
+			rest = rest[len(parts[0]):]
+		}
+	}
+	if len(sel.matchers) == 0 {
+		return nil, fmt.Errorf("selector '%s' does not contain any matcher", selector)
+	}
+	return sel, nil
+}
+
+//Matches returns true if given metric matches all matchers of the selector. Missing labels
+//are matched as empty strings.
+func (s *Selector) Matches(name string, labels map[string]string) bool {
+	for _, matcher := range s.matchers {
+		value := labels[matcher.name]
+		if matcher.name == metricNameLabel {
+			value = name
+		}
+		if !matcher.matches(value) {
+			return false
+		}
+	}
+	return true
+}
+
+//Selectors is a list of selectors. Metric matches if it matches any of the selectors.
+type Selectors []*Selector
+
+//ParseSelectors parses all given selectors
+func ParseSelectors(selectors []string) (Selectors, error) {
+	result := make(Selectors, 0, len(selectors))
+	for _, selector := range selectors {
+		sel, err := ParseSelector(selector)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, sel)
+	}
+	return result, nil
+}
+
+//Matches returns true if given metric matches any of the selectors
+func (s Selectors) Matches(name string, labels map[string]string) bool {
+	for _, sel := range s {
+		if sel.Matches(name, labels) {
+			return true
+		}
+	}
+	return false
+}
+
+//GetMetricIdentity returns name and labels of Prometheus metric which would be created from data source
+//on given index of given metric
+func GetMetricIdentity(metric incoming.MetricDataFormat, index int) (string, map[string]string) {
+	switch m := metric.(type) {
+	case *incoming.CollectdMetric:
+		return metricNameRe.ReplaceAllString(m.GetMetricName(index), "_"), m.GetMetricLabels(index)
+	case *incoming.CeilometerMetric:
+		return metricNameRe.ReplaceAllString(m.GetMetricName(index), "_"), m.GetLabels()
+	case *incoming.UniversalMetric:
+		return m.GetMetricName(index), m.GetLabels()
+	}
+	return "", nil
+}
+
+//NewFilteredGatherer returns gatherer returning only metrics of given gatherer matching the filter
+func NewFilteredGatherer(gatherer prometheus.Gatherer, filter MetricFilter) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := gatherer.Gather()
+		result := make([]*dto.MetricFamily, 0, len(families))
+		for _, family := range families {
+			metrics := make([]*dto.Metric, 0, len(family.Metric))
+			for _, metric := range family.Metric {
+				labels := make(map[string]string, len(metric.Label))
+				for _, label := range metric.Label {
+					labels[label.GetName()] = label.GetValue()
+				}
+				if filter.Matches(family.GetName(), labels) {
+					metrics = append(metrics, metric)
+				}
+			}
+			if len(metrics) > 0 {
+				family.Metric = metrics
+				result = append(result, family)
+			}
+		}
+		return result, err
+	})
+}
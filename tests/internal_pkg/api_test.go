@@ -0,0 +1,699 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/api"
+	"github.com/infrawatch/smart-gateway/internal/pkg/cacheutil"
+	"github.com/infrawatch/smart-gateway/internal/pkg/logging"
+	"github.com/infrawatch/smart-gateway/internal/pkg/metrics"
+	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHealthHandlers(t *testing.T) {
+	metricsHealth := cacheutil.NewApplicationHealthCache()
+	metricsHealth.SetConnectionStatus("127.0.0.1:5672/collectd/telemetry", "collectd", 1)
+	eventsHealth := cacheutil.NewApplicationHealthCache()
+	eventsHealth.SetConnectionStatus("127.0.0.1:5672/collectd/notify", "collectd", 0)
+
+	handler := http.NewServeMux()
+	api.RegisterHealthHandlers(handler, map[string]*cacheutil.ApplicationHealthCache{"metrics": metricsHealth, "events": eventsHealth})
+
+	request := func(path string) (int, api.HealthResponse) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		var response api.HealthResponse
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+		return recorder.Code, response
+	}
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		code, response := request(path)
+		if path == "/healthz" {
+			assert.Equal(t, http.StatusOK, code)
+		} else {
+			assert.Equal(t, http.StatusServiceUnavailable, code)
+		}
+		assert.Equal(t, "not ready", response.Status)
+		assert.False(t, response.Ready)
+		assert.True(t, response.Components["metrics"].Ready)
+		assert.Equal(t, []string{"AMQP1.0 collectd connection to 127.0.0.1:5672/collectd/notify is not established"}, response.Components["events"].Problems)
+	}
+
+	eventsHealth.SetConnectionStatus("127.0.0.1:5672/collectd/notify", "collectd", 1)
+	code, response := request("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", response.Status)
+	assert.True(t, response.Ready)
+}
+
+func TestAdminHandler(t *testing.T) {
+	server := cacheutil.NewCacheServer(1, false)
+	GenerateSampleCacheData(server, "hostname1", 3)
+	GenerateSampleCacheData(server, "hostname2", 1)
+	server.Flush()
+
+	handler := http.NewServeMux()
+	api.RegisterAdminHandler(handler, server.GetCache(), "s3cr3t")
+	request := func(method string, path string, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cr3t")
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	t.Run("Test authorization", func(t *testing.T) {
+		for _, header := range []string{"", "Bearer wrong", "s3cr3", "s3cr3t", "Basic s3cr3t"} {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/admin/cache/hosts", nil)
+			req.Header.Set("Authorization", header)
+			handler.ServeHTTP(recorder, req)
+			assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+		}
+		assert.Equal(t, http.StatusNotFound, request("GET", "/admin/unknown", "").Code)
+		assert.Equal(t, http.StatusMethodNotAllowed, request("POST", "/admin/cache/hosts", "").Code)
+	})
+
+	t.Run("Test hosts", func(t *testing.T) {
+		recorder := request("GET", "/admin/cache/hosts", "")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		var hosts []cacheutil.HostSummary
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &hosts))
+		if assert.Len(t, hosts, 2) {
+			assert.Equal(t, "hostname1", hosts[0].Host)
+			assert.Equal(t, 3, hosts[0].Items)
+			assert.Equal(t, 6, hosts[0].Series)
+			assert.InDelta(t, time.Now().Unix(), hosts[0].LastAccess, 2)
+			assert.Equal(t, "hostname2", hosts[1].Host)
+			assert.Equal(t, 1, hosts[1].Items)
+		}
+
+		recorder = request("GET", "/admin/cache/hosts/hostname1", "")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		var items []cacheutil.CacheItem
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &items))
+		if assert.Len(t, items, 3) {
+			assert.Equal(t, "collectd", items[0].DataSource)
+			assert.True(t, items[0].New)
+			assert.Contains(t, string(items[0].Metric), `"host":"hostname1"`)
+		}
+		assert.Equal(t, http.StatusNotFound, request("GET", "/admin/cache/hosts/unknown", "").Code)
+
+		// evict single item and then whole host
+		assert.Equal(t, http.StatusOK, request("DELETE", "/admin/cache/hosts/hostname1?item="+items[0].Key, "").Code)
+		assert.Equal(t, http.StatusNotFound, request("DELETE", "/admin/cache/hosts/hostname1?item="+items[0].Key, "").Code)
+		assert.Equal(t, 2, server.GetCache().GetShard("hostname1").Size())
+		assert.Equal(t, http.StatusOK, request("DELETE", "/admin/cache/hosts/hostname1", "").Code)
+		assert.Equal(t, http.StatusNotFound, request("DELETE", "/admin/cache/hosts/hostname1", "").Code)
+		assert.Equal(t, 1, server.GetCache().Size())
+	})
+
+	t.Run("Test expire", func(t *testing.T) {
+		recorder := request("POST", "/admin/cache/expire", "")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.JSONEq(t, `{"expired": 0}`, recorder.Body.String())
+		time.Sleep(2 * time.Second)
+		recorder = request("POST", "/admin/cache/expire", "")
+		assert.JSONEq(t, `{"expired": 1}`, recorder.Body.String())
+		assert.Equal(t, 0, server.GetCache().Size())
+	})
+
+	t.Run("Test log level", func(t *testing.T) {
+		defer logging.SetLevels(logging.LevelInfo, nil)
+		logging.SetLevels(logging.LevelInfo, nil)
+		recorder := request("GET", "/admin/loglevel", "")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.JSONEq(t, `{"level": "info", "levels": {}}`, recorder.Body.String())
+
+		recorder = request("PUT", "/admin/loglevel", `{"level": "warn", "levels": {"amqp10": "debug"}}`)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.JSONEq(t, `{"level": "warn", "levels": {"amqp10": "debug"}}`, recorder.Body.String())
+		assert.True(t, logging.New("amqp10").Enabled(logging.LevelDebug))
+		assert.False(t, logging.New("metrics").Enabled(logging.LevelInfo))
+
+		assert.Equal(t, http.StatusBadRequest, request("PUT", "/admin/loglevel", `{"level": "verbose"}`).Code)
+		assert.Equal(t, http.StatusBadRequest, request("PUT", "/admin/loglevel", `{"level": "info", "levels": {"api": "loud"}}`).Code)
+		assert.Equal(t, http.StatusBadRequest, request("PUT", "/admin/loglevel", `level=info`).Code)
+	})
+}
+
+//generateCertificate creates certificate signed by given parent (self-signed in case parent is nil)
+//and writes it together with its key to given directory
+func generateCertificate(t *testing.T, dir string, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestProtectedHandler(t *testing.T) {
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.Write(body)
+	})
+	request := func(handler http.Handler, path string, body string, prepare func(*http.Request)) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		if prepare != nil {
+			prepare(req)
+		}
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+	bearer := func(token string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+	basic := func(user, pass string) func(*http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(user, pass) }
+	}
+
+	t.Run("Test route authentication", func(t *testing.T) {
+		handler := api.NewProtectedHandler(echo, saconfig.EventAPIConfig{
+			Auth:      saconfig.APIAuthBearer,
+			RouteAuth: map[string]string{"/metrics": saconfig.APIAuthBasic, "/healthz": saconfig.APIAuthNone},
+			AuthToken: "s3cr3t",
+			AuthUser:  "prometheus",
+			AuthPass:  "pa55",
+		})
+		assert.Equal(t, http.StatusUnauthorized, request(handler, "/alert", "{}", nil).Code)
+		assert.Equal(t, http.StatusUnauthorized, request(handler, "/alert", "{}", bearer("wrong")).Code)
+		assert.Equal(t, http.StatusUnauthorized, request(handler, "/alert", "{}", basic("prometheus", "pa55")).Code)
+		assert.Equal(t, http.StatusOK, request(handler, "/alert", "{}", bearer("s3cr3t")).Code)
+		// token has to use Bearer scheme, which is case-insensitive
+		for header, code := range map[string]int{
+			"s3cr3t":        http.StatusUnauthorized,
+			"Basic s3cr3t":  http.StatusUnauthorized,
+			"Bearers3cr3t":  http.StatusUnauthorized,
+			"bearer s3cr3t": http.StatusOK,
+			"BEARER s3cr3t": http.StatusOK,
+		} {
+			recorder := request(handler, "/alert", "{}", func(r *http.Request) { r.Header.Set("Authorization", header) })
+			assert.Equal(t, code, recorder.Code, header)
+		}
+
+		recorder := request(handler, "/metrics", "", bearer("s3cr3t"))
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+		assert.Equal(t, `Basic realm="smart-gateway"`, recorder.Header().Get("WWW-Authenticate"))
+		assert.Equal(t, http.StatusUnauthorized, request(handler, "/metrics", "", basic("prometheus", "wrong")).Code)
+		assert.Equal(t, http.StatusOK, request(handler, "/metrics", "", basic("prometheus", "pa55")).Code)
+
+		assert.Equal(t, http.StatusOK, request(handler, "/healthz", "", nil).Code)
+	})
+
+	t.Run("Test route does not cover sibling paths", func(t *testing.T) {
+		handler := api.NewProtectedHandler(echo, saconfig.EventAPIConfig{
+			Auth:      saconfig.APIAuthBearer,
+			RouteAuth: map[string]string{"/alert": saconfig.APIAuthNone, "/admin/": saconfig.APIAuthNone},
+			AuthToken: "s3cr3t",
+		})
+		for path, code := range map[string]int{
+			"/alert":          http.StatusOK,
+			"/alert/":         http.StatusOK,
+			"/alert/critical": http.StatusOK,
+			"/alerts":         http.StatusUnauthorized,
+			"/alertmanager":   http.StatusUnauthorized,
+			"/admin":          http.StatusUnauthorized,
+			"/admin/cache":    http.StatusOK,
+			"/administrator":  http.StatusUnauthorized,
+		} {
+			assert.Equal(t, code, request(handler, path, "{}", nil).Code, path)
+		}
+	})
+
+	t.Run("Test probe endpoints", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.Handle("/alert", echo)
+		api.RegisterHealthHandlers(mux, map[string]*cacheutil.ApplicationHealthCache{"events": cacheutil.NewApplicationHealthCache()})
+		handler := api.NewProtectedHandler(mux, saconfig.EventAPIConfig{Auth: saconfig.APIAuthBearer, AuthToken: "s3cr3t"})
+		assert.Equal(t, http.StatusUnauthorized, request(handler, "/alert", "{}", nil).Code)
+		for _, path := range api.ProbePaths {
+			assert.Equal(t, http.StatusOK, request(handler, path, "", nil).Code, path)
+		}
+		assert.Equal(t, http.StatusUnauthorized, request(handler, "/healthz/", "", nil).Code)
+	})
+
+	t.Run("Test body size limit", func(t *testing.T) {
+		handler := api.NewProtectedHandler(echo, saconfig.EventAPIConfig{MaxBodySize: 8})
+		recorder := request(handler, "/alert", "12345678", nil)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "12345678", recorder.Body.String())
+		assert.Equal(t, http.StatusRequestEntityTooLarge, request(handler, "/alert", "123456789", nil).Code)
+		// body of unknown length is limited while reading
+		assert.Equal(t, http.StatusRequestEntityTooLarge, request(handler, "/alert", "", func(r *http.Request) {
+			r.Body, r.ContentLength = ioutil.NopCloser(strings.NewReader("123456789")), -1
+		}).Code)
+	})
+
+	t.Run("Test rate limit", func(t *testing.T) {
+		handler := api.NewProtectedHandler(echo, saconfig.EventAPIConfig{RateLimit: 10, RateBurst: 3})
+		client := func(addr string) func(*http.Request) {
+			return func(r *http.Request) { r.RemoteAddr = addr }
+		}
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, http.StatusOK, request(handler, "/alert", "{}", client("10.0.0.1:1234")).Code)
+		}
+		recorder := request(handler, "/alert", "{}", client("10.0.0.1:1235"))
+		assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+		assert.Equal(t, "1", recorder.Header().Get("Retry-After"))
+		// clients are limited separately
+		assert.Equal(t, http.StatusOK, request(handler, "/alert", "{}", client("10.0.0.2:1234")).Code)
+		time.Sleep(150 * time.Millisecond)
+		assert.Equal(t, http.StatusOK, request(handler, "/alert", "{}", client("10.0.0.1:1234")).Code)
+	})
+
+	t.Run("Test mutual TLS", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "sg-test-api-tls")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		ca, caKey := generateCertificate(t, dir, "ca", nil, nil)
+		generateCertificate(t, dir, "server", ca, caKey)
+		generateCertificate(t, dir, "client", ca, caKey)
+
+		_, err = api.NewTLSConfig(filepath.Join(dir, "server.crt"), filepath.Join(dir, "missing.key"), "")
+		assert.Error(t, err)
+		tlsConfig, err := api.NewTLSConfig(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := httptest.NewUnstartedServer(echo)
+		server.TLS = tlsConfig
+		server.StartTLS()
+		defer server.Close()
+
+		pool := x509.NewCertPool()
+		pool.AddCert(ca)
+		clientTLS := &tls.Config{RootCAs: pool}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+		_, err = client.Post(server.URL, "application/json", strings.NewReader("{}"))
+		assert.Error(t, err, "client certificate has to be required")
+
+		clientCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		clientTLS.Certificates = []tls.Certificate{clientCert}
+		client = &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+		resp, err := client.Post(server.URL, "application/json", strings.NewReader("{}"))
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+	})
+}
+
+func TestBasicAuthHandler(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("pa55"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := map[string]string{"prometheus": string(hash)}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+	request := func(handler http.Handler, path string, user string, pass string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		if user != "" {
+			req.SetBasicAuth(user, pass)
+		}
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	t.Run("Test basic authentication", func(t *testing.T) {
+		handler := api.NewBasicAuthHandler(ok, users)
+		recorder := request(handler, "/metrics", "", "")
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+		assert.Equal(t, `Basic realm="smart-gateway"`, recorder.Header().Get("WWW-Authenticate"))
+		assert.Equal(t, http.StatusUnauthorized, request(handler, "/metrics", "prometheus", "wrong").Code)
+		assert.Equal(t, http.StatusUnauthorized, request(handler, "/metrics", "grafana", "pa55").Code)
+		// the second request is verified using cache
+		for i := 0; i < 2; i++ {
+			recorder = request(handler, "/metrics", "prometheus", "pa55")
+			assert.Equal(t, http.StatusOK, recorder.Code)
+			assert.Equal(t, "ok", recorder.Body.String())
+		}
+		assert.Equal(t, http.StatusUnauthorized, request(handler, "/metrics", "prometheus", "pa55 ").Code)
+	})
+
+	t.Run("Test metrics exporter", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "sg-test-web-config")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		webConfig := filepath.Join(dir, "web.yml")
+		content := "basic_auth_users:\n  prometheus: " + string(hash) + "\n"
+		if err := ioutil.WriteFile(webConfig, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		adminHash, err := bcrypt.GenerateFromPassword([]byte("4dm1n"), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", ok)
+		api.RegisterHealthHandlers(mux, map[string]*cacheutil.ApplicationHealthCache{"metrics": cacheutil.NewApplicationHealthCache()})
+
+		handler, tlsConfig, err := metrics.NewExporterHandler(&saconfig.MetricConfiguration{WebConfigFile: webConfig}, mux)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Nil(t, tlsConfig)
+		assert.Equal(t, http.StatusUnauthorized, request(handler, "/metrics", "", "").Code)
+		assert.Equal(t, http.StatusOK, request(handler, "/metrics", "prometheus", "pa55").Code)
+		// probes do not need credentials
+		assert.Equal(t, http.StatusOK, request(handler, "/healthz", "", "").Code)
+		assert.Equal(t, http.StatusOK, request(handler, "/readyz", "", "").Code)
+		assert.Equal(t, http.StatusUnauthorized, request(handler, "/debug/pprof/", "", "").Code)
+		assert.Equal(t, http.StatusOK, request(handler, "/debug/pprof/", "prometheus", "pa55").Code)
+
+		handler, _, err = metrics.NewExporterHandler(&saconfig.MetricConfiguration{
+			WebConfigFile: webConfig,
+			PprofUsers:    map[string]string{"admin": string(adminHash)},
+		}, mux)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, http.StatusOK, request(handler, "/metrics", "prometheus", "pa55").Code)
+		assert.Equal(t, http.StatusUnauthorized, request(handler, "/debug/pprof/", "prometheus", "pa55").Code)
+		assert.Equal(t, http.StatusOK, request(handler, "/debug/pprof/", "admin", "4dm1n").Code)
+
+		handler, _, err = metrics.NewExporterHandler(&saconfig.MetricConfiguration{DisablePprof: true}, mux)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, http.StatusOK, request(handler, "/metrics", "", "").Code)
+		assert.Equal(t, http.StatusNotFound, request(handler, "/debug/pprof/", "", "").Code)
+		assert.Equal(t, http.StatusNotFound, request(handler, "/debug/pprof/heap", "", "").Code)
+
+		_, _, err = metrics.NewExporterHandler(&saconfig.MetricConfiguration{WebConfigFile: filepath.Join(dir, "missing.yml")}, mux)
+		assert.Error(t, err)
+	})
+
+	t.Run("Test web TLS configuration", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "sg-test-web-tls")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		ca, caKey := generateCertificate(t, dir, "ca", nil, nil)
+		generateCertificate(t, dir, "server", ca, caKey)
+
+		tlsConfig, err := api.NewWebTLSConfig(&saconfig.TLSServerConfig{
+			CertFile:       filepath.Join(dir, "server.crt"),
+			KeyFile:        filepath.Join(dir, "server.key"),
+			ClientCAFile:   filepath.Join(dir, "ca.crt"),
+			ClientAuthType: "VerifyClientCertIfGiven",
+			MinVersion:     "TLS13",
+		})
+		if assert.NoError(t, err) {
+			assert.Equal(t, tls.VerifyClientCertIfGiven, tlsConfig.ClientAuth)
+			assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion)
+			assert.NotNil(t, tlsConfig.ClientCAs)
+		}
+	})
+}
+
+//alertSenderMock records messages sent to the bus and fails when err is set
+type alertSenderMock struct {
+	messages []string
+	err      error
+}
+
+func (as *alertSenderMock) SendSync(bodies []string, timeout time.Duration) error {
+	if as.err != nil {
+		return as.err
+	}
+	as.messages = append(as.messages, bodies...)
+	return nil
+}
+
+func TestAlertHandler(t *testing.T) {
+	webhook := `{
+	"version": "4",
+	"groupKey": "{}:{alertname=\"HighLoad\"}",
+	"truncatedAlerts": 0,
+	"status": "firing",
+	"receiver": "smart-gateway",
+	"groupLabels": {"alertname": "HighLoad"},
+	"commonLabels": {"alertname": "HighLoad", "severity": "warning"},
+	"externalURL": "http://alertmanager:9093",
+	"alerts": [
+		{
+			"status": "firing",
+			"labels": {"alertname": "HighLoad", "severity": "warning", "host": "compute-0"},
+			"annotations": {"summary": "load is high"},
+			"startsAt": "2020-03-04T10:00:00.000+01:00",
+			"endsAt": "0001-01-01T00:00:00Z",
+			"generatorURL": "http://prometheus:9090/graph",
+			"fingerprint": "2a3b4c5d"
+		},
+		{
+			"status": "resolved",
+			"labels": {"alertname": "HighLoad", "severity": "warning", "host": "compute-1"},
+			"startsAt": "2020-03-04T09:00:00Z",
+			"endsAt": "2020-03-04T09:30:00Z"
+		}
+	]
+}`
+	request := func(sender *alertSenderMock, split bool, body string) *httptest.ResponseRecorder {
+		config := saconfig.EventConfiguration{API: saconfig.EventAPIConfig{SplitAlerts: split}}
+		handler := api.Handler{Context: &api.Context{Config: &config, AMQP1Sender: sender}, H: api.AlertHandler}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/alert", strings.NewReader(body)))
+		return recorder
+	}
+
+	t.Run("Test envelope", func(t *testing.T) {
+		sender := &alertSenderMock{}
+		assert.Equal(t, http.StatusAccepted, request(sender, false, webhook).Code)
+		if assert.Len(t, sender.messages, 1) {
+			envelope := api.AlertEnvelope{}
+			if err := json.Unmarshal([]byte(sender.messages[0]), &envelope); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, api.EnvelopeVersion, envelope.Version)
+			assert.Equal(t, "alertmanager", envelope.Source)
+			assert.NotEmpty(t, envelope.ReceivedAt)
+			assert.Equal(t, "firing", envelope.Status)
+			assert.Equal(t, "smart-gateway", envelope.Receiver)
+			assert.Equal(t, map[string]string{}, envelope.CommonAnnotations)
+			assert.Equal(t, []api.Alert{
+				{
+					Status:       "firing",
+					Labels:       map[string]string{"alertname": "HighLoad", "severity": "warning", "host": "compute-0"},
+					Annotations:  map[string]string{"summary": "load is high"},
+					StartsAt:     "2020-03-04T09:00:00Z",
+					GeneratorURL: "http://prometheus:9090/graph",
+					Fingerprint:  "2a3b4c5d",
+				},
+				{
+					Status:      "resolved",
+					Labels:      map[string]string{"alertname": "HighLoad", "severity": "warning", "host": "compute-1"},
+					Annotations: map[string]string{},
+					StartsAt:    "2020-03-04T09:00:00Z",
+					EndsAt:      "2020-03-04T09:30:00Z",
+				},
+			}, envelope.Alerts)
+		}
+	})
+
+	t.Run("Test split alerts", func(t *testing.T) {
+		sender := &alertSenderMock{}
+		assert.Equal(t, http.StatusAccepted, request(sender, true, webhook).Code)
+		if assert.Len(t, sender.messages, 2) {
+			for i, status := range []string{"firing", "resolved"} {
+				envelope := api.AlertEnvelope{}
+				if err := json.Unmarshal([]byte(sender.messages[i]), &envelope); err != nil {
+					t.Fatal(err)
+				}
+				assert.Equal(t, status, envelope.Status)
+				if assert.Len(t, envelope.Alerts, 1) {
+					assert.Equal(t, status, envelope.Alerts[0].Status)
+				}
+			}
+		}
+	})
+
+	t.Run("Test invalid webhook", func(t *testing.T) {
+		sender := &alertSenderMock{}
+		recorder := request(sender, false, `{"version": "3", "status": "pending", "receiver": "sg", "alerts": [{"status": "firing", "labels": {}, "endsAt": "yesterday"}]}`)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		for _, problem := range []string{
+			"version: unsupported version '3', expected 4",
+			"groupKey: value is required",
+			"status: invalid status 'pending', expected firing or resolved",
+			"alerts[0].labels: value is required",
+			"alerts[0].startsAt: value is required",
+			"alerts[0].endsAt: invalid time 'yesterday', expected RFC3339 format",
+		} {
+			assert.Contains(t, recorder.Body.String(), problem)
+		}
+		assert.Equal(t, http.StatusBadRequest, request(sender, false, `{"version": "4"`).Code)
+		assert.Empty(t, sender.messages)
+	})
+
+	t.Run("Test unavailable bus", func(t *testing.T) {
+		sender := &alertSenderMock{err: errors.New("failed to connect to 127.0.0.1:5672: connection refused")}
+		assert.Equal(t, http.StatusServiceUnavailable, request(sender, false, webhook).Code)
+	})
+
+	t.Run("Test routing by labels", func(t *testing.T) {
+		all, compute0, warning, critical := &alertSenderMock{}, &alertSenderMock{}, &alertSenderMock{}, &alertSenderMock{}
+		routes := []*api.AlertRoute{}
+		for _, route := range []struct {
+			sender  *alertSenderMock
+			match   map[string]string
+			matchRE map[string]string
+		}{
+			{compute0, map[string]string{"host": "compute-0"}, nil},
+			{warning, map[string]string{"severity": "warning"}, map[string]string{"host": "compute-[0-9]+"}},
+			{critical, map[string]string{"severity": "critical"}, nil},
+		} {
+			r, err := api.NewAlertRoute("route", route.sender, route.match, route.matchRE)
+			if err != nil {
+				t.Fatal(err)
+			}
+			routes = append(routes, r)
+		}
+		_, err := api.NewAlertRoute("route", critical, nil, map[string]string{"host": "compute-("})
+		assert.Error(t, err)
+
+		config := saconfig.EventConfiguration{}
+		handler := api.Handler{Context: &api.Context{Config: &config, AMQP1Sender: all, Routes: routes}, H: api.AlertHandler}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/alert", strings.NewReader(webhook)))
+		assert.Equal(t, http.StatusAccepted, recorder.Code)
+
+		hosts := func(sender *alertSenderMock) []string {
+			result := []string{}
+			for _, message := range sender.messages {
+				envelope := api.AlertEnvelope{}
+				if err := json.Unmarshal([]byte(message), &envelope); err != nil {
+					t.Fatal(err)
+				}
+				for _, alert := range envelope.Alerts {
+					result = append(result, envelope.Status+":"+alert.Labels["host"])
+				}
+			}
+			return result
+		}
+		assert.Equal(t, []string{"firing:compute-0", "firing:compute-1"}, hosts(all))
+		assert.Equal(t, []string{"firing:compute-0"}, hosts(compute0))
+		assert.Equal(t, []string{"firing:compute-0", "firing:compute-1"}, hosts(warning))
+		assert.Empty(t, critical.messages)
+
+		// envelope of resolved alerts only is resolved
+		routes[0], err = api.NewAlertRoute("route", compute0, map[string]string{"host": "compute-1"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compute0.messages = nil
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/alert", strings.NewReader(webhook)))
+		assert.Equal(t, http.StatusAccepted, recorder.Code)
+		assert.Equal(t, []string{"resolved:compute-1"}, hosts(compute0))
+
+		// failure of any route is reported so that Alertmanager retries
+		warning.err = errors.New("message was not accepted")
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/alert", strings.NewReader(webhook)))
+		assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	})
+}
+
+func TestHTTPServerShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	started, release := make(chan bool), make(chan bool)
+	defer close(release)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	var wg sync.WaitGroup
+	finish := make(chan bool)
+	api.SpawnHTTPServer(&wg, finish, "test", address, handler, nil, 100*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", address)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	go http.Get("http://" + address + "/")
+	<-started
+
+	// active request is awaited, but hanging request does not block shutdown longer than given timeout
+	start := time.Now()
+	close(finish)
+	wg.Wait()
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
@@ -2,11 +2,21 @@ package tests
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"collectd.org/cdtime"
 	"github.com/infrawatch/smart-gateway/internal/pkg/cacheutil"
 	"github.com/infrawatch/smart-gateway/internal/pkg/metrics/incoming"
+	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
+	"github.com/infrawatch/smart-gateway/internal/pkg/tsdb"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -53,6 +63,11 @@ func TestCacheServer(t *testing.T) {
 		assert.NotNil(t, data)
 		assert.Equal(t, "shardtest", data.(*incoming.CollectdMetric).Host)
 	})
+	t.Run("Test Flush", func(t *testing.T) {
+		GenerateSampleCacheData(server, "hostname3", pluginCount)
+		server.Flush()
+		assert.Equal(t, pluginCount, dataCache.GetShard("hostname3").Size())
+	})
 }
 
 func TestCacheServerCleanUp(t *testing.T) {
@@ -78,3 +93,312 @@ func TestCacheServerCleanUp(t *testing.T) {
 		assert.Equal(t, 0, dataCache.Size())
 	})
 }
+
+func TestCacheServerIntervalExpiry(t *testing.T) {
+	server := cacheutil.NewCacheServer(1, true)
+	dataCache := server.GetCache()
+
+	t.Run("Test items with long interval outlive TTL", func(t *testing.T) {
+		sample := GenerateSampleCollectdData("hostname", "slowplugin")
+		sample.Interval = 1
+		server.Put(sample)
+		// wait until the cache server loop stores the sample
+		server.Flush()
+		shard := dataCache.GetShard("hostname")
+		time.Sleep(time.Second * 2)
+		assert.Equal(t, true, shard.Expired())
+		dataCache.FlushAll()
+		dataCache.FlushAll()
+		// item is stale after 3 intervals, so it has to be still cached
+		assert.Equal(t, 1, dataCache.Size())
+		assert.Equal(t, 1, shard.Size())
+	})
+}
+
+func TestCounterTracking(t *testing.T) {
+	created := make(map[string]time.Time)
+	collect := func(shard *cacheutil.ShardedIncomingDataCache) map[string]float64 {
+		ch := make(chan prometheus.Metric, 10)
+		shard.FlushPrometheusMetric(false, true, ch)
+		close(ch)
+		result := make(map[string]float64)
+		for m := range ch {
+			metric := dto.Metric{}
+			m.Write(&metric)
+			name := strings.Split(strings.TrimPrefix(m.Desc().String(), "Desc{fqName: \""), "\"")[0]
+			if metric.Counter != nil {
+				result[name] = metric.GetCounter().GetValue()
+				created[name] = metric.GetCounter().GetCreatedTimestamp().AsTime()
+			} else {
+				result[name] = metric.GetGauge().GetValue()
+			}
+		}
+		return result
+	}
+	start := time.Now()
+	sample := func(offset time.Duration, counter float64, derive float64) *incoming.CollectdMetric {
+		s := GenerateSampleCollectdData("hostname", "interface")
+		s.Type = "if_octets"
+		s.Dstypes = []string{"counter", "derive"}
+		s.Dsnames = []string{"rx", "tx"}
+		s.Values = []float64{counter, derive}
+		s.Time = cdtime.New(start.Add(offset))
+		return s
+	}
+	shard := cacheutil.NewShardedIncomingDataCache(300)
+
+	t.Run("Test first values", func(t *testing.T) {
+		shard.SetData(sample(0, 4294967000, 100))
+		values := collect(shard)
+		assert.Equal(t, 4294967000.0, values["collectd_interface_if_octets_rx_total"])
+		assert.Equal(t, 100.0, values["collectd_interface_if_octets_tx_total"])
+		// rates are not available until second value is received
+		assert.NotContains(t, values, "collectd_interface_if_octets_rx_per_second")
+	})
+
+	t.Run("Test 32-bit counter wrap around", func(t *testing.T) {
+		shard.SetData(sample(10*time.Second, 704, 50))
+		values := collect(shard)
+		assert.Equal(t, 4294968000.0, values["collectd_interface_if_octets_rx_total"])
+		assert.Equal(t, 100.0, values["collectd_interface_if_octets_rx_per_second"])
+		assert.Equal(t, 50.0, values["collectd_interface_if_octets_tx_total"])
+		assert.Equal(t, -5.0, values["collectd_interface_if_octets_tx_per_second"])
+	})
+
+	t.Run("Test values after wrap around", func(t *testing.T) {
+		shard.SetData(sample(20*time.Second, 1704, 150))
+		values := collect(shard)
+		assert.Equal(t, 4294969000.0, values["collectd_interface_if_octets_rx_total"])
+		assert.Equal(t, 100.0, values["collectd_interface_if_octets_rx_per_second"])
+		assert.Equal(t, 10.0, values["collectd_interface_if_octets_tx_per_second"])
+	})
+
+	t.Run("Test counter reset from value above 32-bit limit", func(t *testing.T) {
+		shard.SetData(sample(30*time.Second, 8589934592, 250))
+		values := collect(shard)
+		// offset of the previous wrap around is still applied
+		assert.Equal(t, 12884901888.0, values["collectd_interface_if_octets_rx_total"])
+		previous := created["collectd_interface_if_octets_rx_total"]
+		time.Sleep(10 * time.Millisecond)
+		shard.SetData(sample(40*time.Second, 1000, 350))
+		values = collect(shard)
+		// reset counter is created again
+		assert.True(t, created["collectd_interface_if_octets_rx_total"].After(previous))
+		// reset is not a 64-bit wrap around, so value is not corrected and rate is dropped
+		assert.Equal(t, 1000.0, values["collectd_interface_if_octets_rx_total"])
+		assert.NotContains(t, values, "collectd_interface_if_octets_rx_per_second")
+		assert.Equal(t, 10.0, values["collectd_interface_if_octets_tx_per_second"])
+		shard.SetData(sample(50*time.Second, 2000, 450))
+		values = collect(shard)
+		assert.Equal(t, 2000.0, values["collectd_interface_if_octets_rx_total"])
+		assert.Equal(t, 100.0, values["collectd_interface_if_octets_rx_per_second"])
+	})
+}
+
+func TestFilteredFlush(t *testing.T) {
+	shard := cacheutil.NewShardedIncomingDataCache(300)
+	shard.SetData(GenerateSampleCollectdData("hostname", "pluginname"))
+	selectors, err := tsdb.ParseSelectors([]string{"collectd_pluginname_collectd_value1"})
+	assert.NoError(t, err)
+
+	ch := make(chan prometheus.Metric, 10)
+	assert.Equal(t, 1, shard.CollectFilteredPrometheusMetric(false, false, selectors, ch))
+	assert.Equal(t, 1, shard.CollectFilteredPrometheusMetric(false, false, selectors, ch))
+	// filtered collection does not mark data sources as exported
+	assert.Equal(t, 2, shard.FlushPrometheusMetric(false, false, ch))
+	assert.Equal(t, 0, shard.FlushPrometheusMetric(false, false, ch))
+	// last values stay available for filtered collection
+	assert.Equal(t, 2, shard.CollectFilteredPrometheusMetric(false, false, nil, ch))
+}
+
+func TestCacheSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sgsnapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.json")
+
+	cache := cacheutil.NewCache(300)
+	sample := GenerateSampleCollectdData("hostname", "pluginname")
+	cache.GetShard("hostname").SetData(sample)
+	tests := make(map[string]jsoniter.RawMessage)
+	testDataJSON, err := ioutil.ReadFile("messages/metric-tests.json")
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(testDataJSON, &tests))
+	testData, err := CeilometerMetricTestTemplateFromJSON(string(tests["CeilometerMetrics"]))
+	assert.NoError(t, err)
+	ceilometer := incoming.NewFromDataSource(saconfig.DataSourceCeilometer)
+	metrics, err := ceilometer.ParseInputJSON(string(testData.TestInput))
+	assert.NoError(t, err)
+	cache.GetShard(metrics[0].GetKey()).SetData(metrics[0])
+	start := time.Now()
+	counter := func(offset time.Duration, value float64) *incoming.CollectdMetric {
+		s := GenerateSampleCollectdData("counterhost", "interface")
+		s.Dstypes = []string{"counter"}
+		s.Dsnames = []string{"rx"}
+		s.Values = []float64{value}
+		s.Time = cdtime.New(start.Add(offset))
+		return s
+	}
+	cache.GetShard("counterhost").SetData(counter(0, 4294967000))
+	cache.GetShard("counterhost").SetData(counter(10*time.Second, 704))
+	assert.NoError(t, cache.WriteSnapshot(path))
+
+	t.Run("Test missing snapshot", func(t *testing.T) {
+		restored := cacheutil.NewCache(300)
+		count, err := restored.RestoreSnapshot(filepath.Join(dir, "missing.json"))
+		assert.NoError(t, err)
+		assert.Equal(t, 0, count)
+	})
+
+	t.Run("Test invalid snapshot version", func(t *testing.T) {
+		invalid := filepath.Join(dir, "invalid.json")
+		assert.NoError(t, ioutil.WriteFile(invalid, []byte(`{"version": 666, "hosts": {}}`), 0644))
+		restored := cacheutil.NewCache(300)
+		_, err := restored.RestoreSnapshot(invalid)
+		assert.Error(t, err)
+	})
+
+	t.Run("Test restored data", func(t *testing.T) {
+		restored := cacheutil.NewCache(300)
+		count, err := restored.RestoreSnapshot(path)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, count)
+		assert.Equal(t, 3, restored.Size())
+
+		shard := restored.GetShard("hostname")
+		assert.True(t, shard.Restored())
+		item := shard.GetData(sample.GetItemKey()).(*incoming.CollectdMetric)
+		assert.Equal(t, sample.Values, item.Values)
+		assert.Equal(t, sample.Dsnames, item.Dsnames)
+		assert.Equal(t, sample.Time.Time().Unix(), item.Time.Time().Unix())
+		assert.False(t, item.ISNew())
+		item2 := restored.GetShard(metrics[0].GetKey()).GetData(metrics[0].GetItemKey())
+		assert.Equal(t, metrics[0].GetValues(), item2.GetValues())
+
+		// restored data are not exported again
+		ch := make(chan prometheus.Metric, 10)
+		assert.Equal(t, 0, shard.FlushPrometheusMetric(false, false, ch))
+		// new data mark the shard as up to date
+		shard.SetData(GenerateSampleCollectdData("hostname", "pluginname"))
+		assert.False(t, shard.Restored())
+		assert.Equal(t, 2, shard.FlushPrometheusMetric(false, false, ch))
+	})
+
+	t.Run("Test restored counter state", func(t *testing.T) {
+		restored := cacheutil.NewCache(300)
+		_, err := restored.RestoreSnapshot(path)
+		assert.NoError(t, err)
+		shard := restored.GetShard("counterhost")
+		shard.SetData(counter(20*time.Second, 1704))
+		ch := make(chan prometheus.Metric, 10)
+		assert.Equal(t, 1, shard.FlushPrometheusMetric(false, true, ch))
+		close(ch)
+		values := make(map[string]float64)
+		for m := range ch {
+			metric := dto.Metric{}
+			m.Write(&metric)
+			name := strings.Split(strings.TrimPrefix(m.Desc().String(), "Desc{fqName: \""), "\"")[0]
+			values[name] = metric.GetCounter().GetValue() + metric.GetGauge().GetValue()
+		}
+		// offset of wrap around before restart is still applied
+		assert.Equal(t, 4294969000.0, values["collectd_interface_collectd_rx_total"])
+		assert.Equal(t, 100.0, values["collectd_interface_collectd_rx_per_second"])
+	})
+
+	t.Run("Test version 1 snapshot", func(t *testing.T) {
+		old := filepath.Join(dir, "old.json")
+		assert.NoError(t, ioutil.WriteFile(old, []byte(`{"version": 1, "hosts": {}}`), 0644))
+		restored := cacheutil.NewCache(300)
+		_, err := restored.RestoreSnapshot(old)
+		assert.NoError(t, err)
+	})
+}
+
+func TestApplicationHealth(t *testing.T) {
+	health := cacheutil.NewApplicationHealthCache()
+	health.AddConnection("127.0.0.1:5672/collectd/telemetry", "collectd")
+	health.AddConnection("127.0.0.1:5672/anycast/ceilometer/metering.sample", "ceilometer")
+
+	t.Run("Test disconnected connections", func(t *testing.T) {
+		report := health.Report()
+		assert.False(t, report.Ready)
+		assert.Equal(t, []string{
+			"AMQP1.0 ceilometer connection to 127.0.0.1:5672/anycast/ceilometer/metering.sample is not established",
+			"AMQP1.0 collectd connection to 127.0.0.1:5672/collectd/telemetry is not established",
+		}, report.Problems)
+		assert.Len(t, report.Connections, 2)
+		assert.Nil(t, report.LastMessageAge)
+		assert.Nil(t, report.Elasticsearch)
+		assert.Nil(t, report.AlertManager)
+		qpidRouterState, _ := health.States()
+		assert.Equal(t, 0, qpidRouterState)
+	})
+
+	t.Run("Test connected connections", func(t *testing.T) {
+		health.SetConnectionStatus("127.0.0.1:5672/collectd/telemetry", "collectd", 1)
+		qpidRouterState, _ := health.States()
+		assert.Equal(t, 0, qpidRouterState)
+		health.SetConnectionStatus("127.0.0.1:5672/anycast/ceilometer/metering.sample", "ceilometer", 1)
+		qpidRouterState, _ = health.States()
+		assert.Equal(t, 1, qpidRouterState)
+		health.MessageReceived("127.0.0.1:5672/collectd/telemetry", "collectd")
+
+		report := health.Report()
+		assert.True(t, report.Ready)
+		assert.Empty(t, report.Problems)
+		assert.NotNil(t, report.LastMessageAge)
+		assert.Nil(t, report.Connections[0].LastMessageAge)
+		assert.NotNil(t, report.Connections[1].LastMessageAge)
+	})
+
+	t.Run("Test removed connections", func(t *testing.T) {
+		health.RetainConnections(func(url string, dataSource string) bool {
+			return dataSource == "collectd"
+		})
+		report := health.Report()
+		if assert.Len(t, report.Connections, 1) {
+			assert.Equal(t, "collectd", report.Connections[0].DataSource)
+		}
+	})
+
+	t.Run("Test readiness thresholds", func(t *testing.T) {
+		health.EnableElasticSearch(true)
+		health.EnableAlertManager(true)
+		health.SetReadiness(saconfig.ReadinessConfig{MaxMessageAge: 60, MaxElasticFailures: 2, MaxAlertManagerFailures: 1})
+		health.SetElasticSearchResult(fmt.Errorf("connection refused"))
+		report := health.Report()
+		assert.True(t, report.Ready)
+		assert.Equal(t, 1, report.Elasticsearch.ConsecutiveFailures)
+		assert.False(t, report.Elasticsearch.Healthy)
+		assert.True(t, report.AlertManager.Healthy)
+
+		health.SetElasticSearchResult(fmt.Errorf("connection refused"))
+		health.SetAlertManagerResult(fmt.Errorf("unexpected response status 400 Bad Request"))
+		report = health.Report()
+		assert.False(t, report.Ready)
+		assert.Equal(t, []string{
+			"2 consecutive Elasticsearch request(s) failed",
+			"1 consecutive Alertmanager notification(s) failed",
+		}, report.Problems)
+		assert.Equal(t, "unexpected response status 400 Bad Request", report.AlertManager.LastError)
+
+		health.SetElasticSearchResult(nil)
+		health.SetAlertManagerResult(nil)
+		assert.True(t, health.Report().Ready)
+		_, elasticSearchState := health.States()
+		assert.Equal(t, 1, elasticSearchState)
+	})
+
+	t.Run("Test message age threshold", func(t *testing.T) {
+		stale := cacheutil.NewApplicationHealthCache()
+		stale.SetConnectionStatus("127.0.0.1:5672/collectd/telemetry", "collectd", 1)
+		stale.SetReadiness(saconfig.ReadinessConfig{MaxMessageAge: 1})
+		assert.True(t, stale.Report().Ready)
+		time.Sleep(1100 * time.Millisecond)
+		assert.Equal(t, []string{"no message received for more than 1s"}, stale.Report().Problems)
+		stale.MessageReceived("127.0.0.1:5672/collectd/telemetry", "collectd")
+		assert.True(t, stale.Report().Ready)
+	})
+}
@@ -1,6 +1,8 @@
 package tests
 
 import (
+	"fmt"
+	"io/ioutil"
 	"testing"
 
 	"github.com/infrawatch/smart-gateway/internal/pkg/events/incoming"
@@ -321,4 +323,79 @@ func TestGenerateAlert(t *testing.T) {
 			assert.Equal(t, run.Timestamp, eventAlert.StartsAt)
 		})
 	}
+
+	t.Run("Verify alerts of malformed events", func(t *testing.T) {
+		for source, data := range map[saconfig.DataSource]string{
+			saconfig.DataSourceCollectd:   `{"labels": "compute-0", "annotations": {"summary": null, "count": true}}`,
+			saconfig.DataSourceCeilometer: `{"publisher_id": 1, "priority": ["ERROR"], "payload": "none"}`,
+		} {
+			evt := incoming.NewFromDataSource(source)
+			evt.ParseEvent(data)
+			var alert incoming.PrometheusAlert
+			assert.NotPanics(t, func() { alert = evt.GeneratePrometheusAlert("") }, data)
+			assert.Equal(t, "unknown", alert.Labels["severity"], data)
+			assert.NotContains(t, alert.Labels, "instance", data)
+		}
+	})
+}
+
+func TestCeilometerTraits(t *testing.T) {
+	evt := incoming.NewFromDataSource(saconfig.DataSourceCeilometer)
+	assert.NoError(t, evt.ParseEvent(`{"event_type": "image.update", "payload": {"traits": [["name", 1, "cirros"], ["size", 2, 13287936], ["ratio", 3, 0.5]]}}`))
+	assert.Equal(t, map[string]interface{}{"name": "cirros", "size": 13287936.0, "ratio": 0.5},
+		evt.GetRawData().(map[string]interface{})["payload"].(map[string]interface{})["traits"])
+	for _, traits := range []string{`[[1, 1, "cirros"]]`, `[["name", "1", "cirros"]]`, `[["name", 1, null]]`, `[["name", 1]]`} {
+		assert.Error(t, evt.ParseEvent(fmt.Sprintf(`{"payload": {"traits": %s}}`, traits)), traits)
+	}
+}
+
+func TestUniversalEvent(t *testing.T) {
+	eventData, err := ioutil.ReadFile("messages/universal-event.json")
+	if err != nil {
+		t.Fatalf("Failed loading test data: %s\n", err)
+	}
+
+	t.Run("Test parsing of universal event", func(t *testing.T) {
+		evt := incoming.NewFromDataSource(saconfig.DataSourceUniversal)
+		if err := evt.ParseEvent(string(eventData)); err != nil {
+			t.Fatalf("Failed event parsing: %s", err)
+		}
+		assert.Equal(t, "universal_edge_disk_full", evt.GetIndexName())
+		assert.Equal(t, string(eventData), evt.GetSanitized())
+		assert.Equal(t, incoming.UniversalEventData{
+			Labels:      map[string]string{"alertname": "edge_disk_full", "instance": "edge-0", "device": "sda1"},
+			Annotations: map[string]string{"summary": "disk sda1 of edge-0 is full", "used": "99.7%"},
+			Severity:    "critical",
+			Timestamp:   "2020-03-04T09:00:00.5Z",
+		}, evt.GetRawData())
+	})
+
+	t.Run("Test alert generated from universal event", func(t *testing.T) {
+		evt := incoming.NewFromDataSource(saconfig.DataSourceUniversal)
+		evt.ParseEvent(string(eventData))
+		alert := evt.GeneratePrometheusAlert("https://this/is/test")
+		assert.Equal(t, map[string]string{
+			"alertname":   "edge_disk_full",
+			"instance":    "edge-0",
+			"device":      "sda1",
+			"severity":    "critical",
+			"alertsource": "SmartGateway",
+		}, alert.Labels)
+		assert.Equal(t, map[string]string{"summary": "disk sda1 of edge-0 is full", "used": "99.7%"}, alert.Annotations)
+		assert.Equal(t, "2020-03-04T09:00:00Z", alert.StartsAt)
+		assert.Equal(t, "https://this/is/test", alert.GeneratorURL)
+	})
+
+	t.Run("Test invalid universal events", func(t *testing.T) {
+		evt := incoming.NewFromDataSource(saconfig.DataSourceUniversal)
+		assert.EqualError(t, evt.ParseEvent(`{"labels": {"instance": "edge-0"}, "severity": "fatal"}`), "missing alertname label")
+		assert.Equal(t, "universal_generic", evt.GetIndexName())
+		assert.Equal(t, "unknown", evt.GeneratePrometheusAlert("").Labels["severity"])
+
+		assert.Error(t, evt.ParseEvent(`{"labels": {"alertname": "Edge Down!"}, "timestamp": "yesterday"}`))
+		assert.Equal(t, "universal_edge_down", evt.GetIndexName())
+		assert.NotEmpty(t, evt.GeneratePrometheusAlert("").StartsAt)
+
+		assert.Error(t, evt.ParseEvent(`[{"labels": {"alertname": "listed"}}]`))
+	})
 }
@@ -0,0 +1,423 @@
+package tests
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/events"
+	"github.com/infrawatch/smart-gateway/internal/pkg/events/incoming"
+	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+//parseSinkEvent returns sink data of given event message
+func parseSinkEvent(t *testing.T, source saconfig.DataSource, message string) events.SinkEvent {
+	event := incoming.NewFromDataSource(source)
+	if err := event.ParseEvent(message); err != nil {
+		t.Fatal(err)
+	}
+	return events.NewSinkEvent(event)
+}
+
+func TestWebhookSink(t *testing.T) {
+	connectivity := parseSinkEvent(t, saconfig.DataSourceCollectd, connectivityEventData)
+	ovs := parseSinkEvent(t, saconfig.DataSourceCollectd, ovsEventData)
+	ceilometer := parseSinkEvent(t, saconfig.DataSourceCeilometer, ceiloEventData)
+
+	t.Run("Test webhook event", func(t *testing.T) {
+		assert.Equal(t, "collectd_connectivity", connectivity.Index)
+		assert.Equal(t, "critical", connectivity.Severity)
+		assert.Equal(t, "d60b3c68f23e", connectivity.Host)
+		assert.Equal(t, "compute.host1", ceilometer.Host)
+		assert.Contains(t, connectivity.Raw, "collectd_connectivity_gauge")
+		assert.Equal(t, "info", ovs.Severity)
+		assert.Equal(t, "warning", ceilometer.Severity)
+		// malformed event does not make severity evaluation fail
+		assert.Equal(t, "unknown", events.NewSinkEvent(&incoming.CollectdEvent{}).Severity)
+	})
+
+	t.Run("Test filters", func(t *testing.T) {
+		sink, err := events.NewWebhookSink(saconfig.WebhookConfig{URL: "http://localhost", IndexPattern: "collectd_.*", Severities: []string{"critical", "warning"}})
+		if assert.NoError(t, err) {
+			assert.True(t, sink.Accepts(connectivity))
+			assert.False(t, sink.Accepts(ovs), "severity does not match")
+			assert.False(t, sink.Accepts(ceilometer), "index does not match")
+		}
+		sink, err = events.NewWebhookSink(saconfig.WebhookConfig{URL: "http://localhost"})
+		if assert.NoError(t, err) {
+			assert.Equal(t, "http://localhost", sink.Name())
+			assert.True(t, sink.Accepts(ovs))
+			assert.True(t, sink.Accepts(ceilometer))
+		}
+		_, err = events.NewWebhookSink(saconfig.WebhookConfig{URL: "http://localhost", IndexPattern: "collectd_("})
+		assert.Error(t, err)
+		_, err = events.NewWebhookSink(saconfig.WebhookConfig{URL: "http://localhost", Template: "{{ .Event.Index "})
+		assert.Error(t, err)
+	})
+
+	t.Run("Test body", func(t *testing.T) {
+		sink, err := events.NewWebhookSink(saconfig.WebhookConfig{URL: "http://localhost"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := sink.Body([]events.SinkEvent{connectivity})
+		if assert.NoError(t, err) {
+			data := map[string]interface{}{}
+			assert.NoError(t, json.Unmarshal(body, &data))
+			assert.Equal(t, "collectd_connectivity_gauge", data["labels"].(map[string]interface{})["alertname"])
+		}
+
+		sink, err = events.NewWebhookSink(saconfig.WebhookConfig{URL: "http://localhost", BatchSize: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err = sink.Body([]events.SinkEvent{connectivity, ovs})
+		if assert.NoError(t, err) {
+			data := []interface{}{}
+			assert.NoError(t, json.Unmarshal(body, &data))
+			assert.Len(t, data, 2)
+		}
+
+		sink, err = events.NewWebhookSink(saconfig.WebhookConfig{
+			URL:      "http://localhost",
+			Template: `{"text": {{ json (printf "%s: %s" .Event.Severity .Event.Index) }}, "count": {{ len .Events }}}`,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err = sink.Body([]events.SinkEvent{connectivity, ovs})
+		if assert.NoError(t, err) {
+			assert.Equal(t, `{"text": "critical: collectd_connectivity", "count": 2}`, string(body))
+		}
+	})
+
+	t.Run("Test sending", func(t *testing.T) {
+		var lock sync.Mutex
+		requests := []map[string]interface{}{}
+		failures := 1
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+			body, _ := ioutil.ReadAll(r.Body)
+			mac := hmac.New(sha256.New, []byte("s3cr3t"))
+			mac.Write(body)
+			assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), r.Header.Get(events.WebhookSignatureHeader))
+			assert.Equal(t, "smart-gateway", r.Header.Get("X-Source"))
+			if failures > 0 {
+				failures--
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			data := []interface{}{}
+			assert.NoError(t, json.Unmarshal(body, &data))
+			requests = append(requests, map[string]interface{}{"events": len(data)})
+		}))
+		defer server.Close()
+
+		sink, err := events.NewWebhookSink(saconfig.WebhookConfig{
+			URL:           server.URL,
+			Headers:       map[string]string{"X-Source": "smart-gateway"},
+			Secret:        "s3cr3t",
+			BatchSize:     2,
+			BatchInterval: 60,
+			Retries:       2,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		sink.Spawn(&wg)
+		sink.Enqueue(connectivity)
+		sink.Enqueue(ovs)
+		sink.Enqueue(ceilometer)
+		// incomplete batch is sent on close
+		sink.Close(time.Now().Add(10 * time.Second))
+		wg.Wait()
+
+		lock.Lock()
+		defer lock.Unlock()
+		assert.Equal(t, 0, failures)
+		assert.Equal(t, []map[string]interface{}{{"events": 2}, {"events": 1}}, requests)
+	})
+
+	t.Run("Test client errors are not retried", func(t *testing.T) {
+		var lock sync.Mutex
+		count := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+			count++
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer server.Close()
+
+		sink, err := events.NewWebhookSink(saconfig.WebhookConfig{URL: server.URL, Retries: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		sink.Spawn(&wg)
+		sink.Enqueue(connectivity)
+		sink.Close(time.Now().Add(10 * time.Second))
+		wg.Wait()
+
+		lock.Lock()
+		defer lock.Unlock()
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("Test queued events are dropped at shutdown deadline", func(t *testing.T) {
+		var lock sync.Mutex
+		count := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+			count++
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		// retries of all events would take minutes
+		sink, err := events.NewWebhookSink(saconfig.WebhookConfig{URL: server.URL, Retries: 5, RetryInterval: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		sink.Spawn(&wg)
+		for i := 0; i < 10; i++ {
+			sink.Enqueue(connectivity)
+		}
+		start := time.Now()
+		sink.Close(start.Add(500 * time.Millisecond))
+		wg.Wait()
+		assert.Less(t, int64(time.Since(start)), int64(2*time.Second))
+
+		lock.Lock()
+		defer lock.Unlock()
+		// waiting for retry of the first request is interrupted by the deadline
+		assert.Equal(t, 1, count)
+	})
+}
+
+//lokiEntry is single entry pushed to Loki stand-in
+type lokiEntry struct {
+	labels string
+	time   time.Time
+	line   string
+}
+
+//decodeLokiJSON returns entries of Loki push request in JSON format
+func decodeLokiJSON(t *testing.T, body []byte) []lokiEntry {
+	request := struct {
+		Streams []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"streams"`
+	}{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		t.Fatal(err)
+	}
+	entries := []lokiEntry{}
+	for _, stream := range request.Streams {
+		labels := fmt.Sprintf("%s/%s/%s", stream.Stream["host"], stream.Stream["index"], stream.Stream["severity"])
+		for _, value := range stream.Values {
+			nanos, err := strconv.ParseInt(value[0], 10, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries = append(entries, lokiEntry{labels: labels, time: time.Unix(0, nanos), line: value[1]})
+		}
+	}
+	return entries
+}
+
+//consumeMessage calls given function for each field of protobuf message
+func consumeMessage(t *testing.T, message []byte, field func(num protowire.Number, value []byte, varint uint64)) {
+	for len(message) > 0 {
+		num, typ, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		message = message[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(message)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			field(num, nil, v)
+			message = message[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(message)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			field(num, v, 0)
+			message = message[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+	}
+}
+
+//decodeLokiProtobuf returns entries of Loki push request in protobuf format
+func decodeLokiProtobuf(t *testing.T, body []byte) []lokiEntry {
+	// snappy block containing literals only
+	length, n := protowire.ConsumeVarint(body)
+	body = body[n:]
+	message := []byte{}
+	for len(body) > 0 {
+		tag := int(body[0] >> 2)
+		switch tag {
+		case 60:
+			tag, body = int(body[1]), body[2:]
+		case 61:
+			tag, body = int(body[1])|int(body[2])<<8, body[3:]
+		default:
+			body = body[1:]
+		}
+		message, body = append(message, body[:tag+1]...), body[tag+1:]
+	}
+	assert.Equal(t, int(length), len(message))
+
+	entries := []lokiEntry{}
+	consumeMessage(t, message, func(_ protowire.Number, stream []byte, _ uint64) {
+		var labels string
+		consumeMessage(t, stream, func(num protowire.Number, value []byte, _ uint64) {
+			if num == 1 {
+				labels = string(value)
+				return
+			}
+			entry := lokiEntry{labels: labels}
+			consumeMessage(t, value, func(num protowire.Number, value []byte, _ uint64) {
+				if num == 2 {
+					entry.line = string(value)
+					return
+				}
+				var seconds, nanos uint64
+				consumeMessage(t, value, func(num protowire.Number, _ []byte, varint uint64) {
+					if num == 1 {
+						seconds = varint
+					} else {
+						nanos = varint
+					}
+				})
+				entry.time = time.Unix(int64(seconds), int64(nanos))
+			})
+			entries = append(entries, entry)
+		})
+	})
+	return entries
+}
+
+func TestLokiSink(t *testing.T) {
+	connectivity := parseSinkEvent(t, saconfig.DataSourceCollectd, connectivityEventData)
+	ovs := parseSinkEvent(t, saconfig.DataSourceCollectd, ovsEventData)
+	ceilometer := parseSinkEvent(t, saconfig.DataSourceCeilometer, ceiloEventData)
+	// the longest event is sent in literal with two bytes of length
+	big := connectivity
+	big.Raw = strings.Repeat("x", 70000)
+
+	t.Run("Test JSON body", func(t *testing.T) {
+		sink := events.NewLokiSink(saconfig.LokiConfig{URL: "http://localhost:3100/loki/api/v1/push"})
+		body, err := sink.JSONBody([]events.SinkEvent{connectivity, ovs, connectivity, ceilometer})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries := decodeLokiJSON(t, body)
+		assert.Equal(t, []string{
+			"d60b3c68f23e/collectd_connectivity/critical",
+			"d60b3c68f23e/collectd_connectivity/critical",
+			"nfvha-comp-03/collectd_ovs_events/info",
+			"compute.host1/ceilometer_compute_create_instance/warning",
+		}, []string{entries[0].labels, entries[1].labels, entries[2].labels, entries[3].labels})
+		assert.Equal(t, connectivity.Raw, entries[0].line)
+		assert.Equal(t, connectivity.Time.UnixNano(), entries[0].time.UnixNano())
+	})
+
+	t.Run("Test protobuf body", func(t *testing.T) {
+		sink := events.NewLokiSink(saconfig.LokiConfig{
+			URL:          "http://localhost:3100/loki/api/v1/push",
+			Format:       "protobuf",
+			Labels:       []string{"severity"},
+			StaticLabels: map[string]string{"source": "smart-gateway"},
+		})
+		entries := decodeLokiProtobuf(t, sink.ProtobufBody([]events.SinkEvent{connectivity, ovs, big}))
+		if assert.Len(t, entries, 3) {
+			assert.Equal(t, `{severity="critical", source="smart-gateway"}`, entries[0].labels)
+			assert.Equal(t, connectivity.Raw, entries[0].line)
+			assert.Equal(t, connectivity.Time.UnixNano(), entries[0].time.UnixNano())
+			assert.Equal(t, `{severity="critical", source="smart-gateway"}`, entries[1].labels)
+			assert.Equal(t, big.Raw, entries[1].line)
+			assert.Equal(t, `{severity="info", source="smart-gateway"}`, entries[2].labels)
+			assert.Equal(t, ovs.Raw, entries[2].line)
+		}
+	})
+
+	t.Run("Test pushing", func(t *testing.T) {
+		var lock sync.Mutex
+		entries := []lokiEntry{}
+		failures := 1
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+			assert.Equal(t, "/loki/api/v1/push", r.URL.Path)
+			assert.Equal(t, "tenant1", r.Header.Get("X-Scope-OrgID"))
+			user, pass, _ := r.BasicAuth()
+			assert.Equal(t, "loki:s3cr3t", user+":"+pass)
+			if failures > 0 {
+				failures--
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			switch r.Header.Get("Content-Type") {
+			case "application/json":
+				entries = append(entries, decodeLokiJSON(t, body)...)
+			case "application/x-protobuf":
+				entries = append(entries, decodeLokiProtobuf(t, body)...)
+			default:
+				t.Errorf("unexpected content type %s", r.Header.Get("Content-Type"))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		for _, format := range []string{"json", "protobuf"} {
+			sink := events.NewLokiSink(saconfig.LokiConfig{
+				URL:      server.URL + "/loki/api/v1/push",
+				Format:   format,
+				TenantID: "tenant1",
+				AuthUser: "loki",
+				AuthPass: "s3cr3t",
+				Retries:  1,
+			})
+			var wg sync.WaitGroup
+			sink.Spawn(&wg)
+			sink.Enqueue(connectivity)
+			sink.Enqueue(ceilometer)
+			sink.Close(time.Now().Add(10 * time.Second))
+			wg.Wait()
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		assert.Equal(t, 0, failures)
+		lines := []string{}
+		for _, entry := range entries {
+			lines = append(lines, entry.line)
+		}
+		assert.Equal(t, []string{connectivity.Raw, ceilometer.Raw, connectivity.Raw, ceilometer.Raw}, lines)
+	})
+}
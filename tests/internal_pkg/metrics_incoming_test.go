@@ -243,3 +243,204 @@ func TestCeilometerGetLabels(t *testing.T) {
 		cm.GetLabels()
 	})
 }
+
+func TestCollectdMapping(t *testing.T) {
+	mappings := []saconfig.CollectdMetricMapping{
+		{
+			Plugin: "cpu",
+			Type:   "percent",
+			Name:   "collectd_cpu_percent",
+			Help:   "CPU usage of ${host} in percent",
+			Labels: map[string]string{"cpu": "${plugin_instance}", "state": "${type_instance}"},
+		},
+		{
+			Plugin:       "interface",
+			Type:         "if_(octets|packets|errors)",
+			TypeInstance: "(?P<direction>rx|tx)_.*",
+			Name:         "collectd_interface_${type}_${dsname}_total",
+			Labels:       map[string]string{"interface": "$plugin_instance", "direction": "$direction"},
+		},
+	}
+	defer incoming.SetCollectdMappings(nil)
+
+	t.Run("Test invalid mappings", func(t *testing.T) {
+		assert.Error(t, incoming.SetCollectdMappings([]saconfig.CollectdMetricMapping{{Plugin: "cpu("}}))
+		assert.Error(t, incoming.SetCollectdMappings([]saconfig.CollectdMetricMapping{{Labels: map[string]string{"in-valid": "x"}}}))
+	})
+
+	if err := incoming.SetCollectdMappings(mappings); err != nil {
+		t.Fatalf("Failed to set collectd mappings: %s", err)
+	}
+
+	t.Run("Test matching mapping", func(t *testing.T) {
+		sample := GenerateSampleCollectdData("hostname", "cpu")
+		sample.Type = "percent"
+		sample.PluginInstance = "0"
+		sample.Dstypes = []string{"gauge"}
+		sample.Dsnames = []string{"value"}
+		assert.Equal(t, "collectd_cpu_percent", sample.GetMetricName(0))
+		assert.Equal(t, "CPU usage of hostname in percent", sample.GetMetricDesc(0))
+		assert.Equal(t, map[string]string{"cpu": "0", "state": "idle", "instance": "hostname"}, sample.GetLabels())
+	})
+
+	t.Run("Test named capture groups", func(t *testing.T) {
+		sample := GenerateSampleCollectdData("hostname", "interface")
+		sample.Type = "if_octets"
+		sample.PluginInstance = "eth0"
+		sample.TypeInstance = "rx_queue1"
+		sample.Dsnames = []string{"rx", "tx"}
+		assert.Equal(t, "collectd_interface_if_octets_tx_total", sample.GetMetricName(1))
+		assert.Equal(t, map[string]string{"interface": "eth0", "direction": "rx", "instance": "hostname"}, sample.GetMetricLabels(1))
+		// TypeInstance not matching the pattern falls back to default scheme
+		sample.TypeInstance = "idle"
+		assert.Equal(t, "collectd_interface_if_octets_rx", sample.GetMetricName(0))
+	})
+
+	t.Run("Test default scheme for unmapped metrics", func(t *testing.T) {
+		sample := GenerateSampleCollectdData("hostname", "pluginname")
+		assert.Equal(t, "collectd_pluginname_collectd_value1", sample.GetMetricName(0))
+		assert.Equal(t, map[string]string{"pluginname": "pluginnameinstance", "type": "idle", "instance": "hostname"}, sample.GetLabels())
+	})
+}
+
+func TestCeilometerMetadataLabelsAndIntervals(t *testing.T) {
+	testDataJSON, err := ioutil.ReadFile("messages/metric-tests.json")
+	if err != nil {
+		t.Fatalf("Failed loading test data: %s\n", err)
+	}
+	tests := make(map[string]jsoniter.RawMessage)
+	if err = json.Unmarshal(testDataJSON, &tests); err != nil {
+		t.Fatalf("error parsing json: %s", err)
+	}
+	testData, err := CeilometerMetricTestTemplateFromJSON(string(tests["CeilometerMetrics"]))
+	if err != nil {
+		t.Fatalf("Failed loading ceilometer metric test data: %s", err)
+	}
+	metrics, err := incoming.NewFromDataSource(saconfig.DataSourceCeilometer).ParseInputJSON(string(testData.TestInput))
+	if err != nil {
+		t.Fatalf("Ceilometer message parsing failed: %s\n", err)
+	}
+	defer incoming.SetCeilometerLabels(nil)
+	defer incoming.SetCeilometerIntervals(nil, 0)
+
+	t.Run("Test metadata labels", func(t *testing.T) {
+		assert.Error(t, incoming.SetCeilometerLabels(map[string]string{"vm-name": "resource_metadata.display_name"}))
+		err := incoming.SetCeilometerLabels(map[string]string{
+			"vm_name":  "resource_metadata.display_name",
+			"flavor":   "resource_metadata.flavor_name",
+			"host":     "resource_metadata.host",
+			"missing":  "resource_metadata.nonexistent",
+			"resource": "resource_metadata.image_ref",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		labels := metrics[0].(*incoming.CeilometerMetric).GetLabels()
+		assert.Equal(t, "new-instance", labels["vm_name"])
+		assert.Equal(t, "m1.tiny", labels["flavor"])
+		assert.Equal(t, "compute-0.redhat.local", labels["host"])
+		assert.NotContains(t, labels, "missing")
+		// built-in labels have precedence
+		assert.Equal(t, "d8bd99b6-6fd8-4c02-a2e3-efbf596df636", labels["resource"])
+	})
+
+	t.Run("Test meter intervals", func(t *testing.T) {
+		assert.Error(t, incoming.SetCeilometerIntervals(map[string]float64{"disk.*": 0}, 0))
+		assert.Error(t, incoming.SetCeilometerIntervals(map[string]float64{"disk.[": 30}, 0))
+		err := incoming.SetCeilometerIntervals(map[string]float64{
+			"disk.*":              300,
+			"disk.ephemeral.*":    600,
+			"disk.ephemeral.size": 900,
+		}, 30)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, 900.0, metrics[0].GetInterval())
+		assert.Equal(t, 300.0, metrics[1].GetInterval())
+		if err = incoming.SetCeilometerIntervals(map[string]float64{"disk.*": 300}, 0); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, 300.0, metrics[0].GetInterval())
+		if err = incoming.SetCeilometerIntervals(nil, 30); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, 30.0, metrics[0].GetInterval())
+	})
+}
+
+func TestUniversalIncoming(t *testing.T) {
+	um := incoming.NewFromDataSource(saconfig.DataSourceUniversal)
+	assert.IsType(t, &incoming.UniversalMetric{}, um)
+	assert.IsType(t, &incoming.UniversalMetric{}, incoming.NewFromDataSourceName("universal"))
+
+	testDataJSON, err := ioutil.ReadFile("messages/universal-metrics.json")
+	if err != nil {
+		t.Fatalf("Failed loading test data: %s\n", err)
+	}
+
+	t.Run("Test parsing of universal message", func(t *testing.T) {
+		metrics, err := um.ParseInputJSON(string(testDataJSON))
+		if err != nil {
+			t.Fatalf("Universal message parsing failed: %s\n", err)
+		}
+		assert.Len(t, metrics, 3)
+
+		counter := metrics[0].(*incoming.UniversalMetric)
+		assert.Equal(t, saconfig.DataSourceUniversal, counter.DataSource)
+		assert.Equal(t, "edge_cpu_seconds_total", counter.GetName())
+		assert.Equal(t, incoming.UniversalCounter, counter.Type)
+		assert.Equal(t, []float64{1234.5}, counter.GetValues())
+		assert.Equal(t, 5.0, counter.GetInterval())
+		assert.Equal(t, "edge-0", counter.GetKey())
+		assert.Equal(t, `edge_cpu_seconds_total{cpu="0",instance="edge-0",mode="idle"}`, counter.GetItemKey())
+		assert.Equal(t, map[string]string{"instance": "edge-0", "cpu": "0", "mode": "idle"}, counter.GetLabels())
+		assert.Equal(t, time.Unix(1583312400, 250000000), counter.GetTime())
+		assert.True(t, counter.ISNew())
+
+		// optional fields are set to defaults
+		gauge := metrics[1].(*incoming.UniversalMetric)
+		assert.Equal(t, incoming.UniversalGauge, gauge.Type)
+		assert.Equal(t, 10.0, gauge.GetInterval())
+		assert.WithinDuration(t, time.Now(), gauge.GetTime(), time.Minute)
+
+		// metrics without instance label are cached under the same host
+		assert.Equal(t, "universal", metrics[2].GetKey())
+		assert.Equal(t, "edge_uplink_up{}", metrics[2].GetItemKey())
+	})
+
+	t.Run("Test invalid universal metrics", func(t *testing.T) {
+		_, err := um.ParseInputJSON(`{"name": "not_a_list", "value": 1}`)
+		assert.Error(t, err)
+
+		metrics, err := um.ParseInputJSON(`[
+	{"name": "valid", "value": 1},
+	{"name": "invalid-name", "value": 1},
+	{"name": "missing_value"},
+	{"name": "invalid_label", "labels": {"in-valid": "x"}, "value": 1},
+	{"name": "invalid_type", "value": 1, "type": "histogram"}
+]`)
+		assert.Len(t, metrics, 1)
+		assert.Equal(t, "valid", metrics[0].GetName())
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "invalid metric name 'invalid-name'")
+			assert.Contains(t, err.Error(), "missing value of metric 'missing_value'")
+			assert.Contains(t, err.Error(), "invalid label name 'in-valid' of metric 'invalid_label'")
+			assert.Contains(t, err.Error(), "invalid type 'histogram' of metric 'invalid_type'")
+		}
+	})
+
+	t.Run("Test universal metric survives marshalling", func(t *testing.T) {
+		metrics, _ := um.ParseInputJSON(string(testDataJSON))
+		data, err := json.Marshal(metrics[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored := incoming.NewFromDataSourceName(metrics[0].GetDataSourceName())
+		if err := json.Unmarshal(data, restored); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, metrics[0].GetItemKey(), restored.GetItemKey())
+		assert.Equal(t, metrics[0].GetValues(), restored.GetValues())
+		assert.Equal(t, metrics[0].(*incoming.UniversalMetric).GetTime(), restored.(*incoming.UniversalMetric).GetTime())
+	})
+}
@@ -0,0 +1,186 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/infrawatch/smart-gateway/internal/pkg/amqp10"
+	"github.com/infrawatch/smart-gateway/internal/pkg/api"
+	"github.com/infrawatch/smart-gateway/internal/pkg/events"
+	"github.com/infrawatch/smart-gateway/internal/pkg/metrics"
+	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
+	"github.com/infrawatch/smart-gateway/internal/pkg/tsdb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	pipelineCollectdMetric = `[{"values":[42],"dstypes":["gauge"],"dsnames":["value"],"time":1583312400.000,"interval":10.000,"host":"compute-0","plugin":"memory","plugin_instance":"","type":"memory","type_instance":"free"}]`
+	pipelineCollectdEvent  = `[{"labels":{"alertname":"collectd_interface_if_octets","instance":"compute-0","interface":"lo","severity":"FAILURE","service":"collectd"},"annotations":{"summary":"interface lo is above the failure threshold"},"startsAt":"2020-03-04T09:00:00.000000000Z"}]`
+)
+
+type eventStoreMock struct {
+	lock      sync.Mutex
+	documents map[string][]interface{}
+}
+
+func (es *eventStoreMock) Create(indexname string, indextype string, jsondata interface{}) (string, error) {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	es.documents[indexname] = append(es.documents[indexname], jsondata)
+	return indexname, nil
+}
+
+func (es *eventStoreMock) count(indexname string) int {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	return len(es.documents[indexname])
+}
+
+func TestLoopbackTransport(t *testing.T) {
+	url := "loopback://test/transport"
+	sender, err := amqp10.NewSender(url, saconfig.KafkaConfig{}, "loopback-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.IsType(t, &amqp10.LoopbackSender{}, sender)
+
+	t.Run("Test send and receive", func(t *testing.T) {
+		// messages are queued until the receiver is created
+		assert.NoError(t, sender.SendSync([]string{"one", "two"}, time.Second))
+		receiver := amqp10.NewLoopbackReceiver(url, nil)
+		defer receiver.Stop()
+		assert.Equal(t, 1, <-receiver.GetStatus())
+		assert.Equal(t, "one", <-receiver.GetNotifier())
+		assert.Equal(t, "two", <-receiver.GetNotifier())
+	})
+
+	t.Run("Test drain", func(t *testing.T) {
+		receiver := amqp10.NewLoopbackReceiver(url, nil)
+		assert.Equal(t, 1, <-receiver.GetStatus())
+		assert.NoError(t, sender.SendSync([]string{QDRMsg}, time.Second))
+		assert.Equal(t, QDRMsg, <-receiver.GetNotifier())
+		receiver.Processed()
+
+		servers := []amqp10.AMQPServerItem{{Server: receiver, DataSource: saconfig.DataSourceCollectd}}
+		pending := amqp10.DrainMessages(servers, time.Second, func(item amqp10.AMQPServerItem, msg string) {
+			t.Errorf("unexpected message %s", msg)
+		})
+		assert.Equal(t, 0, pending)
+		assert.Equal(t, uint64(1), receiver.GetProcessed())
+		_, ok := <-receiver.GetNotifier()
+		assert.False(t, ok)
+
+		// message which was not passed before draining is left for other receivers
+		assert.NoError(t, sender.SendSync([]string{"left"}, time.Second))
+		other := amqp10.NewLoopbackReceiver(url, nil)
+		defer other.Stop()
+		assert.Equal(t, 1, <-other.GetStatus())
+		assert.Equal(t, "left", <-other.GetNotifier())
+	})
+}
+
+func TestMetricsPipeline(t *testing.T) {
+	url := "loopback://test/metrics"
+	config := &saconfig.MetricConfiguration{
+		AMQP1Connections: []saconfig.AMQPConnection{{URL: url, DataSource: "collectd", DataSourceID: saconfig.DataSourceCollectd}},
+		Exporterport:     8081,
+		DataCount:        -1,
+	}
+	loadConfig := func() (*saconfig.MetricConfiguration, error) {
+		return config, nil
+	}
+	var wg sync.WaitGroup
+	finish := make(chan bool)
+	registry := prometheus.NewRegistry()
+	handler := http.NewServeMux()
+	health := metrics.SpawnMetrics(&wg, finish, config, loadConfig, "metrics-pipeline-test", handler, metrics.Dependencies{Registerer: registry})
+	handler.Handle("/metrics", tsdb.NewExpositionHandler(registry))
+
+	sender := amqp10.NewLoopbackSender(url)
+	assert.NoError(t, sender.SendSync([]string{pipelineCollectdMetric}, time.Second))
+	scrape := func(path string) string {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		return recorder.Body.String()
+	}
+	sample := `collectd_memory{instance="compute-0",memory="free",type="base"} 42`
+	// filtered scrapes do not take received metrics away from other scrapers
+	assert.Eventually(t, func() bool {
+		return strings.Contains(scrape("/federate?match[]=collectd_memory"), sample)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Contains(t, scrape("/metrics/host/compute-0"), sample)
+	// metrics of the gateway itself are not related to any host
+	assert.NotContains(t, scrape("/metrics/host/compute-0"), "collectd_qpid_router_status")
+	assert.NotContains(t, scrape("/metrics/host/compute-0"), "collectd_total_amqp_message_recv_count")
+	assert.Contains(t, scrape("/federate?match[]=collectd_qpid_router_status"), "collectd_qpid_router_status")
+	// received metrics are exposed by the first scrape following their arrival
+	exposition := scrape("/metrics")
+	assert.Contains(t, exposition, sample)
+	assert.NotContains(t, scrape("/metrics"), sample)
+	assert.Contains(t, scrape("/federate?match[]=collectd_memory"), sample)
+	assert.Contains(t, exposition, `collectd_last_metric_for_host_status{instance="compute-0"} 1`)
+	assert.Contains(t, exposition, `collectd_total_amqp_message_recv_count{datasource="collectd",source="Metric Consumer",url="loopback://test/metrics"} 1`)
+	assert.True(t, health.Report().Ready)
+
+	close(finish)
+	wg.Wait()
+}
+
+func TestEventsPipeline(t *testing.T) {
+	url, publishURL := "loopback://test/events", "loopback://test/alerts"
+	config := &saconfig.EventConfiguration{
+		AMQP1Connections: []saconfig.AMQPConnection{{URL: url, DataSource: "collectd", DataSourceID: saconfig.DataSourceCollectd}},
+		ElasticHostURL:   "http://127.0.0.1:9200",
+		API:              saconfig.EventAPIConfig{AMQP1PublishURL: publishURL},
+	}
+	loadConfig := func() (*saconfig.EventConfiguration, error) {
+		return config, nil
+	}
+	var wg sync.WaitGroup
+	finish := make(chan bool)
+	store := &eventStoreMock{documents: make(map[string][]interface{})}
+	handler := http.NewServeMux()
+	health := events.SpawnEvents(&wg, finish, config, loadConfig, "events-pipeline-test", handler, events.Dependencies{Store: store, Registerer: prometheus.NewRegistry()})
+
+	t.Run("Test event is stored", func(t *testing.T) {
+		sender := amqp10.NewLoopbackSender(url)
+		assert.NoError(t, sender.SendSync([]string{pipelineCollectdEvent}, time.Second))
+		assert.Eventually(t, func() bool {
+			return store.count("collectd_interface_if") == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		store.lock.Lock()
+		document := store.documents["collectd_interface_if"][0].(map[string]interface{})
+		store.lock.Unlock()
+		assert.Equal(t, "compute-0", document["labels"].(map[string]interface{})["instance"])
+		assert.True(t, health.Report().Ready)
+	})
+
+	t.Run("Test alert is relayed", func(t *testing.T) {
+		receiver := amqp10.NewLoopbackReceiver(publishURL, nil)
+		defer receiver.Stop()
+		assert.Equal(t, 1, <-receiver.GetStatus())
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/alert", strings.NewReader(`{
+	"version": "4",
+	"groupKey": "{}:{alertname=\"HighLoad\"}",
+	"status": "firing",
+	"receiver": "smart-gateway",
+	"alerts": [{"status": "firing", "labels": {"alertname": "HighLoad"}, "startsAt": "2020-03-04T09:00:00Z"}]
+}`)))
+		assert.Equal(t, http.StatusAccepted, recorder.Code)
+		envelope := api.AlertEnvelope{}
+		if err := json.Unmarshal([]byte(<-receiver.GetNotifier()), &envelope); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "firing", envelope.Status)
+		assert.Equal(t, "HighLoad", envelope.Alerts[0].Labels["alertname"])
+	})
+
+	close(finish)
+	wg.Wait()
+}
@@ -1,10 +1,15 @@
 package tests
 
 import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/infrawatch/smart-gateway/internal/pkg/metrics/incoming"
+	"github.com/infrawatch/smart-gateway/internal/pkg/saconfig"
 	"github.com/infrawatch/smart-gateway/internal/pkg/tsdb"
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
@@ -14,11 +19,11 @@ import (
 /*----------------------------- helper functions -----------------------------*/
 
 //GenerateSampleCacheData  ....
-func GenerateCollectdMetric(hostname string, pluginname string, useTimestamp bool, index int) (*incoming.CollectdMetric, prometheus.Metric, dto.Metric) {
+func GenerateCollectdMetric(hostname string, pluginname string, useTimestamp bool, index int) (*incoming.CollectdMetric, prometheus.Metric, *dto.Metric) {
 	sample := GenerateSampleCollectdData(hostname, pluginname)
 	collectdMetric, _ := tsdb.NewPrometheusMetric(useTimestamp, "collectd", sample, index)
-	metric := dto.Metric{}
-	collectdMetric.Write(&metric)
+	metric := &dto.Metric{}
+	collectdMetric.Write(metric)
 	return sample, collectdMetric, metric
 }
 
@@ -63,3 +68,224 @@ func TestCollectdMetric(t *testing.T) {
 		assert.Equal(t, "test_host", metric.GetLabel()[0].GetValue())
 	})
 }
+
+func TestMappedCollectdMetric(t *testing.T) {
+	err := incoming.SetCollectdMappings([]saconfig.CollectdMetricMapping{
+		{Plugin: "pluginname", Name: "collectd_mapped_${dsname}", Labels: map[string]string{"state": "${type_instance}"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer incoming.SetCollectdMappings(nil)
+
+	_, collectdMetric, metric := GenerateCollectdMetric("hostname", "pluginname", false, 1)
+	assert.True(t, strings.HasPrefix(collectdMetric.Desc().String(), "Desc{fqName: \"collectd_mapped_value2\""))
+	labels := map[string]string{}
+	for _, label := range metric.GetLabel() {
+		labels[label.GetName()] = label.GetValue()
+	}
+	assert.Equal(t, map[string]string{"state": "idle", "instance": "hostname"}, labels)
+}
+
+//metricList is unchecked collector exposing given metrics
+type metricList []prometheus.Metric
+
+func (l metricList) Describe(ch chan<- *prometheus.Desc) {}
+
+func (l metricList) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range l {
+		ch <- m
+	}
+}
+
+func TestOpenMetricsExposition(t *testing.T) {
+	err := incoming.SetCollectdMappings([]saconfig.CollectdMetricMapping{
+		{Plugin: "timer", Name: "timer_${dsname}_seconds_total", Unit: "seconds", Labels: map[string]string{}},
+		{Plugin: "clock", Name: "clock_${dsname}_seconds", Unit: "seconds", Labels: map[string]string{}},
+		{Plugin: "other", Unit: "bytes"},
+		{Plugin: "bytes", Name: "transfer_${dsname}_kilo_bytes", Unit: "bytes", Labels: map[string]string{}},
+		{Plugin: "kilobytes", Name: "transfer_${dsname}_kilo_bytes", Unit: "kilo_bytes", Labels: map[string]string{}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer incoming.SetCollectdMappings(nil)
+
+	created := time.Unix(1600000000, 0)
+	counter, err := tsdb.NewPrometheusMetricWithCreated(false, "collectd", GenerateSampleCollectdData("hostname", "timer"), 1, created)
+	assert.NoError(t, err)
+	gauge, err := tsdb.NewPrometheusMetricWithCreated(false, "collectd", GenerateSampleCollectdData("hostname", "clock"), 0, created)
+	assert.NoError(t, err)
+	other, err := tsdb.NewPrometheusMetricWithCreated(false, "collectd", GenerateSampleCollectdData("hostname", "other"), 0, created)
+	assert.NoError(t, err)
+	ceilometer := ceilometerTestMetrics(t)
+	booting, err := tsdb.NewPrometheusMetricWithCreated(false, "ceilometer", ceilometer[2], 0, created)
+	assert.NoError(t, err)
+	memory, err := tsdb.NewPrometheusMetricWithCreated(false, "ceilometer", ceilometer[4], 0, created)
+	assert.NoError(t, err)
+	// units of one metric conflicting between sources are not announced
+	for _, plugin := range []string{"bytes", "kilobytes"} {
+		_, err := tsdb.NewPrometheusMetricWithCreated(false, "collectd", GenerateSampleCollectdData("hostname", plugin), 0, created)
+		assert.NoError(t, err)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(metricList{counter, gauge, other, booting, memory})
+	handler := tsdb.NewExpositionHandler(registry)
+
+	t.Run("Test OpenMetrics format", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.Header.Set("Accept", "application/openmetrics-text;version=1.0.0")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		body := rec.Body.String()
+		assert.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "application/openmetrics-text"))
+		assert.Contains(t, body, "# TYPE timer_value2_seconds counter\n")
+		assert.Contains(t, body, "# UNIT timer_value2_seconds seconds\n")
+		assert.Contains(t, body, "timer_value2_seconds_created{instance=\"hostname\"} 1.6e+09\n")
+		assert.Contains(t, body, "# UNIT clock_value1_seconds seconds\n")
+		assert.NotContains(t, body, "clock_value1_seconds_created")
+		// unit not being suffix of metric name is ignored, names are not changed
+		assert.Contains(t, body, "# TYPE collectd_other_collectd_value1 gauge\n")
+		assert.NotContains(t, body, "# UNIT collectd_other_collectd_value1")
+		// names of Ceilometer meters are not suffixed by their units
+		assert.Contains(t, body, "# TYPE ceilometer_compute_instance_booting_time gauge\n")
+		assert.NotContains(t, body, "# UNIT ceilometer_compute_instance_booting_time")
+		assert.Contains(t, body, "# TYPE ceilometer_memory gauge\n")
+		assert.NotContains(t, body, "# UNIT ceilometer_memory")
+		assert.True(t, strings.HasSuffix(body, "# EOF\n"))
+	})
+
+	t.Run("Test text format", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		body := rec.Body.String()
+		assert.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4"))
+		assert.Contains(t, body, "# TYPE timer_value2_seconds_total counter\n")
+		assert.Contains(t, body, "# TYPE collectd_other_collectd_value1 gauge\n")
+		assert.NotContains(t, body, "# UNIT")
+		assert.NotContains(t, body, "_created")
+	})
+
+	t.Run("Test compression", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+		reader, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.Contains(t, string(body), "# TYPE timer_value2_seconds_total counter\n")
+	})
+
+	t.Run("Test handler instrumentation", func(t *testing.T) {
+		instrumented := tsdb.NewInstrumentedExpositionHandler(registry, registry)
+		instrumented.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/metrics", nil))
+		rec := httptest.NewRecorder()
+		instrumented.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		assert.Contains(t, rec.Body.String(), "promhttp_metric_handler_requests_total{code=\"200\"} 1\n")
+	})
+
+	assert.Equal(t, "seconds", tsdb.GetMetricUnit("timer_value2_seconds_total"))
+	assert.Equal(t, "", tsdb.GetMetricUnit("collectd_other_collectd_value1"))
+	assert.Equal(t, "", tsdb.GetMetricUnit("ceilometer_memory"))
+	assert.Equal(t, "", tsdb.GetMetricUnit("transfer_value1_kilo_bytes"))
+}
+
+//ceilometerTestMetrics returns metrics parsed from Ceilometer test message
+func ceilometerTestMetrics(t *testing.T) []incoming.MetricDataFormat {
+	var tests = make(map[string]CeilometerMetricTestTemplate)
+	testDataJSON, err := ioutil.ReadFile("messages/metric-tests.json")
+	if err != nil {
+		t.Fatalf("Failed loading test data: %s\n", err)
+	}
+	if err := json.Unmarshal(testDataJSON, &tests); err != nil {
+		t.Fatalf("error parsing json: %s", err)
+	}
+	metrics, err := incoming.NewFromDataSource(saconfig.DataSourceCeilometer).ParseInputJSON(string(tests["CeilometerMetrics"].TestInput))
+	if err != nil {
+		t.Fatalf("Ceilometer message parsing failed: %s", err)
+	}
+	return metrics
+}
+
+func TestSelector(t *testing.T) {
+	t.Run("Test invalid selectors", func(t *testing.T) {
+		for _, selector := range []string{"", "{}", "name{", "{instance=host}", "{instance=~\"(\"}", "{instance==\"a\"}"} {
+			_, err := tsdb.ParseSelector(selector)
+			assert.Error(t, err, selector)
+		}
+	})
+
+	t.Run("Test matching", func(t *testing.T) {
+		labels := map[string]string{"instance": "host1", "type": "user"}
+		cases := []struct {
+			selector string
+			matches  bool
+		}{
+			{"collectd_cpu_total", true},
+			{"collectd_cpu", false},
+			{"{__name__=~\"collectd_.*\"}", true},
+			{"collectd_cpu_total{instance=\"host1\"}", true},
+			{"{instance=\"host1\", type!=\"user\"}", false},
+			{"{instance=~\"host[0-9]\",type=~\"user|system\",}", true},
+			{"{instance!~\"host.*\"}", false},
+			{"{plugin=\"\"}", true},
+		}
+		for _, c := range cases {
+			sel, err := tsdb.ParseSelector(c.selector)
+			assert.NoError(t, err)
+			assert.Equal(t, c.matches, sel.Matches("collectd_cpu_total", labels), c.selector)
+		}
+
+		selectors, err := tsdb.ParseSelectors([]string{"collectd_cpu", "{type=\"user\"}"})
+		assert.NoError(t, err)
+		assert.True(t, selectors.Matches("collectd_cpu_total", labels))
+	})
+}
+
+func TestUniversalMetric(t *testing.T) {
+	metrics, err := incoming.NewFromDataSource(saconfig.DataSourceUniversal).ParseInputJSON(`[
+	{"name": "edge_cpu_seconds_total", "labels": {"instance": "edge-0", "cpu": "0"}, "value": 1234.5, "type": "counter", "timestamp": 1583312400},
+	{"name": "edge_memory_free_bytes", "labels": {"instance": "edge-0"}, "value": 524288, "timestamp": 1583312400}
+]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Test counter", func(t *testing.T) {
+		created := time.Unix(1583312000, 0)
+		promMetric, err := tsdb.NewPrometheusMetricWithCreated(true, "universal", metrics[0], 0, created)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.True(t, strings.HasPrefix(promMetric.Desc().String(), "Desc{fqName: \"edge_cpu_seconds_total\""))
+		metric := &dto.Metric{}
+		promMetric.Write(metric)
+		assert.Equal(t, 1234.5, metric.GetCounter().GetValue())
+		assert.Equal(t, created.Unix(), metric.GetCounter().GetCreatedTimestamp().GetSeconds())
+		assert.Equal(t, int64(1583312400000), metric.GetTimestampMs())
+		assert.Len(t, metric.GetLabel(), 2)
+	})
+
+	t.Run("Test gauge", func(t *testing.T) {
+		promMetric, err := tsdb.NewPrometheusMetric(false, "universal", metrics[1], 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		metric := &dto.Metric{}
+		promMetric.Write(metric)
+		assert.Equal(t, 524288.0, metric.GetGauge().GetValue())
+		assert.Equal(t, int64(0), metric.GetTimestampMs())
+	})
+
+	t.Run("Test identity", func(t *testing.T) {
+		name, labels := tsdb.GetMetricIdentity(metrics[0], 0)
+		assert.Equal(t, "edge_cpu_seconds_total", name)
+		assert.Equal(t, map[string]string{"instance": "edge-0", "cpu": "0"}, labels)
+	})
+}